@@ -38,8 +38,53 @@ int LOOP_COUNTER = 0;
 int ANALOG_SLIDER_VALUES[NUM_SLIDERS];
 float DISPLAY_VALUES[NUM_DISPLAYS];
 
+// Buttons and toggle switches, each wired between its pin and ground (i.e. {7, 8, 9}). Leave empty if there are none
+const int BUTTON_PINS[] = {};
+const int NUM_BUTTONS = sizeof(BUTTON_PINS) / sizeof(BUTTON_PINS[0]);
+const unsigned long BUTTON_DEBOUNCE_TIME = 20; // A button's state only counts once it's held this long (ms)
+bool BUTTON_STATES[NUM_BUTTONS];
+bool BUTTON_READINGS[NUM_BUTTONS];
+unsigned long BUTTON_READING_CHANGED_TIME[NUM_BUTTONS];
+
+// Rotary encoders, each wired between its A/B pins and ground (i.e. {10} and {16}). Leave empty if there are none.
+// They're numbered after the sliders (so the first one is NUM_SLIDERS in deej's slider_mapping)
+const int ENCODER_PINS_A[] = {};
+const int ENCODER_PINS_B[] = {};
+const int NUM_ENCODERS = sizeof(ENCODER_PINS_A) / sizeof(ENCODER_PINS_A[0]);
+const int ENCODER_STEPS_PER_DETENT = 4; // Quadrature transitions per click, most encoders go through all 4
+const int MAX_ENCODER_DELTA = 999;      // The most steps a single line can hold
+// Previous A/B reading (2 bits) -> current one (2 bits) = direction, 0 for no change or a missed transition
+const int8_t ENCODER_TRANSITIONS[16] = {0, -1, 1, 0, 1, 0, 0, -1, -1, 0, 0, 1, 0, 1, -1, 0};
+uint8_t ENCODER_READINGS[NUM_ENCODERS];
+int ENCODER_TRANSITION_COUNTS[NUM_ENCODERS];
+int ENCODER_DELTAS[NUM_ENCODERS]; // Steps taken since the last line
+
+// LEDs showing how loud each slider's apps actually are, on PWM pins in slider order (i.e. {3, 5, 6}). Leave empty if there are none
+const int LEVEL_LED_PINS[] = {};
+const int NUM_LEVEL_LEDS = sizeof(LEVEL_LED_PINS) / sizeof(LEVEL_LED_PINS[0]);
+
+// Motorized faders, driven through an H-bridge with one pin raising the slider's value and one lowering it, in slider
+// order (i.e. {4, 7} and {8, 12} for the first two sliders). Leave empty if there are none
+const int MOTOR_PINS_UP[] = {};
+const int MOTOR_PINS_DOWN[] = {};
+const int NUM_MOTORS = sizeof(MOTOR_PINS_UP) / sizeof(MOTOR_PINS_UP[0]);
+const int MOTOR_TOLERANCE = 8;             // Close enough to the target to stop, deej allows for 10
+const unsigned long MOTOR_TIMEOUT = 1500;  // Give up on faders that don't get there (i.e. because they're held), deej waits for 2s
+int MOTOR_TARGETS[NUM_MOTORS];             // -1 while idle
+unsigned long MOTOR_STARTED_TIME[NUM_MOTORS];
+
+// Bump this whenever the serial protocol changes, deej adapts to whatever this firmware announces
+const int PROTOCOL_VERSION = 2;
+// Always there, the rest is only announced if the board has it (see announceDevice)
+const char CAPABILITIES[] = "displays,frames,rle,delta";
+
 const char START_SERIAL_TAG[] = "<<START>>";
 const char END_SERIAL_TAG[] = "<<END>>";
+const char HELLO_SERIAL_TAG[] = "<<HELLO>>";
+const char COMMAND_START_TAG[] = "<<";
+const char COMMAND_END_TAG[] = ">>";
+const char LEVELS_COMMAND_TAG[] = "<<LEVELS|"; // Followed by each slider's level in percent (-1 if unknown), e.g. "<<LEVELS|80|35|-1>>"
+const char MOTOR_COMMAND_TAG[] = "<<M";        // Followed by a slider index and where to move it (0-1023), e.g. "<<M2:512>>"
 boolean isReceiving = false;
 int display_idx = 1;
 int x = 0, y = 0;
@@ -51,10 +96,35 @@ enum State
 {
   WAITING_FOR_START,
   RECEIVING_DATA,
-  WAITING_FOR_END
+  WAITING_FOR_END,
+  RECEIVING_FRAME_HEADER,
+  RECEIVING_FRAME_PAYLOAD,
+  RECEIVING_FRAME_CRC
 };
 State currentState = WAITING_FOR_START;
 
+// Framed display data: magic (2) | type (1) | display index (1) | sequence (1) | length (2) | payload | crc32 (4)
+const uint8_t FRAME_MAGIC[] = {0xDE, 0xE7};
+const uint8_t FRAME_TYPE_IMAGE = 0x01;
+const uint8_t FRAME_TYPE_RLE = 0x02;   // (count, byte) pairs
+const uint8_t FRAME_TYPE_DELTA = 0x03; // (offset (2), length (1), bytes...) runs on top of the display's last frame
+const int FRAME_HEADER_SIZE = 5; // Not counting the magic
+uint8_t frameHeader[FRAME_HEADER_SIZE];
+uint8_t frameCrcBytes[4];
+int frameBytesRead = 0;
+uint16_t framePayloadLength = 0;
+uint32_t frameCrc = 0;
+uint8_t lastReceivedByte = 0;
+unsigned long lastFrameByteTime = 0;
+bool frameRejected = false;
+uint8_t rleCount = 0;
+uint8_t deltaRunHeader[3];
+int deltaRunHeaderRead = 0;
+int deltaRunRemaining = 0;
+// All displays share a single buffer, so deltas only work on the display that was drawn last
+int bufferDisplayIdx = -1;
+const unsigned long FRAME_BYTE_TIMEOUT = 250; // Give up on frames that stall, i.e. because of a damaged length
+
 bool INITIALIZED = false;
 
 Adafruit_SSD1306 display(SCREEN_WIDTH, SCREEN_HEIGHT, &Wire, -1);
@@ -67,6 +137,30 @@ void setup()
     pinMode(ANALOG_INPUTS[i], INPUT);
     ANALOG_SLIDER_VALUES[i] = getSliderValue(ANALOG_INPUTS[i]);
   }
+  for (int i = 0; i < NUM_BUTTONS; i++)
+  {
+    pinMode(BUTTON_PINS[i], INPUT_PULLUP);
+    BUTTON_STATES[i] = BUTTON_READINGS[i] = isButtonPressed(BUTTON_PINS[i]);
+    BUTTON_READING_CHANGED_TIME[i] = millis();
+  }
+  for (int i = 0; i < NUM_LEVEL_LEDS; i++)
+  {
+    pinMode(LEVEL_LED_PINS[i], OUTPUT);
+  }
+  for (int i = 0; i < NUM_MOTORS; i++)
+  {
+    pinMode(MOTOR_PINS_UP[i], OUTPUT);
+    pinMode(MOTOR_PINS_DOWN[i], OUTPUT);
+    stopMotor(i);
+  }
+  for (int i = 0; i < NUM_ENCODERS; i++)
+  {
+    pinMode(ENCODER_PINS_A[i], INPUT_PULLUP);
+    pinMode(ENCODER_PINS_B[i], INPUT_PULLUP);
+    ENCODER_READINGS[i] = readEncoder(i);
+    ENCODER_TRANSITION_COUNTS[i] = 0;
+    ENCODER_DELTAS[i] = 0;
+  }
   Wire.begin();
   for (int i = 0; i < NUM_DISPLAYS; i++)
   {
@@ -74,6 +168,7 @@ void setup()
     initializeDisplay(DISPLAY_SLIDER_MAP[i]);
   }
   Serial.begin(9600);
+  announceDevice();
   INITIALIZED = true;
   delay(1000);
 }
@@ -81,6 +176,7 @@ void setup()
 void loop()
 {
   updateSliderValues();
+  updateButtonStates();
   sendSliderValues(); // Actually send data (all the time)
   recvWithStartEndMarkers();
   // if (INITIALIZED)
@@ -96,7 +192,14 @@ void loop()
   //   }
   // }
   // printSliderValues(); // For debug
-  delay(10);
+
+  // Encoders and motors are looked after while waiting, once a loop would miss most steps and overshoot most targets
+  unsigned long waitStart = millis();
+  while (millis() - waitStart < 10)
+  {
+    pollEncoders();
+    driveMotors();
+  }
 }
 
 void TCA9548A(uint8_t bus)
@@ -121,9 +224,35 @@ void initializeDisplay(int display_idx)
 
 void recvWithStartEndMarkers()
 {
+  if ((currentState == RECEIVING_FRAME_HEADER || currentState == RECEIVING_FRAME_PAYLOAD || currentState == RECEIVING_FRAME_CRC) &&
+      millis() - lastFrameByteTime > FRAME_BYTE_TIMEOUT)
+  {
+    currentState = WAITING_FOR_START;
+  }
+
   while (Serial.available())
   {
     char c = Serial.read();
+
+    if (currentState == RECEIVING_FRAME_HEADER || currentState == RECEIVING_FRAME_PAYLOAD || currentState == RECEIVING_FRAME_CRC)
+    {
+      recvFrameByte(c);
+      continue;
+    }
+
+    if (currentState == WAITING_FOR_START && lastReceivedByte == FRAME_MAGIC[0] && (uint8_t)c == FRAME_MAGIC[1])
+    {
+      currentState = RECEIVING_FRAME_HEADER;
+      lastFrameByteTime = millis();
+      frameBytesRead = 0;
+      frameCrc = 0xFFFFFFFF;
+      lastReceivedByte = 0;
+      memset(buffer, 0, BUFFER_SIZE); // Clear buffer
+      bufferIndex = 0;
+      continue;
+    }
+    lastReceivedByte = c;
+
     if (bufferIndex < BUFFER_SIZE - 1)
     {
       buffer[bufferIndex] = c;
@@ -140,6 +269,14 @@ void recvWithStartEndMarkers()
     {
     case WAITING_FOR_START:
 
+      if (bufferIndex >= 9 && strncmp(buffer + bufferIndex - strlen(HELLO_SERIAL_TAG), HELLO_SERIAL_TAG, strlen(HELLO_SERIAL_TAG)) == 0)
+      {
+        announceDevice();
+        memset(buffer, 0, BUFFER_SIZE); // Clear buffer
+        bufferIndex = 0;
+        break;
+      }
+
       if (bufferIndex >= 9 && strncmp(buffer + bufferIndex - strlen(START_SERIAL_TAG), START_SERIAL_TAG, strlen(START_SERIAL_TAG)) == 0)
       {
         // showText(0, "Receiving", 2);
@@ -149,6 +286,14 @@ void recvWithStartEndMarkers()
         bufferIndex = 0;
         TCA9548A(DISPLAY_SLIDER_MAP[display_idx]);
         // display.clearDisplay();
+        break;
+      }
+
+      if (bufferIndex >= 2 && strncmp(buffer + bufferIndex - strlen(COMMAND_END_TAG), COMMAND_END_TAG, strlen(COMMAND_END_TAG)) == 0)
+      {
+        handleCommand();
+        memset(buffer, 0, BUFFER_SIZE); // Clear buffer
+        bufferIndex = 0;
       }
       break;
 
@@ -156,6 +301,7 @@ void recvWithStartEndMarkers()
       if (bufferIndex >= 7 && strncmp(buffer + bufferIndex - strlen(END_SERIAL_TAG), END_SERIAL_TAG, strlen(END_SERIAL_TAG)) == 0)
       {
         display.display();
+        bufferDisplayIdx = -1;
         currentState = WAITING_FOR_START;
         isReceiving = false;
         memset(buffer, 0, BUFFER_SIZE); // Clear buffer
@@ -184,6 +330,222 @@ void recvWithStartEndMarkers()
   }
 }
 
+// Short commands deej sends about the sliders, i.e. "<<LEVELS|80|35|-1>>". Only the last one in the buffer counts,
+// anything before it is left over from data we didn't understand
+void handleCommand()
+{
+  char *command = NULL;
+  for (char *found = strstr(buffer, COMMAND_START_TAG); found != NULL; found = strstr(found + 1, COMMAND_START_TAG))
+  {
+    command = found;
+  }
+  if (command == NULL)
+  {
+    return;
+  }
+
+  if (strncmp(command, LEVELS_COMMAND_TAG, strlen(LEVELS_COMMAND_TAG)) == 0)
+  {
+    showLevels(command + strlen(LEVELS_COMMAND_TAG));
+  }
+  else if (strncmp(command, MOTOR_COMMAND_TAG, strlen(MOTOR_COMMAND_TAG)) == 0)
+  {
+    int slider_idx, target;
+    if (sscanf(command + strlen(MOTOR_COMMAND_TAG), "%d:%d", &slider_idx, &target) == 2)
+    {
+      moveMotor(slider_idx, target);
+    }
+  }
+}
+
+void showLevels(char *levels)
+{
+  char *level = strtok(levels, "|");
+  for (int i = 0; i < NUM_LEVEL_LEDS && level != NULL; i++)
+  {
+    int percent = atoi(level); // Stops at the end tag
+    analogWrite(LEVEL_LED_PINS[i], percent < 0 ? 0 : map(constrain(percent, 0, 100), 0, 100, 0, 255));
+    level = strtok(NULL, "|");
+  }
+}
+
+void moveMotor(int slider_idx, int target)
+{
+  if (slider_idx < 0 || slider_idx >= NUM_MOTORS)
+  {
+    return;
+  }
+  MOTOR_TARGETS[slider_idx] = constrain(target, 0, 1023);
+  MOTOR_STARTED_TIME[slider_idx] = millis();
+}
+
+void stopMotor(int slider_idx)
+{
+  digitalWrite(MOTOR_PINS_UP[slider_idx], LOW);
+  digitalWrite(MOTOR_PINS_DOWN[slider_idx], LOW);
+  MOTOR_TARGETS[slider_idx] = -1;
+}
+
+void driveMotors()
+{
+  for (int i = 0; i < NUM_MOTORS; i++)
+  {
+    if (MOTOR_TARGETS[i] < 0)
+    {
+      continue;
+    }
+
+    // Targets are in the same terms as the values we send, which may be inverted
+    int difference = MOTOR_TARGETS[i] - getSliderValue(ANALOG_INPUTS[i]);
+    if (abs(difference) <= MOTOR_TOLERANCE || millis() - MOTOR_STARTED_TIME[i] > MOTOR_TIMEOUT)
+    {
+      stopMotor(i);
+      continue;
+    }
+
+    bool raise = (difference > 0) != INVERT_SLIDERS;
+    digitalWrite(MOTOR_PINS_UP[i], raise ? HIGH : LOW);
+    digitalWrite(MOTOR_PINS_DOWN[i], raise ? LOW : HIGH);
+  }
+}
+
+uint32_t crc32Update(uint32_t crc, uint8_t data)
+{
+  crc ^= data;
+  for (int i = 0; i < 8; i++)
+  {
+    crc = (crc >> 1) ^ (0xEDB88320 & -(crc & 1));
+  }
+  return crc;
+}
+
+void recvFrameByte(uint8_t c)
+{
+  lastFrameByteTime = millis();
+
+  switch (currentState)
+  {
+  case RECEIVING_FRAME_HEADER:
+    frameCrc = crc32Update(frameCrc, c);
+    frameHeader[frameBytesRead++] = c;
+    if (frameBytesRead == FRAME_HEADER_SIZE)
+    {
+      framePayloadLength = ((uint16_t)frameHeader[3] << 8) | frameHeader[4];
+      frameBytesRead = 0;
+      deltaRunHeaderRead = 0;
+      deltaRunRemaining = 0;
+      x = 0;
+      y = 0;
+      frameRejected = frameHeader[1] >= NUM_DISPLAYS ||
+                      frameHeader[0] < FRAME_TYPE_IMAGE || frameHeader[0] > FRAME_TYPE_DELTA ||
+                      (frameHeader[0] == FRAME_TYPE_DELTA && bufferDisplayIdx != frameHeader[1]);
+      if (!frameRejected)
+      {
+        TCA9548A(DISPLAY_SLIDER_MAP[frameHeader[1]]);
+      }
+      currentState = framePayloadLength > 0 ? RECEIVING_FRAME_PAYLOAD : RECEIVING_FRAME_CRC;
+    }
+    break;
+
+  case RECEIVING_FRAME_PAYLOAD:
+    frameCrc = crc32Update(frameCrc, c);
+    // Draw as we go, the display buffer is only pushed to the display once the frame checks out
+    if (!frameRejected)
+    {
+      drawFramePayloadByte(c);
+    }
+    if (++frameBytesRead == framePayloadLength)
+    {
+      frameBytesRead = 0;
+      currentState = RECEIVING_FRAME_CRC;
+    }
+    break;
+
+  case RECEIVING_FRAME_CRC:
+    frameCrcBytes[frameBytesRead++] = c;
+    if (frameBytesRead == 4)
+    {
+      uint32_t receivedCrc = ((uint32_t)frameCrcBytes[0] << 24) | ((uint32_t)frameCrcBytes[1] << 16) |
+                             ((uint32_t)frameCrcBytes[2] << 8) | frameCrcBytes[3];
+      bool valid = receivedCrc == (frameCrc ^ 0xFFFFFFFF) && !frameRejected;
+      if (valid)
+      {
+        display.display();
+        bufferDisplayIdx = frameHeader[1];
+      }
+      else if (!frameRejected)
+      {
+        bufferDisplayIdx = -1; // The buffer holds part of a damaged frame
+      }
+      Serial.print(valid ? "ACK|" : "NAK|");
+      Serial.println(frameHeader[2]);
+      currentState = WAITING_FOR_START;
+    }
+    break;
+
+  default:
+    break;
+  }
+}
+
+void drawByte(uint8_t c)
+{
+  for (int bit = 7; bit >= 0; bit--)
+  {
+    display.drawPixel(x, y, (c & (1 << bit)) ? 1 : 0);
+    x++;
+    if (x >= SCREEN_WIDTH)
+    {
+      x = 0;
+      y++;
+    }
+  }
+}
+
+void drawFramePayloadByte(uint8_t c)
+{
+  switch (frameHeader[0])
+  {
+  case FRAME_TYPE_IMAGE:
+    drawByte(c);
+    break;
+
+  case FRAME_TYPE_RLE:
+    if (frameBytesRead % 2 == 0)
+    {
+      rleCount = c;
+    }
+    else
+    {
+      for (int i = 0; i < rleCount; i++)
+      {
+        drawByte(c);
+      }
+    }
+    break;
+
+  case FRAME_TYPE_DELTA:
+    if (deltaRunRemaining == 0)
+    {
+      deltaRunHeader[deltaRunHeaderRead++] = c;
+      if (deltaRunHeaderRead == 3)
+      {
+        int offset = ((int)deltaRunHeader[0] << 8) | deltaRunHeader[1];
+        deltaRunRemaining = deltaRunHeader[2];
+        deltaRunHeaderRead = 0;
+        x = (offset * 8) % SCREEN_WIDTH;
+        y = (offset * 8) / SCREEN_WIDTH;
+      }
+    }
+    else
+    {
+      drawByte(c);
+      deltaRunRemaining--;
+    }
+    break;
+  }
+}
+
 void showVolume(int display_idx, int volume)
 {
   return;
@@ -238,6 +600,55 @@ void updateSliderValues()
   }
 }
 
+bool isButtonPressed(int pin)
+{
+  return digitalRead(pin) == LOW; // Pulled up, so pressing connects it to ground
+}
+
+void updateButtonStates()
+{
+  unsigned long now = millis();
+  for (int i = 0; i < NUM_BUTTONS; i++)
+  {
+    bool reading = isButtonPressed(BUTTON_PINS[i]);
+    if (reading != BUTTON_READINGS[i])
+    {
+      BUTTON_READINGS[i] = reading;
+      BUTTON_READING_CHANGED_TIME[i] = now;
+    }
+    else if (now - BUTTON_READING_CHANGED_TIME[i] >= BUTTON_DEBOUNCE_TIME)
+    {
+      BUTTON_STATES[i] = reading;
+    }
+  }
+}
+
+uint8_t readEncoder(int encoder_idx)
+{
+  return (digitalRead(ENCODER_PINS_A[encoder_idx]) << 1) | digitalRead(ENCODER_PINS_B[encoder_idx]);
+}
+
+void pollEncoders()
+{
+  for (int i = 0; i < NUM_ENCODERS; i++)
+  {
+    uint8_t reading = readEncoder(i);
+    if (reading == ENCODER_READINGS[i])
+    {
+      continue;
+    }
+
+    ENCODER_TRANSITION_COUNTS[i] += ENCODER_TRANSITIONS[(ENCODER_READINGS[i] << 2) | reading];
+    ENCODER_READINGS[i] = reading;
+
+    if (abs(ENCODER_TRANSITION_COUNTS[i]) >= ENCODER_STEPS_PER_DETENT)
+    {
+      ENCODER_DELTAS[i] += ENCODER_TRANSITION_COUNTS[i] > 0 ? 1 : -1;
+      ENCODER_TRANSITION_COUNTS[i] = 0;
+    }
+  }
+}
+
 bool almostEquals(float a, float b)
 {
   return fabs(a - b) < 0.5; // Using a small threshold to determine equality
@@ -275,6 +686,36 @@ bool significantlyDifferent(float oldVal, float newVal, const char *noiseReducti
   return false;
 }
 
+// Tells deej what this board can do, on boot and whenever deej asks for it
+void announceDevice()
+{
+  String capabilities = String(CAPABILITIES);
+  if (NUM_BUTTONS > 0)
+  {
+    capabilities += String(",buttons");
+  }
+  if (NUM_ENCODERS > 0)
+  {
+    capabilities += String(",encoders");
+  }
+  if (NUM_LEVEL_LEDS > 0)
+  {
+    capabilities += String(",levels");
+  }
+  if (NUM_MOTORS > 0)
+  {
+    capabilities += String(",motors");
+  }
+
+  String announcement = String("DEEJ|version=") + String(PROTOCOL_VERSION) +
+                        String("|sliders=") + String(NUM_SLIDERS) +
+                        String("|displays=") + String(NUM_DISPLAYS) +
+                        String("|resolution=") + String(SCREEN_WIDTH) + String("x") + String(SCREEN_HEIGHT) +
+                        String("|capabilities=") + capabilities;
+
+  Serial.println(announcement);
+}
+
 void sendSliderValues()
 {
   String builtString = String("");
@@ -289,6 +730,29 @@ void sendSliderValues()
     }
   }
 
+  // Encoders that were turned report their own index and steps, e.g. "E5:+3"
+  for (int i = 0; i < NUM_ENCODERS; i++)
+  {
+    int delta = constrain(ENCODER_DELTAS[i], -MAX_ENCODER_DELTA, MAX_ENCODER_DELTA);
+    if (delta == 0)
+    {
+      continue;
+    }
+
+    builtString += String("|E") + String(NUM_SLIDERS + i) + String(delta > 0 ? ":+" : ":") + String(delta);
+    ENCODER_DELTAS[i] -= delta;
+  }
+
+  // All buttons go in a single segment at the end, e.g. "B:0100" when only the second one is pressed
+  if (NUM_BUTTONS > 0)
+  {
+    builtString += String("|B:");
+    for (int i = 0; i < NUM_BUTTONS; i++)
+    {
+      builtString += BUTTON_STATES[i] ? String("1") : String("0");
+    }
+  }
+
   Serial.println(builtString);
 }
 
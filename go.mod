@@ -3,6 +3,7 @@ module github.com/omriharel/deej
 go 1.14
 
 require (
+	github.com/BurntSushi/xgb v0.0.0-20200324125942-20f126ea2843
 	github.com/fcjr/geticon v0.1.1
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gen2brain/beeep v0.0.0-20200420150314-13046a26d502
@@ -20,8 +21,11 @@ require (
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/shirou/gopsutil/v3 v3.23.9
 	github.com/spf13/viper v1.7.1
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef
 	github.com/thoas/go-funk v0.7.0
 	go.uber.org/zap v1.15.0
-	golang.org/x/image v0.0.0-20200801110659-972c09e46d76
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 	golang.org/x/sys v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,162 @@
+package deej
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// buttonHandler performs the configured actions whenever a button or switch on the board is used
+type buttonHandler struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	// actions are performed by their own goroutine, in the order they came in. some of them (like refreshing
+	// sessions) take a while, and button events are delivered from the goroutine that reads slider values
+	actions chan string
+}
+
+const (
+
+	// actions are written as "<action>" or "<action>:<argument>"
+	buttonActionArgumentSeparator = ":"
+
+	// toggles mute for every target bound to a slider, e.g. "mute:1"
+	buttonActionMute = "mute"
+
+	// simulates a media key press, e.g. "media:play_pause"
+	buttonActionMedia = "media"
+
+	// re-acquires all audio sessions, same as the tray menu option
+	buttonActionRefreshSessions = "refresh_sessions"
+
+	// switches to the given profile, e.g. "profile:gaming", or cycles through all of them
+	buttonActionProfile     = "profile"
+	buttonActionNextProfile = "next_profile"
+
+	// actions beyond this many are dropped until the ones before them are done
+	buttonActionQueueSize = 16
+)
+
+func newButtonHandler(deej *Deej, logger *zap.SugaredLogger) (*buttonHandler, error) {
+	logger = logger.Named("buttons")
+
+	bh := &buttonHandler{
+		deej:    deej,
+		logger:  logger,
+		actions: make(chan string, buttonActionQueueSize),
+	}
+
+	logger.Debug("Created button handler instance")
+
+	return bh, nil
+}
+
+func (bh *buttonHandler) initialize() {
+	bh.setupActionRunner()
+	bh.setupOnButtonEvent()
+}
+
+func (bh *buttonHandler) setupActionRunner() {
+	go func() {
+		for action := range bh.actions {
+			bh.performAction(action)
+		}
+	}()
+}
+
+func (bh *buttonHandler) setupOnButtonEvent() {
+	buttonEventsChannel := bh.deej.serial.SubscribeToButtonEvents()
+
+	go func() {
+		for {
+			select {
+			case event := <-buttonEventsChannel:
+				bh.handleButtonEvent(event)
+			}
+		}
+	}()
+}
+
+func (bh *buttonHandler) handleButtonEvent(event ButtonEvent) {
+
+	// momentary buttons act when pressed, while toggle switches act whenever they're flipped (either way)
+	isSwitch := funk.ContainsInt(bh.deej.config.toggleSwitches(), event.ButtonID)
+	if !isSwitch && !event.Pressed {
+		return
+	}
+
+	// get the actions mapped to this button from the config
+	actions, ok := bh.deej.config.buttonMapping().get(event.ButtonID)
+
+	// if button not found in config, silently ignore
+	if !ok {
+		return
+	}
+
+	for _, action := range actions {
+		bh.queueAction(strings.ToLower(action))
+	}
+}
+
+func (bh *buttonHandler) queueAction(action string) {
+	select {
+	case bh.actions <- action:
+	default:
+		bh.logger.Warnw("Too many button actions pending, dropping action", "action", action)
+	}
+}
+
+func (bh *buttonHandler) performAction(action string) {
+	actionName, argument := splitButtonAction(action)
+
+	bh.logger.Debugw("Performing button action", "action", actionName, "argument", argument)
+
+	switch actionName {
+
+	case buttonActionMute:
+		sliderIdx, err := strconv.Atoi(argument)
+		if err != nil {
+			bh.logger.Warnw("Invalid slider index for mute action", "action", action, "error", err)
+			return
+		}
+
+		bh.deej.sessions.toggleMute(sliderIdx)
+
+	case buttonActionMedia:
+		if err := util.SendMediaKey(argument); err != nil {
+			bh.logger.Warnw("Failed to send media key", "action", action, "error", err)
+		}
+
+	case buttonActionRefreshSessions:
+
+		// performance: a person pressing a button can't do it at a rate that's meaningful to performance
+		bh.deej.sessions.refreshSessions(true)
+
+	case buttonActionProfile:
+		if err := bh.deej.config.SwitchProfile(argument); err != nil {
+			bh.logger.Warnw("Failed to switch profile", "action", action, "error", err)
+		}
+
+	case buttonActionNextProfile:
+		if err := bh.deej.config.NextProfile(); err != nil {
+			bh.logger.Warnw("Failed to switch to next profile", "action", action, "error", err)
+		}
+
+	default:
+		bh.logger.Warnw("Unknown button action, ignoring", "action", action)
+	}
+}
+
+// splitButtonAction splits an action into its name and argument (which is empty for actions that don't have one)
+func splitButtonAction(action string) (string, string) {
+	if separatorIdx := strings.Index(action, buttonActionArgumentSeparator); separatorIdx != -1 {
+		return action[:separatorIdx], action[separatorIdx+len(buttonActionArgumentSeparator):]
+	}
+
+	return action, ""
+}
@@ -1,13 +1,17 @@
 package deej
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	"github.com/thoas/go-funk"
 	"go.uber.org/zap"
 
 	"github.com/omriharel/deej/pkg/deej/util"
@@ -16,25 +20,70 @@ import (
 // CanonicalConfig provides application-wide access to configuration fields,
 // as well as loading/file watching logic for deej's configuration file
 type CanonicalConfig struct {
+
+	// the slider mapping, inverted sliders, slider curves and display config depend on the active profile,
+	// and are guarded by profileLock (see profiles.go)
 	SliderMapping *sliderMap
 
-	ConnectionInfo struct {
-		COMPort  string
-		BaudRate int
-	}
+	ConnectionInfo ConnectionInfo
 
 	InvertSliders bool
 
+	// how each slider's position maps to the volume it sets, by slider index (see sliderCurve)
+	SliderCurves map[int]*sliderCurve
+
+	// when set, a slider whose volume was changed elsewhere only takes over again once it crosses that volume
+	SoftTakeover bool
+
+	// the profile whose settings are in effect, and the names of all of them (see profiles.go)
+	activeProfile string
+	profileNames  []string
+	profileLock   sync.Mutex
+
+	// rules for switching profiles automatically, most important first, and the profile to use when none apply
+	ProfileRules    []*profileRule
+	FallbackProfile string
+
+	EncoderConfig struct {
+		Step         float64
+		Acceleration float64
+	}
+
+	// button mappings share the slider mapping's structure (index -> list of actions)
+	ButtonMapping  *sliderMap
+	ToggleSwitches []int
+
+	// which sound server to talk to on linux. only read once, at startup
+	AudioBackend string
+
 	NoiseReductionLevel string
 	DisplayConfig       *DisplayConfig
 	logger              *zap.SugaredLogger
 	notifier            Notifier
 	stopWatcherChannel  chan bool
 
-	reloadConsumers []chan bool
+	reloadConsumers     []chan bool
+	reloadConsumersLock sync.Mutex
 
+	// the user config in effect. it's replaced as a whole on every successful load, and only then
 	userConfig     *viper.Viper
 	internalConfig *viper.Viper
+
+	// watches the user config file. viper re-reads the file into the instance that watches it before telling us,
+	// which is why that's never the one in effect (or a broken file would be, as soon as a profile is switched)
+	userConfigWatcher *viper.Viper
+}
+
+// ConnectionInfo holds the parameters needed to connect to the board
+type ConnectionInfo struct {
+	Type     string
+	COMPort  string
+	BaudRate int
+	Address  string
+
+	// only used to narrow down the search when COMPort is set to auto
+	USBVendorID  string
+	USBProductID string
 }
 
 const (
@@ -50,15 +99,32 @@ const (
 
 	configKeySliderMapping                = "slider_mapping"
 	configKeyInvertSliders                = "invert_sliders"
+	configKeySliderCurves                 = "slider_curves"
+	configKeySoftTakeover                 = "soft_takeover"
+	configKeyEncoderStep                  = "encoder_step"
+	configKeyEncoderAcceleration          = "encoder_acceleration"
+	configKeyButtonMapping                = "button_mapping"
+	configKeyToggleSwitches               = "toggle_switches"
+	configKeyConnectionType               = "connection_type"
+	configKeyConnectionAddress            = "connection_address"
 	configKeyCOMPort                      = "com_port"
 	configKeyBaudRate                     = "baud_rate"
+	configKeyUSBVendorID                  = "usb_vid"
+	configKeyUSBProductID                 = "usb_pid"
 	configKeyNoiseReductionLevel          = "noise_reduction"
 	configKeyDisplayConfig                = "display_config"
 	configKeyDisplayConfigEnabled         = "display_config.enabled"
 	configKeyDisplayConfigDitherThreshold = "display_config.dither_threshold"
 	configKeyDisplayConfigDisplayMapping  = "display_config.display_mapping"
+	configKeyDisplayConfigLegacyProtocol  = "display_config.legacy_protocol"
+	configKeyDisplayConfigVolumeOverlay   = "display_config.volume_overlay"
+	configKeyAudioBackend                 = "audio_backend"
+	defaultConnectionType                 = transportTypeSerial
 	defaultCOMPort                        = "COM4"
 	defaultBaudRate                       = 9600
+	defaultEncoderStep                    = 0.02
+	defaultEncoderAcceleration            = 1.0
+	defaultAudioBackend                   = audioBackendPulseAudio
 )
 
 // has to be defined as a non-constant because we're using path.Join
@@ -83,11 +149,28 @@ func NewConfig(logger *zap.SugaredLogger, notifier Notifier) (*CanonicalConfig,
 	cc := &CanonicalConfig{
 		logger:             logger,
 		notifier:           notifier,
+		activeProfile:      defaultProfileName,
 		reloadConsumers:    []chan bool{},
 		stopWatcherChannel: make(chan bool),
 	}
 
 	// distinguish between the user-provided config (config.yaml) and the internal config (logs/preferences.yaml)
+	internalConfig := viper.New()
+	internalConfig.SetConfigName(internalConfigName)
+	internalConfig.SetConfigType(configType)
+	internalConfig.AddConfigPath(internalConfigPath)
+
+	cc.userConfig = newUserConfig()
+	cc.userConfigWatcher = newUserConfig()
+	cc.internalConfig = internalConfig
+
+	logger.Debug("Created config instance")
+
+	return cc, nil
+}
+
+// newUserConfig creates a viper instance for the user config, with its defaults set
+func newUserConfig() *viper.Viper {
 	userConfig := viper.New()
 	userConfig.SetConfigName(userConfigName)
 	userConfig.SetConfigType(configType)
@@ -95,21 +178,25 @@ func NewConfig(logger *zap.SugaredLogger, notifier Notifier) (*CanonicalConfig,
 
 	userConfig.SetDefault(configKeySliderMapping, map[string][]string{})
 	userConfig.SetDefault(configKeyInvertSliders, false)
+	userConfig.SetDefault(configKeySliderCurves, map[string]interface{}{})
+	userConfig.SetDefault(configKeySoftTakeover, false)
+	userConfig.SetDefault(configKeyEncoderStep, defaultEncoderStep)
+	userConfig.SetDefault(configKeyEncoderAcceleration, defaultEncoderAcceleration)
+	userConfig.SetDefault(configKeyButtonMapping, map[string][]string{})
+	userConfig.SetDefault(configKeyToggleSwitches, []int{})
+	userConfig.SetDefault(configKeyConnectionType, defaultConnectionType)
+	userConfig.SetDefault(configKeyConnectionAddress, "")
 	userConfig.SetDefault(configKeyCOMPort, defaultCOMPort)
 	userConfig.SetDefault(configKeyBaudRate, defaultBaudRate)
+	userConfig.SetDefault(configKeyUSBVendorID, "")
+	userConfig.SetDefault(configKeyUSBProductID, "")
 	userConfig.SetDefault(configKeyDisplayConfig, defaultDisplayConfig)
+	userConfig.SetDefault(configKeyAudioBackend, defaultAudioBackend)
+	userConfig.SetDefault(configKeyProfiles, map[string]interface{}{})
+	userConfig.SetDefault(configKeyProfileRules, []interface{}{})
+	userConfig.SetDefault(configKeyFallbackProfile, defaultProfileName)
 
-	internalConfig := viper.New()
-	internalConfig.SetConfigName(internalConfigName)
-	internalConfig.SetConfigType(configType)
-	internalConfig.AddConfigPath(internalConfigPath)
-
-	cc.userConfig = userConfig
-	cc.internalConfig = internalConfig
-
-	logger.Debug("Created config instance")
-
-	return cc, nil
+	return userConfig
 }
 
 // Load reads deej's config files from disk and tries to parse them
@@ -125,8 +212,23 @@ func (cc *CanonicalConfig) Load() error {
 		return fmt.Errorf("config file doesn't exist: %s", userConfigFilepath)
 	}
 
-	// load the user config
-	if err := cc.userConfig.ReadInConfig(); err != nil {
+	data, err := ioutil.ReadFile(userConfigFilepath)
+	if err != nil {
+		cc.logger.Warnw("Failed to read user config", "error", err)
+		cc.notifier.Notify("Error loading configuration!", "Please check deej's logs for more details.")
+
+		return fmt.Errorf("read user config file: %w", err)
+	}
+
+	// check it before viper reads it, so that a broken config never replaces the one in effect
+	if problems := validateConfig(data); len(problems) > 0 {
+		cc.notifyConfigProblems(problems)
+		return fmt.Errorf("invalid user config: %d problem(s) found", len(problems))
+	}
+
+	// load the user config into a new viper instance, which only takes the current one's place once it's populated
+	userConfig := newUserConfig()
+	if err := userConfig.ReadConfig(bytes.NewReader(data)); err != nil {
 		cc.logger.Warnw("Viper failed to read user config", "error", err)
 
 		// if the error is yaml-format-related, show a sensible error. otherwise, show 'em to the logs
@@ -146,24 +248,56 @@ func (cc *CanonicalConfig) Load() error {
 	}
 
 	// canonize the configuration with viper's helpers
-	if err := cc.populateFromVipers(); err != nil {
+	cc.profileLock.Lock()
+
+	previousUserConfig := cc.userConfig
+	cc.userConfig = userConfig
+
+	if err = cc.populateFromVipers(); err != nil {
+
+		// put the previous settings back into effect
+		cc.userConfig = previousUserConfig
+		cc.populateFromVipers()
+	}
+
+	cc.profileLock.Unlock()
+
+	if err != nil {
 		cc.logger.Warnw("Failed to populate config fields", "error", err)
 		return fmt.Errorf("populate config fields: %w", err)
 	}
 
+	profileRules, fallbackProfile := cc.profileRules()
+	encoderStep, encoderAcceleration := cc.encoderConfig()
+
 	cc.logger.Info("Loaded config successfully")
 	cc.logger.Infow("Config values",
-		"sliderMapping", cc.SliderMapping,
+		"profiles", cc.ProfileNames(),
+		"activeProfile", cc.ActiveProfile(),
+		"profileRules", profileRules,
+		"fallbackProfile", fallbackProfile,
+		"sliderMapping", cc.sliderMapping(),
 		"connectionInfo", cc.ConnectionInfo,
-		"invertSliders", cc.InvertSliders,
-		"displayConfig", cc.DisplayConfig)
+		"invertSliders", cc.invertSliders(),
+		"sliderCurves", cc.sliderCurves(),
+		"softTakeover", cc.softTakeover(),
+		"encoderStep", encoderStep,
+		"encoderAcceleration", encoderAcceleration,
+		"buttonMapping", cc.buttonMapping(),
+		"toggleSwitches", cc.toggleSwitches(),
+		"displayConfig", cc.displayConfig(),
+		"audioBackend", cc.AudioBackend)
 	return nil
 }
 
-// SubscribeToChanges allows external components to receive updates when the config is reloaded
+// SubscribeToChanges allows external components to receive updates when the config is reloaded.
+// the channel holds a single pending update, so reloads that happen before it's read are only seen once
 func (cc *CanonicalConfig) SubscribeToChanges() chan bool {
-	c := make(chan bool)
+	c := make(chan bool, 1)
+
+	cc.reloadConsumersLock.Lock()
 	cc.reloadConsumers = append(cc.reloadConsumers, c)
+	cc.reloadConsumersLock.Unlock()
 
 	return c
 }
@@ -181,8 +315,8 @@ func (cc *CanonicalConfig) WatchConfigFileChanges() {
 	lastAttemptedReload := time.Now()
 
 	// establish watch using viper as opposed to doing it ourselves, though our internal cooldown is still required
-	cc.userConfig.WatchConfig()
-	cc.userConfig.OnConfigChange(func(event fsnotify.Event) {
+	cc.userConfigWatcher.WatchConfig()
+	cc.userConfigWatcher.OnConfigChange(func(event fsnotify.Event) {
 
 		// when we get a write event...
 		if event.Op&fsnotify.Write == fsnotify.Write {
@@ -216,7 +350,7 @@ func (cc *CanonicalConfig) WatchConfigFileChanges() {
 	// wait till they stop us
 	<-cc.stopWatcherChannel
 	cc.logger.Debug("Stopping user config file watcher")
-	cc.userConfig.OnConfigChange(nil)
+	cc.userConfigWatcher.OnConfigChange(nil)
 }
 
 // StopWatchingConfigFile signals our filesystem watcher to stop
@@ -224,16 +358,69 @@ func (cc *CanonicalConfig) StopWatchingConfigFile() {
 	cc.stopWatcherChannel <- true
 }
 
+// the fields below are replaced whenever the config is reloaded, which happens with the profile lock held
+// (see Load). anything that runs alongside reloads reads them through these
+
+func (cc *CanonicalConfig) softTakeover() bool {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.SoftTakeover
+}
+
+// encoderConfig returns the step and the acceleration of encoders
+func (cc *CanonicalConfig) encoderConfig() (float64, float64) {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.EncoderConfig.Step, cc.EncoderConfig.Acceleration
+}
+
+func (cc *CanonicalConfig) buttonMapping() *sliderMap {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.ButtonMapping
+}
+
+func (cc *CanonicalConfig) toggleSwitches() []int {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.ToggleSwitches
+}
+
+// populateFromVipers expects to be called with the profile lock held, since some fields depend on the active profile
 func (cc *CanonicalConfig) populateFromVipers() error {
+	cc.populateProfiles()
 
-	// merge the slider mappings from the user and internal configs
-	cc.SliderMapping = sliderMapFromConfigs(
-		cc.userConfig.GetStringMapStringSlice(configKeySliderMapping),
-		cc.internalConfig.GetStringMapStringSlice(configKeySliderMapping),
-	)
+	cc.ProfileRules = profileRulesFromConfig(cc.logger, cc.userConfig.Get(configKeyProfileRules), cc.profileNames)
+
+	cc.FallbackProfile = strings.ToLower(cc.userConfig.GetString(configKeyFallbackProfile))
+	if !funk.ContainsString(cc.profileNames, cc.FallbackProfile) {
+		cc.logger.Warnw("Invalid fallback profile specified, using default value",
+			"key", configKeyFallbackProfile,
+			"invalidValue", cc.FallbackProfile,
+			"defaultValue", defaultProfileName)
+
+		cc.FallbackProfile = defaultProfileName
+	}
 
 	// get the rest of the config fields - viper saves us a lot of effort here
+	cc.ConnectionInfo.Type = strings.ToLower(cc.userConfig.GetString(configKeyConnectionType))
+	if !funk.ContainsString(supportedTransportTypes, cc.ConnectionInfo.Type) {
+		cc.logger.Warnw("Invalid connection type specified, using default value",
+			"key", configKeyConnectionType,
+			"invalidValue", cc.ConnectionInfo.Type,
+			"defaultValue", defaultConnectionType)
+
+		cc.ConnectionInfo.Type = defaultConnectionType
+	}
+
+	cc.ConnectionInfo.Address = cc.userConfig.GetString(configKeyConnectionAddress)
 	cc.ConnectionInfo.COMPort = cc.userConfig.GetString(configKeyCOMPort)
+	cc.ConnectionInfo.USBVendorID = strings.ToLower(cc.userConfig.GetString(configKeyUSBVendorID))
+	cc.ConnectionInfo.USBProductID = strings.ToLower(cc.userConfig.GetString(configKeyUSBProductID))
 
 	cc.ConnectionInfo.BaudRate = cc.userConfig.GetInt(configKeyBaudRate)
 	if cc.ConnectionInfo.BaudRate <= 0 {
@@ -245,29 +432,103 @@ func (cc *CanonicalConfig) populateFromVipers() error {
 		cc.ConnectionInfo.BaudRate = defaultBaudRate
 	}
 
-	cc.InvertSliders = cc.userConfig.GetBool(configKeyInvertSliders)
+	cc.SoftTakeover = cc.userConfig.GetBool(configKeySoftTakeover)
+
+	cc.EncoderConfig.Step = cc.userConfig.GetFloat64(configKeyEncoderStep)
+	if cc.EncoderConfig.Step <= 0 || cc.EncoderConfig.Step > 1 {
+		cc.logger.Warnw("Invalid encoder step specified, using default value",
+			"key", configKeyEncoderStep,
+			"invalidValue", cc.EncoderConfig.Step,
+			"defaultValue", defaultEncoderStep)
+
+		cc.EncoderConfig.Step = defaultEncoderStep
+	}
+
+	cc.EncoderConfig.Acceleration = cc.userConfig.GetFloat64(configKeyEncoderAcceleration)
+	if cc.EncoderConfig.Acceleration < 1 {
+		cc.logger.Warnw("Invalid encoder acceleration specified, using default value",
+			"key", configKeyEncoderAcceleration,
+			"invalidValue", cc.EncoderConfig.Acceleration,
+			"defaultValue", defaultEncoderAcceleration)
+
+		cc.EncoderConfig.Acceleration = defaultEncoderAcceleration
+	}
+
+	// buttons can only be mapped through the user config
+	cc.ButtonMapping = sliderMapFromConfigs(
+		cc.userConfig.GetStringMapStringSlice(configKeyButtonMapping),
+		map[string][]string{},
+	)
+
+	cc.ToggleSwitches = cc.userConfig.GetIntSlice(configKeyToggleSwitches)
 	cc.NoiseReductionLevel = cc.userConfig.GetString(configKeyNoiseReductionLevel)
 
+	cc.AudioBackend = strings.ToLower(cc.userConfig.GetString(configKeyAudioBackend))
+	if !funk.ContainsString(supportedAudioBackends, cc.AudioBackend) {
+		cc.logger.Warnw("Invalid audio backend specified, using default value",
+			"key", configKeyAudioBackend,
+			"invalidValue", cc.AudioBackend,
+			"defaultValue", defaultAudioBackend)
+
+		cc.AudioBackend = defaultAudioBackend
+	}
+
 	// Populate DisplayConfig from the config
 	displayConfig := newDisplayConfig()
 	displayConfig.Enabled = cc.userConfig.GetBool(configKeyDisplayConfigEnabled)
 	displayConfig.DitherThreshold = cc.userConfig.GetInt(configKeyDisplayConfigDitherThreshold)
-	displayConfig.DisplayMapping = createDisplayMapFromConfig(cc.userConfig.GetStringMapStringSlice(configKeyDisplayConfigDisplayMapping), cc.SliderMapping)
+	displayConfig.LegacyProtocol = cc.userConfig.GetBool(configKeyDisplayConfigLegacyProtocol)
+
+	if volumeOverlay := strings.ToLower(cc.userConfig.GetString(configKeyDisplayConfigVolumeOverlay)); volumeOverlay != "" {
+		if funk.ContainsString(supportedVolumeOverlays, volumeOverlay) {
+			displayConfig.VolumeOverlay = volumeOverlay
+		} else {
+			cc.logger.Warnw("Invalid volume overlay specified, using default value",
+				"key", configKeyDisplayConfigVolumeOverlay,
+				"invalidValue", volumeOverlay,
+				"defaultValue", displayConfig.VolumeOverlay)
+		}
+	}
+
 	// if err := cc.userConfig.UnmarshalKey(configKeyDisplayConfig, displayConfig); err != nil {
 	// 	cc.logger.Warnw("Failed to unmarshal display config", "error", err)
 	// 	return err
 	// }
 	cc.DisplayConfig = displayConfig
 
+	// the slider mapping (and everything else a profile can override) comes from the active profile
+	cc.populateProfileFields()
+
 	cc.logger.Debug("Populated config fields from vipers")
 
 	return nil
 }
 
+// target returns whatever the user would recognize as the board's location: a COM port, or an address
+func (ci ConnectionInfo) target() string {
+	if ci.Type == transportTypeSerial {
+		if strings.EqualFold(ci.COMPort, comPortAuto) {
+			return "your deej board"
+		}
+
+		return ci.COMPort
+	}
+
+	return ci.Address
+}
+
 func (cc *CanonicalConfig) onConfigReloaded() {
 	cc.logger.Debug("Notifying consumers about configuration reload")
 
+	cc.reloadConsumersLock.Lock()
+	defer cc.reloadConsumersLock.Unlock()
+
+	// never wait on consumers: one that's already got an update pending will see this reload too,
+	// and one that stopped listening (i.e. the display watcher, once interrupted) mustn't hold up everyone else
 	for _, consumer := range cc.reloadConsumers {
-		consumer <- true
+		select {
+		case consumer <- true:
+		default:
+		}
 	}
 }
@@ -0,0 +1,730 @@
+package deej
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/thoas/go-funk"
+	"gopkg.in/yaml.v3"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// the user config is checked before viper gets to read it, so that mistakes in it are pointed out (with the key
+// and line they're on) instead of quietly turning into defaults. a config with any problems isn't loaded at all,
+// which keeps whatever was loaded before it in effect
+
+// configProblem is one thing wrong with the config file
+type configProblem struct {
+	key     string
+	line    int
+	message string
+}
+
+const (
+
+	// how many problems the notification lists, the rest are only in the logs
+	notifiedConfigProblems = 3
+
+	// unknown keys this close to a known one (in edits) are probably typos of it
+	maxConfigKeyTypoDistance = 2
+)
+
+// every key that can be at the top of the config file
+var topLevelConfigKeys = []string{
+	configKeySliderMapping,
+	configKeyInvertSliders,
+	configKeySliderCurves,
+	configKeySoftTakeover,
+	configKeyProfiles,
+	configKeyProfileRules,
+	configKeyFallbackProfile,
+	configKeyEncoderStep,
+	configKeyEncoderAcceleration,
+	configKeyButtonMapping,
+	configKeyToggleSwitches,
+	configKeyConnectionType,
+	configKeyConnectionAddress,
+	configKeyCOMPort,
+	configKeyBaudRate,
+	configKeyUSBVendorID,
+	configKeyUSBProductID,
+	configKeyNoiseReductionLevel,
+	configKeyAudioBackend,
+	configKeyDisplayConfig,
+}
+
+// every key that can be in the display config
+var displayConfigKeys = []string{
+	configKeyDisplayConfigEnabled,
+	configKeyDisplayConfigDitherThreshold,
+	configKeyDisplayConfigLegacyProtocol,
+	configKeyDisplayConfigVolumeOverlay,
+	configKeyDisplayConfigDisplayMapping,
+}
+
+// see util.SignificantlyDifferent
+var supportedNoiseReductionLevels = []string{"low", "default", "high"}
+
+// the special targets that come after specialTargetTransformPrefix
+var supportedSpecialTargets = []string{specialTargetCurrentWindow, specialTargetAllUnmapped}
+
+var supportedMediaKeys = []string{util.MediaKeyPlayPause, util.MediaKeyNext, util.MediaKeyPrevious, util.MediaKeyStop}
+
+// the words yaml 1.1 (which viper reads the config with) takes as booleans
+var configBoolValues = []string{"true", "false", "yes", "no", "on", "off", "y", "n"}
+
+// configValidator collects the problems found in a config file, as it goes through all of it
+type configValidator struct {
+	problems []configProblem
+
+	// profiles that can be referred to by other settings (buttons, rules)
+	profileNames []string
+}
+
+// validateConfig checks the given contents of a config file, and returns everything that's wrong with them
+func validateConfig(data []byte) []configProblem {
+	v := &configValidator{}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+
+		// these already say which line they're about, i.e. "yaml: line 4: did not find expected key"
+		v.report("", 0, strings.TrimPrefix(err.Error(), "yaml: "))
+		return v.problems
+	}
+
+	// an empty file leaves everything at its default
+	if len(document.Content) == 0 {
+		return nil
+	}
+
+	root := resolveAlias(document.Content[0])
+	if !v.expectKind(root, yaml.MappingNode, "", "expected a map of settings") {
+		return v.problems
+	}
+
+	v.profileNames = configProfileNames(root)
+	v.validateSettings(root)
+
+	return v.problems
+}
+
+func (v *configValidator) validateSettings(node *yaml.Node) {
+	v.forEachEntry(node, "", func(key string, keyNode *yaml.Node, value *yaml.Node) {
+		switch strings.ToLower(keyNode.Value) {
+		case configKeySliderMapping:
+			v.validateSliderMapping(key, value)
+
+		case configKeyInvertSliders, configKeySoftTakeover:
+			v.validateBool(key, value)
+
+		case configKeySliderCurves:
+			v.validateSliderCurves(key, value)
+
+		case configKeyProfiles:
+			v.validateProfiles(key, value)
+
+		case configKeyProfileRules:
+			v.validateProfileRules(key, value)
+
+		case configKeyFallbackProfile:
+			if v.validateString(key, value) && !funk.ContainsString(v.profileNames, strings.ToLower(value.Value)) {
+				v.report(key, value.Line, fmt.Sprintf("unknown profile %q, expected one of %v", value.Value, v.profileNames))
+			}
+
+		case configKeyEncoderStep:
+			if number, ok := v.validateNumber(key, value); ok && (number <= 0 || number > 1) {
+				v.report(key, value.Line, fmt.Sprintf("must be above 0 and at most 1, got %v", value.Value))
+			}
+
+		case configKeyEncoderAcceleration:
+			if number, ok := v.validateNumber(key, value); ok && number < 1 {
+				v.report(key, value.Line, fmt.Sprintf("must be at least 1, got %v", value.Value))
+			}
+
+		case configKeyButtonMapping:
+			v.validateButtonMapping(key, value)
+
+		case configKeyToggleSwitches:
+			v.validateToggleSwitches(key, value)
+
+		case configKeyConnectionType:
+			v.validateChoice(key, value, supportedTransportTypes)
+
+		case configKeyConnectionAddress, configKeyCOMPort:
+			v.validateString(key, value)
+
+		case configKeyBaudRate:
+			if number, ok := v.validateInt(key, value); ok && number <= 0 {
+				v.report(key, value.Line, fmt.Sprintf("must be above 0, got %v", value.Value))
+			}
+
+		case configKeyUSBVendorID, configKeyUSBProductID:
+			v.validateUSBID(key, value)
+
+		case configKeyNoiseReductionLevel:
+			v.validateChoice(key, value, supportedNoiseReductionLevels)
+
+		case configKeyAudioBackend:
+			v.validateChoice(key, value, supportedAudioBackends)
+
+		case configKeyDisplayConfig:
+			v.validateDisplayConfig(key, value)
+
+		default:
+			v.reportUnknownKey(key, keyNode, topLevelConfigKeys)
+		}
+	})
+}
+
+func (v *configValidator) validateSliderMapping(key string, node *yaml.Node) {
+	v.forEachIndexedEntry(node, key, "slider", func(entryKey string, value *yaml.Node) {
+		for _, target := range v.scalarOrList(entryKey, value, "a target or a list of them") {
+			if err := validateSliderTarget(target.Value); err != nil {
+				v.report(entryKey, target.Line, err.Error())
+			}
+		}
+	})
+}
+
+// validateSliderTarget catches targets that can never match anything, most likely because of a typo
+func validateSliderTarget(target string) error {
+	lowerTarget := strings.ToLower(strings.TrimSpace(target))
+
+	switch {
+	case lowerTarget == "":
+		return fmt.Errorf("empty target")
+
+	case strings.HasPrefix(lowerTarget, specialTargetTransformPrefix):
+		special := strings.TrimPrefix(lowerTarget, specialTargetTransformPrefix)
+		if !funk.ContainsString(supportedSpecialTargets, special) {
+			return fmt.Errorf("unknown special target %q, expected %s%s or %s%s", target,
+				specialTargetTransformPrefix, specialTargetCurrentWindow,
+				specialTargetTransformPrefix, specialTargetAllUnmapped)
+		}
+
+	case strings.HasPrefix(lowerTarget, propertyTargetPrefix):
+		if _, _, ok := parsePropertyTarget(lowerTarget); !ok {
+			return fmt.Errorf("property target %q must look like %s<property>=<value>", target, propertyTargetPrefix)
+		}
+
+	case strings.HasPrefix(lowerTarget, sinkTargetPrefix), strings.HasPrefix(lowerTarget, sourceTargetPrefix):
+		if name := strings.TrimSpace(lowerTarget[strings.Index(lowerTarget, ":")+1:]); name == "" {
+			return fmt.Errorf("device target %q is missing the device's name or description", target)
+		}
+
+	case isBalanceTarget(lowerTarget):
+		balanced := strings.TrimPrefix(lowerTarget, balanceTargetPrefix)
+		if isBalanceTarget(balanced) {
+			return fmt.Errorf("balance target %q can't control the balance of another balance target", target)
+		}
+
+		if err := validateSliderTarget(balanced); err != nil {
+			return fmt.Errorf("balance target %q: %w", target, err)
+		}
+	}
+
+	return nil
+}
+
+func (v *configValidator) validateSliderCurves(key string, node *yaml.Node) {
+	v.forEachIndexedEntry(node, key, "slider", func(entryKey string, value *yaml.Node) {
+		var curve interface{}
+		if err := value.Decode(&curve); err != nil {
+			v.report(entryKey, value.Line, err.Error())
+			return
+		}
+
+		if _, err := parseSliderCurve(curve); err != nil {
+			v.report(entryKey, value.Line, err.Error())
+		}
+	})
+}
+
+func (v *configValidator) validateButtonMapping(key string, node *yaml.Node) {
+	v.forEachIndexedEntry(node, key, "button", func(entryKey string, value *yaml.Node) {
+		for _, action := range v.scalarOrList(entryKey, value, "an action or a list of them") {
+			if err := validateButtonAction(action.Value, v.profileNames); err != nil {
+				v.report(entryKey, action.Line, err.Error())
+			}
+		}
+	})
+}
+
+func validateButtonAction(action string, profileNames []string) error {
+	actionName, argument := splitButtonAction(strings.ToLower(action))
+
+	switch actionName {
+	case buttonActionMute:
+		if sliderIdx, err := strconv.Atoi(argument); err != nil || sliderIdx < 0 {
+			return fmt.Errorf("%q needs a slider index, i.e. %s%s1", action, buttonActionMute, buttonActionArgumentSeparator)
+		}
+
+	case buttonActionMedia:
+		if !funk.ContainsString(supportedMediaKeys, argument) {
+			return fmt.Errorf("%q needs a media key, expected one of %v", action, supportedMediaKeys)
+		}
+
+	case buttonActionProfile:
+		if !funk.ContainsString(profileNames, argument) {
+			return fmt.Errorf("%q names an unknown profile, expected one of %v", action, profileNames)
+		}
+
+	case buttonActionRefreshSessions, buttonActionNextProfile:
+		if argument != "" {
+			return fmt.Errorf("%q doesn't take an argument, use just %s", action, actionName)
+		}
+
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	return nil
+}
+
+func (v *configValidator) validateToggleSwitches(key string, node *yaml.Node) {
+	if node.Tag == "!!null" || !v.expectKind(node, yaml.SequenceNode, key, "expected a list of button indexes") {
+		return
+	}
+
+	for _, item := range node.Content {
+		if buttonIdx, ok := v.validateInt(key, resolveAlias(item)); ok && buttonIdx < 0 {
+			v.report(key, item.Line, fmt.Sprintf("button indexes start at 0, got %v", item.Value))
+		}
+	}
+}
+
+func (v *configValidator) validateUSBID(key string, node *yaml.Node) {
+	if !v.validateString(key, node) || node.Value == "" {
+		return
+	}
+
+	if _, err := strconv.ParseUint(node.Value, 16, 16); err != nil {
+		v.report(key, node.Line, fmt.Sprintf("expected a 4-digit hex id (i.e. \"2341\"), got %q", node.Value))
+	}
+}
+
+// validateDisplayConfig checks the display config. inside of profiles, only its display mapping is allowed
+func (v *configValidator) validateDisplayConfig(key string, node *yaml.Node) {
+	v.validateDisplayConfigKeys(key, node, displayConfigKeys)
+}
+
+func (v *configValidator) validateDisplayConfigKeys(key string, node *yaml.Node, supportedKeys []string) {
+
+	// the constants are full key paths, but only their last part is in the display config's own map
+	supportedSubKeys := make([]string, len(supportedKeys))
+	for idx, supportedKey := range supportedKeys {
+		supportedSubKeys[idx] = strings.TrimPrefix(supportedKey, configKeyDisplayConfig+".")
+	}
+
+	v.forEachEntry(node, key, func(entryKey string, keyNode *yaml.Node, value *yaml.Node) {
+		subKey := strings.ToLower(keyNode.Value)
+
+		// only profiles leave some of them out
+		if !funk.ContainsString(supportedKeys, configKeyDisplayConfig+"."+subKey) &&
+			funk.ContainsString(displayConfigKeys, configKeyDisplayConfig+"."+subKey) {
+
+			v.report(entryKey, keyNode.Line, "can't be changed per profile, only at the top of the file")
+			return
+		}
+
+		if !funk.ContainsString(supportedSubKeys, subKey) {
+			v.reportUnknownKey(entryKey, keyNode, supportedSubKeys)
+			return
+		}
+
+		switch configKeyDisplayConfig + "." + subKey {
+		case configKeyDisplayConfigEnabled, configKeyDisplayConfigLegacyProtocol:
+			v.validateBool(entryKey, value)
+
+		case configKeyDisplayConfigDitherThreshold:
+			if threshold, ok := v.validateInt(entryKey, value); ok && (threshold < 0 || threshold > 255) {
+				v.report(entryKey, value.Line, fmt.Sprintf("must be between 0 and 255, got %v", value.Value))
+			}
+
+		case configKeyDisplayConfigVolumeOverlay:
+			v.validateChoice(entryKey, value, supportedVolumeOverlays)
+
+		case configKeyDisplayConfigDisplayMapping:
+			v.validateDisplayMapping(entryKey, value)
+		}
+	})
+}
+
+func (v *configValidator) validateDisplayMapping(key string, node *yaml.Node) {
+	v.forEachIndexedEntry(node, key, "display", func(entryKey string, value *yaml.Node) {
+		targets := v.scalarOrList(entryKey, value, "an image, auto or a process name")
+		if len(targets) == 0 {
+			return
+		}
+
+		// only the last one is shown (see createDisplayMapFromConfig)
+		target := targets[len(targets)-1]
+		lowerTarget := strings.ToLower(target.Value)
+
+		if lowerTarget != "auto" && !isImageDisplayTarget(lowerTarget) && !isProcessDisplayTarget(lowerTarget) {
+			v.report(entryKey, target.Line, fmt.Sprintf("can't show an icon for %q, expected a .png image, auto or a process name", target.Value))
+		}
+	})
+}
+
+func (v *configValidator) validateProfiles(key string, node *yaml.Node) {
+	v.forEachEntry(node, key, func(profileKey string, keyNode *yaml.Node, profile *yaml.Node) {
+
+		// profile names end up in key paths, where dots would mean something else
+		if strings.Contains(keyNode.Value, ".") {
+			v.report(profileKey, keyNode.Line, "profile names can't contain dots")
+			return
+		}
+
+		if profile.Tag == "!!null" || !v.expectKind(profile, yaml.MappingNode, profileKey, "expected a map of settings") {
+			return
+		}
+
+		// profiles can only override some settings (see profileKeys), in the same shape as at the top of the file
+		supportedKeys := []string{}
+		for _, key := range profileKeys {
+			if topLevelKey := strings.SplitN(key, ".", 2)[0]; !funk.ContainsString(supportedKeys, topLevelKey) {
+				supportedKeys = append(supportedKeys, topLevelKey)
+			}
+		}
+
+		supportedDisplayKeys := funk.FilterString(profileKeys, func(key string) bool {
+			return strings.HasPrefix(key, configKeyDisplayConfig+".")
+		})
+
+		v.forEachEntry(profile, profileKey, func(entryKey string, keyNode *yaml.Node, value *yaml.Node) {
+			switch strings.ToLower(keyNode.Value) {
+			case configKeySliderMapping:
+				v.validateSliderMapping(entryKey, value)
+
+			case configKeyInvertSliders:
+				v.validateBool(entryKey, value)
+
+			case configKeySliderCurves:
+				v.validateSliderCurves(entryKey, value)
+
+			case configKeyDisplayConfig:
+				v.validateDisplayConfigKeys(entryKey, value, supportedDisplayKeys)
+
+			default:
+				if funk.ContainsString(topLevelConfigKeys, strings.ToLower(keyNode.Value)) {
+					v.report(entryKey, keyNode.Line, "can't be changed per profile, only at the top of the file")
+					return
+				}
+
+				v.reportUnknownKey(entryKey, keyNode, supportedKeys)
+			}
+		})
+	})
+}
+
+func (v *configValidator) validateProfileRules(key string, node *yaml.Node) {
+	if node.Tag == "!!null" || !v.expectKind(node, yaml.SequenceNode, key, "expected a list of rules") {
+		return
+	}
+
+	ruleKeys := []string{profileRuleKeyProfile, profileRuleKeyProcess, profileRuleKeyWhen, profileRuleKeyPriority}
+
+	for idx, item := range node.Content {
+		ruleKey := fmt.Sprintf("%s[%d]", key, idx)
+		rule := resolveAlias(item)
+
+		if !v.expectKind(rule, yaml.MappingNode, ruleKey, "expected a map with the rule's profile and process") {
+			continue
+		}
+
+		valid := true
+		v.forEachEntry(rule, ruleKey, func(entryKey string, keyNode *yaml.Node, _ *yaml.Node) {
+			if !funk.ContainsString(ruleKeys, strings.ToLower(keyNode.Value)) {
+				v.reportUnknownKey(entryKey, keyNode, ruleKeys)
+				valid = false
+			}
+		})
+
+		if !valid {
+			continue
+		}
+
+		var value interface{}
+		if err := rule.Decode(&value); err != nil {
+			v.report(ruleKey, rule.Line, err.Error())
+			continue
+		}
+
+		if _, err := parseProfileRule(value, v.profileNames); err != nil {
+			v.report(ruleKey, rule.Line, err.Error())
+		}
+	}
+}
+
+func (v *configValidator) validateBool(key string, node *yaml.Node) bool {
+	if node.Kind != yaml.ScalarNode || !funk.ContainsString(configBoolValues, strings.ToLower(node.Value)) {
+		v.report(key, node.Line, fmt.Sprintf("expected true or false, got %s", describeNode(node)))
+		return false
+	}
+
+	return true
+}
+
+// validateString accepts any single value (numbers too, since they can always be read as a string)
+func (v *configValidator) validateString(key string, node *yaml.Node) bool {
+	if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+		v.report(key, node.Line, fmt.Sprintf("expected a single value, got %s", describeNode(node)))
+		return false
+	}
+
+	return true
+}
+
+func (v *configValidator) validateChoice(key string, node *yaml.Node, choices []string) {
+	if v.validateString(key, node) && !funk.ContainsString(choices, strings.ToLower(node.Value)) {
+		v.report(key, node.Line, fmt.Sprintf("unknown value %q, expected one of %v", node.Value, choices))
+	}
+}
+
+func (v *configValidator) validateNumber(key string, node *yaml.Node) (float64, bool) {
+	var number float64
+
+	if node.Kind != yaml.ScalarNode || (node.Tag != "!!int" && node.Tag != "!!float") || node.Decode(&number) != nil {
+		v.report(key, node.Line, fmt.Sprintf("expected a number, got %s", describeNode(node)))
+		return 0, false
+	}
+
+	return number, true
+}
+
+func (v *configValidator) validateInt(key string, node *yaml.Node) (int, bool) {
+	var number int
+
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!int" || node.Decode(&number) != nil {
+		v.report(key, node.Line, fmt.Sprintf("expected a whole number, got %s", describeNode(node)))
+		return 0, false
+	}
+
+	return number, true
+}
+
+// scalarOrList returns the given value's items, for settings that take a single value or a list of them
+func (v *configValidator) scalarOrList(key string, node *yaml.Node, expected string) []*yaml.Node {
+	items := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		items = node.Content
+	}
+
+	result := []*yaml.Node{}
+	for _, item := range items {
+		item = resolveAlias(item)
+
+		if item.Kind != yaml.ScalarNode || item.Tag == "!!null" {
+			v.report(key, item.Line, fmt.Sprintf("expected %s, got %s", expected, describeNode(item)))
+			continue
+		}
+
+		result = append(result, item)
+	}
+
+	return result
+}
+
+// forEachEntry calls the given function with the full key path, key and value of each of a map's entries.
+// an empty value counts as an empty map, and keys given more than once are reported
+func (v *configValidator) forEachEntry(node *yaml.Node, key string, f func(string, *yaml.Node, *yaml.Node)) {
+	if node.Tag == "!!null" || !v.expectKind(node, yaml.MappingNode, key, "expected a map") {
+		return
+	}
+
+	seenLines := map[string]int{}
+
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		keyNode, value := node.Content[idx], resolveAlias(node.Content[idx+1])
+
+		entryKey := keyNode.Value
+		if key != "" {
+			entryKey = key + "." + keyNode.Value
+		}
+
+		// viper doesn't care about the case of keys, so neither do duplicates
+		lowerKey := strings.ToLower(keyNode.Value)
+		if seenLine, ok := seenLines[lowerKey]; ok {
+			v.report(entryKey, keyNode.Line, fmt.Sprintf("set more than once (first on line %d)", seenLine))
+			continue
+		}
+
+		seenLines[lowerKey] = keyNode.Line
+
+		f(entryKey, keyNode, value)
+	}
+}
+
+// forEachIndexedEntry is forEachEntry for maps keyed by slider, button or display index
+func (v *configValidator) forEachIndexedEntry(node *yaml.Node, key string, indexName string, f func(string, *yaml.Node)) {
+	v.forEachEntry(node, key, func(entryKey string, keyNode *yaml.Node, value *yaml.Node) {
+		if idx, err := strconv.Atoi(keyNode.Value); err != nil || idx < 0 {
+			v.report(entryKey, keyNode.Line, fmt.Sprintf("%q isn't a %s index, expected a whole number starting at 0", keyNode.Value, indexName))
+			return
+		}
+
+		f(entryKey, value)
+	})
+}
+
+func (v *configValidator) expectKind(node *yaml.Node, kind yaml.Kind, key string, message string) bool {
+	if node.Kind != kind {
+		v.report(key, node.Line, fmt.Sprintf("%s, got %s", message, describeNode(node)))
+		return false
+	}
+
+	return true
+}
+
+// reportUnknownKey reports a key nobody reads, suggesting the known key it's closest to (if any is close enough)
+func (v *configValidator) reportUnknownKey(key string, keyNode *yaml.Node, knownKeys []string) {
+	suggestion := ""
+	bestDistance := maxConfigKeyTypoDistance + 1
+
+	for _, knownKey := range knownKeys {
+		if distance := editDistance(strings.ToLower(keyNode.Value), knownKey); distance < bestDistance {
+			suggestion = knownKey
+			bestDistance = distance
+		}
+	}
+
+	if suggestion != "" {
+		v.report(key, keyNode.Line, fmt.Sprintf("unknown setting, did you mean %s?", suggestion))
+		return
+	}
+
+	v.report(key, keyNode.Line, "unknown setting")
+}
+
+func (v *configValidator) report(key string, line int, message string) {
+	v.problems = append(v.problems, configProblem{key: key, line: line, message: message})
+}
+
+// notifyConfigProblems logs every problem found in the config, and lets the user know about the first few
+func (cc *CanonicalConfig) notifyConfigProblems(problems []configProblem) {
+	for _, problem := range problems {
+		cc.logger.Warnw("Invalid config value", "key", problem.key, "line", problem.line, "problem", problem.message)
+	}
+
+	// there's nothing to fall back on the first time around, since deej can't start without a config
+	outcome := "Your previous settings are still in use."
+	if cc.sliderMapping() == nil {
+		outcome = "Please fix it and re-launch."
+	}
+
+	lines := []string{fmt.Sprintf("Found %d problem(s) in %s. %s", len(problems), userConfigFilepath, outcome)}
+	for idx, problem := range problems {
+		if idx == notifiedConfigProblems {
+			lines = append(lines, fmt.Sprintf("...and %d more, see deej's logs", len(problems)-idx))
+			break
+		}
+
+		lines = append(lines, problem.String())
+	}
+
+	cc.notifier.Notify("Invalid configuration!", strings.Join(lines, "\n"))
+}
+
+func (p configProblem) String() string {
+	location := p.key
+	if p.line > 0 && p.key != "" {
+		location = fmt.Sprintf("line %d (%s)", p.line, p.key)
+	} else if p.line > 0 {
+		location = fmt.Sprintf("line %d", p.line)
+	}
+
+	if location == "" {
+		return p.message
+	}
+
+	return fmt.Sprintf("%s: %s", location, p.message)
+}
+
+// configProfileNames returns the names of all profiles in the config, starting with the default one
+// (just like CanonicalConfig.populateProfiles will, if it turns out to be valid)
+func configProfileNames(root *yaml.Node) []string {
+	profileNames := []string{defaultProfileName}
+
+	for idx := 0; idx+1 < len(root.Content); idx += 2 {
+		if strings.ToLower(root.Content[idx].Value) != configKeyProfiles {
+			continue
+		}
+
+		profiles := resolveAlias(root.Content[idx+1])
+		if profiles.Kind != yaml.MappingNode {
+			break
+		}
+
+		for profileIdx := 0; profileIdx < len(profiles.Content); profileIdx += 2 {
+			name := strings.ToLower(profiles.Content[profileIdx].Value)
+			if !strings.Contains(name, ".") && !funk.ContainsString(profileNames, name) {
+				profileNames = append(profileNames, name)
+			}
+		}
+	}
+
+	return profileNames
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// describeNode names what a value turned out to be, for problems that expected something else
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a map"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+
+	if node.Tag == "!!null" {
+		return "nothing"
+	}
+
+	return fmt.Sprintf("%q", node.Value)
+}
+
+// editDistance is the number of single character edits it takes to turn one string into the other
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
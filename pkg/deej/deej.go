@@ -25,6 +25,8 @@ type Deej struct {
 	config      *CanonicalConfig
 	serial      *SerialIO
 	sessions    *sessionMap
+	buttons     *buttonHandler
+	profiles    *profileSwitcher
 	display     *DeejDisplay
 	stopChannel chan bool
 	version     string
@@ -63,13 +65,7 @@ func NewDeej(logger *zap.SugaredLogger, verbose bool) (*Deej, error) {
 
 	d.serial = serial
 
-	sessionFinder, err := newSessionFinder(logger)
-	if err != nil {
-		logger.Errorw("Failed to create SessionFinder", "error", err)
-		return nil, fmt.Errorf("create new SessionFinder: %w", err)
-	}
-
-	sessions, err := newSessionMap(d, logger, sessionFinder)
+	sessions, err := newSessionMap(d, logger)
 	if err != nil {
 		logger.Errorw("Failed to create sessionMap", "error", err)
 		return nil, fmt.Errorf("create new sessionMap: %w", err)
@@ -77,6 +73,22 @@ func NewDeej(logger *zap.SugaredLogger, verbose bool) (*Deej, error) {
 
 	d.sessions = sessions
 
+	buttons, err := newButtonHandler(d, logger)
+	if err != nil {
+		logger.Errorw("Failed to create buttonHandler", "error", err)
+		return nil, fmt.Errorf("create new buttonHandler: %w", err)
+	}
+
+	d.buttons = buttons
+
+	profiles, err := newProfileSwitcher(d, logger)
+	if err != nil {
+		logger.Errorw("Failed to create profileSwitcher", "error", err)
+		return nil, fmt.Errorf("create new profileSwitcher: %w", err)
+	}
+
+	d.profiles = profiles
+
 	display, err := NewDeejDisplay(d, logger)
 	if err != nil {
 		logger.Errorw("Failed to create display", "error", err)
@@ -100,12 +112,25 @@ func (d *Deej) Initialize() error {
 		return fmt.Errorf("load config during init: %w", err)
 	}
 
+	// the session finder depends on the config (i.e. which audio backend to use), so it's only created now
+	sessionFinder, err := newSessionFinder(d.logger, d.config)
+	if err != nil {
+		d.logger.Errorw("Failed to create SessionFinder", "error", err)
+		return fmt.Errorf("create new SessionFinder: %w", err)
+	}
+
 	// initialize the session map
-	if err := d.sessions.initialize(); err != nil {
+	if err := d.sessions.initialize(sessionFinder); err != nil {
 		d.logger.Errorw("Failed to initialize session map", "error", err)
 		return fmt.Errorf("init session map: %w", err)
 	}
 
+	// start responding to buttons and switches
+	d.buttons.initialize()
+
+	// and switching profiles by the config's rules
+	d.profiles.initialize()
+
 	// decide whether to run with/without tray
 	if _, noTraySet := os.LookupEnv(envNoTray); noTraySet {
 
@@ -149,7 +174,8 @@ func (d *Deej) run() {
 	// watch the config file for changes
 	go d.config.WatchConfigFileChanges()
 
-	// connect to the arduino for the first time
+	// connect to the arduino for the first time. if it isn't there yet, serial keeps trying on its own,
+	// so only errors that won't go away by waiting end up here
 	go func() {
 		if err := d.serial.Start(); err != nil {
 			d.logger.Warnw("Failed to start first-time serial connection", "error", err)
@@ -157,22 +183,15 @@ func (d *Deej) run() {
 			// If the port is busy, that's because something else is connected - notify and quit
 			if errors.Is(err, os.ErrPermission) {
 				d.logger.Warnw("Serial port seems busy, notifying user and closing",
-					"comPort", d.config.ConnectionInfo.COMPort)
+					"target", d.config.ConnectionInfo.target())
 
-				d.notifier.Notify(fmt.Sprintf("Can't connect to %s!", d.config.ConnectionInfo.COMPort),
+				d.notifier.Notify(fmt.Sprintf("Can't connect to %s!", d.config.ConnectionInfo.target()),
 					"This serial port is busy, make sure to close any serial monitor or other deej instance.")
 
 				d.signalStop()
-
-				// also notify if the COM port they gave isn't found, maybe their config is wrong
-			} else if errors.Is(err, os.ErrNotExist) {
-				d.logger.Warnw("Provided COM port seems wrong, notifying user and closing",
-					"comPort", d.config.ConnectionInfo.COMPort)
-
-				d.notifier.Notify(fmt.Sprintf("Can't connect to %s!", d.config.ConnectionInfo.COMPort),
-					"This serial port doesn't exist, check your configuration and make sure it's set correctly.")
-
-				d.signalStop()
+			} else {
+				d.notifier.Notify(fmt.Sprintf("Can't connect to %s!", d.config.ConnectionInfo.target()),
+					"Please check your configuration and deej's logs for more details.")
 			}
 		}
 	}()
@@ -199,6 +218,7 @@ func (d *Deej) stop() error {
 	d.logger.Info("Stopping")
 
 	d.config.StopWatchingConfigFile()
+	d.profiles.stop()
 	d.serial.Stop()
 
 	// release the session map
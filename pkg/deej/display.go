@@ -2,27 +2,26 @@ package deej
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"image/png"
-	"log"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/fcjr/geticon"
 	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
 	"github.com/nfnt/resize"
 	"github.com/omriharel/deej/pkg/deej/util"
-	"github.com/shirou/gopsutil/v3/process"
+	"github.com/thoas/go-funk"
 	"go.uber.org/zap"
 	"golang.org/x/image/font/gofont/goregular"
-	"golang.org/x/sys/windows"
 )
 
 type (
@@ -41,26 +40,49 @@ const (
 	UNKNOWN   ImageType = "unknown"
 )
 
-var (
-	mod                          = windows.NewLazyDLL("user32.dll")
-	procGetWindowThreadProcessId = mod.NewProc("GetWindowThreadProcessId")
-	modKernel32                  = windows.NewLazyDLL("kernel32.dll")
-	modPsapi                     = windows.NewLazyDLL("psapi.dll")
-
-	procOpenProcess       = modKernel32.NewProc("OpenProcess")
-	procGetModuleBaseName = modPsapi.NewProc("GetModuleBaseNameW")
-	lastActiceProcess     = ""
-)
+var lastActiceProcess = ""
 
 type DisplayConfig struct {
 	Enabled         bool
 	DitherThreshold int
 	DisplayMapping  []DisplayMap
+
+	// forces the original marker-based format even if the board can do better, for firmware with broken frame support
+	LegacyProtocol bool
+
+	// what to draw on top of each display's image to show the volume of its slider (see display_overlay.go)
+	VolumeOverlay string
 }
 
 type DeejDisplay struct {
 	deej   *Deej
 	logger *zap.SugaredLogger
+
+	// the background watcher must only be started once, no matter how many times we (re)connect
+	watcherOnce sync.Once
+
+	// bitmaps waiting to be sent, by display index. only the latest one for each display is worth sending
+	pendingFrames map[int][]byte
+	framesPending chan bool
+
+	// the last bitmap each display acknowledged, so that it isn't sent again
+	sentFrames map[int][]byte
+
+	// the display whose last bitmap the firmware still holds, or noBufferedDisplay. the firmware has a single frame
+	// buffer shared by all displays, so deltas can only be sent to the display it drew last
+	bufferedDisplayIdx int
+
+	framesLock sync.Locker
+
+	// identifies each frame we send, so that the firmware's replies can be matched to them
+	frameSequence byte
+
+	// each display's image before any overlay is drawn on it, and the volume of the slider it belongs to
+	baseImages     map[int]*image.RGBA
+	volumes        map[int]float32
+	changedVolumes map[int]bool
+	overlayLock    sync.Locker
+	overlayFont    *truetype.Font
 }
 
 type DisplayMap struct {
@@ -74,18 +96,42 @@ func newDisplayConfig() *DisplayConfig {
 		Enabled:         false,
 		DitherThreshold: 127,
 		DisplayMapping:  []DisplayMap{},
+		VolumeOverlay:   volumeOverlayNone,
 	}
 }
 
 func NewDeejDisplay(deej *Deej, logger *zap.SugaredLogger) (*DeejDisplay, error) {
 	logger = logger.Named("Display")
 	display := &DeejDisplay{
-		deej:   deej,
-		logger: logger,
+		deej:               deej,
+		logger:             logger,
+		pendingFrames:      map[int][]byte{},
+		framesPending:      make(chan bool, 1),
+		sentFrames:         map[int][]byte{},
+		bufferedDisplayIdx: noBufferedDisplay,
+		framesLock:         &sync.Mutex{},
+		baseImages:         map[int]*image.RGBA{},
+		volumes:            map[int]float32{},
+		changedVolumes:     map[int]bool{},
+		overlayLock:        &sync.Mutex{},
 	}
 
+	overlayFont, err := freetype.ParseFont(goregular.TTF)
+	if err != nil {
+		logger.Errorw("Failed to parse overlay font", "error", err)
+		return nil, fmt.Errorf("parse overlay font: %w", err)
+	}
+
+	display.overlayFont = overlayFont
+
 	logger.Debug("Created display instance")
 
+	// transfers can take a while, so they happen in the background
+	go display.sendPendingFrames()
+
+	// keep the volume overlay up to date
+	display.setupOnSliderMove()
+
 	return display, nil
 }
 
@@ -97,13 +143,8 @@ func createDisplayMapFromConfig(userMapping map[string][]string, userSliderMap *
 		firstTarget := target[len(target)-1]
 		idx, _ := strconv.Atoi(display_idx)
 
-		if strings.Contains(firstTarget, ".exe") {
-			mapping = append(mapping, DisplayMap{
-				display_idx: idx,
-				target:      firstTarget,
-			})
-		}
-		if strings.Contains(firstTarget, ".png") {
+		// images and processes (with or without an extension, which linux binaries don't have) are shown as-is
+		if isImageDisplayTarget(firstTarget) || isProcessDisplayTarget(firstTarget) {
 			mapping = append(mapping, DisplayMap{
 				display_idx: idx,
 				target:      firstTarget,
@@ -111,6 +152,12 @@ func createDisplayMapFromConfig(userMapping map[string][]string, userSliderMap *
 		}
 		if firstTarget == "auto" {
 			mappedTo, _ := userSliderMap.get(idx)
+
+			// there's nothing to show for sliders that aren't mapped (i.e. in a profile that leaves them out)
+			if len(mappedTo) == 0 {
+				continue
+			}
+
 			firstExe := mappedTo[len(mappedTo)-1]
 
 			isCurrent := firstExe == "deej.current" || firstExe == "deej.unmapped"
@@ -122,14 +169,29 @@ func createDisplayMapFromConfig(userMapping map[string][]string, userSliderMap *
 			})
 		}
 	}
-	fmt.Printf("auto target %v\n", mapping)
 
 	return mapping
 }
 
+// initDisplays renders all displays, and makes sure they're kept up to date from now on.
+// it's called whenever a connection to the board is established, including reconnections
 func (deejDisplay *DeejDisplay) initDisplays() {
+
+	// the board might've been reset (or replaced) since, so we can't tell what its displays show
+	deejDisplay.framesLock.Lock()
+	deejDisplay.sentFrames = map[int][]byte{}
+	deejDisplay.bufferedDisplayIdx = noBufferedDisplay
+	deejDisplay.framesLock.Unlock()
+
 	deejDisplay.renderDisplays()
 
+	// forget the last active process, so that displays showing the current app get their icon on the next tick
+	lastActiceProcess = ""
+
+	deejDisplay.watcherOnce.Do(deejDisplay.watchForChanges)
+}
+
+func (deejDisplay *DeejDisplay) watchForChanges() {
 	// Create a channel to receive OS signals
 	signalChan := make(chan os.Signal, 1)
 
@@ -145,11 +207,15 @@ func (deejDisplay *DeejDisplay) initDisplays() {
 		for {
 			select {
 			case <-ticker.C:
-				activeWindows, _ := util.GetCurrentWindowProcessNames()
+				activeWindows, err := util.GetCurrentWindowProcessNames()
+				if err != nil || len(activeWindows) == 0 {
+					continue
+				}
+
 				activeWindow := activeWindows[len(activeWindows)-1]
 				if activeWindow != lastActiceProcess {
 					lastActiceProcess = activeWindow
-					for _, displayConfig := range deejDisplay.deej.config.DisplayConfig.DisplayMapping {
+					for _, displayConfig := range deejDisplay.deej.config.displayConfig().DisplayMapping {
 						if displayConfig.currentApp {
 							deejDisplay.sendProcessIconToDisplayByProcessName(activeWindow, displayConfig.display_idx)
 						}
@@ -168,62 +234,286 @@ func (deejDisplay *DeejDisplay) initDisplays() {
 }
 
 func (deejDisplay *DeejDisplay) renderDisplays() {
-	for _, displayMap := range deejDisplay.deej.config.DisplayConfig.DisplayMapping {
-		// .exe is found grab icon from running process
-		if strings.Contains(displayMap.target, ".exe") {
-			deejDisplay.sendProcessIconToDisplayByProcessName(displayMap.target, displayMap.display_idx)
-		}
-		if strings.Contains(displayMap.target, ".png") {
+	for _, displayMap := range deejDisplay.deej.config.displayConfig().DisplayMapping {
+		if isImageDisplayTarget(displayMap.target) {
 			deejDisplay.sendPNGToDisplay(displayMap.target, displayMap.display_idx)
+		} else if isProcessDisplayTarget(displayMap.target) {
+			// grab icon from the process (or the app it belongs to)
+			deejDisplay.sendProcessIconToDisplayByProcessName(displayMap.target, displayMap.display_idx)
 		}
 	}
 }
 
+// isImageDisplayTarget returns true if the display target is a path to an image file
+func isImageDisplayTarget(target string) bool {
+	return strings.HasSuffix(strings.ToLower(target), ".png")
+}
+
+// isProcessDisplayTarget returns true if the display target names a process, as opposed to an image, a device
+// or any of the special slider targets (which have no icon of their own)
+func isProcessDisplayTarget(target string) bool {
+	lowerTarget := strings.ToLower(target)
+
+	if lowerTarget == "auto" || isImageDisplayTarget(lowerTarget) || strings.HasPrefix(lowerTarget, specialTargetTransformPrefix) ||
+		targetNeedsMatching(lowerTarget) || isBalanceTarget(lowerTarget) {
+		return false
+	}
+
+	if funk.ContainsString([]string{masterSessionName, systemSessionName, inputSessionName}, lowerTarget) {
+		return false
+	}
+
+	return !deviceSessionKeyPattern.MatchString(target)
+}
+
 func (deejDisplay *DeejDisplay) sendPNGToDisplay(imagePath string, display_idx int) {
 	byteSlice := deejDisplay.readFile(imagePath)
 
 	img, err := png.Decode(bytes.NewReader(byteSlice))
 	if err != nil {
-		log.Fatalf("Error loading PNG icon: %v", err)
+		deejDisplay.logger.Warnw("Failed to load PNG image", "path", imagePath, "error", err)
+		return
 	}
 
-	byteSlice = deejDisplay.convertForDisplay(img, false, false)
-	deejDisplay.sendData(display_idx, byteSlice)
+	deejDisplay.showImage(display_idx, deejDisplay.convertForDisplay(img, false, false))
 }
 
 func (deejDisplay *DeejDisplay) sendProcessIconToDisplayByProcessName(processName string, display_idx int) {
 	deejDisplay.logger.Debug(fmt.Sprintf("Fetching process icon for %s", processName))
-	pid, err := deejDisplay.getPIDByExeName(processName) // I assume this function is implemented elsewhere in your package
-	deejDisplay.logger.Info("Got PID: ", pid)
-	if err != nil || pid == 0 {
+
+	// how icons are found depends on the platform (see display_<platform>.go)
+	icon, err := deejDisplay.lookupProcessIcon(processName)
+	if err != nil {
+		deejDisplay.logger.Debug("Error fetching icon: ", err)
 		return
 	}
 
-	deejDisplay.sendProcessIconToDisplayByPid(pid, display_idx)
+	deejDisplay.showImage(display_idx, deejDisplay.convertForDisplay(icon, true, true))
 }
 
-func (deejDisplay *DeejDisplay) sendProcessIconToDisplayByPid(pid uint32, display_idx int) {
-	deejDisplay.logger.Debug("Fetching icon")
-	icon, err := geticon.FromPid(pid)
-	if err != nil {
-		deejDisplay.logger.Debug("Error fetching icon: ", err)
+// sendData queues a bitmap to be sent to the given display, replacing any other that's still waiting for it
+func (deejDisplay *DeejDisplay) sendData(display_idx int, data []byte) {
+
+	// sending an image to a display that isn't there would have the firmware draw it somewhere else (or nowhere)
+	device := deejDisplay.deej.serial.identifiedDevice()
+	if device == nil {
+		deejDisplay.logger.Debug("Board hasn't identified itself yet, skip sending data")
 		return
 	}
-	byteData := deejDisplay.convertForDisplay(icon, true, true)
-	deejDisplay.sendData(display_idx, byteData)
+
+	if !device.hasDisplay(display_idx) {
+		deejDisplay.logger.Warnw("Board has no such display, skip sending data",
+			"displayIdx", display_idx,
+			"device", device)
+		return
+	}
+
+	deejDisplay.framesLock.Lock()
+	deejDisplay.pendingFrames[display_idx] = data
+	deejDisplay.framesLock.Unlock()
+
+	// wake the sender up, unless it's already been woken up
+	select {
+	case deejDisplay.framesPending <- true:
+	default:
+	}
 }
 
-func (deejDisplay *DeejDisplay) sendData(display_idx int, data []byte) {
-	if deejDisplay.deej.serial.connected {
-		deejDisplay.logger.Debug(fmt.Sprintf("Writing to display %d", display_idx))
-		sendData := append([]byte(fmt.Sprintf("<<START>>%d|", display_idx)), data...)
-		sendData = append(sendData, []byte("<<END>>.....")...)
+// sendPendingFrames sends queued bitmaps one at a time, for as long as deej runs
+func (deejDisplay *DeejDisplay) sendPendingFrames() {
+	for range deejDisplay.framesPending {
+		for {
+			display_idx, data, ok := deejDisplay.popPendingFrame()
+			if !ok {
+				break
+			}
+
+			deejDisplay.transferFrame(display_idx, data)
+		}
+	}
+}
+
+// popPendingFrame takes the queued bitmap of the lowest display index, if there is one
+func (deejDisplay *DeejDisplay) popPendingFrame() (int, []byte, bool) {
+	deejDisplay.framesLock.Lock()
+	defer deejDisplay.framesLock.Unlock()
+
+	lowestIdx := -1
+	for display_idx := range deejDisplay.pendingFrames {
+		if lowestIdx == -1 || display_idx < lowestIdx {
+			lowestIdx = display_idx
+		}
+	}
+
+	if lowestIdx == -1 {
+		return 0, nil, false
+	}
+
+	data := deejDisplay.pendingFrames[lowestIdx]
+	delete(deejDisplay.pendingFrames, lowestIdx)
+
+	return lowestIdx, data, true
+}
+
+// transferFrame sends a single bitmap to the board, in the best format it supports
+func (deejDisplay *DeejDisplay) transferFrame(display_idx int, data []byte) {
+	serial := deejDisplay.deej.serial
+
+	device := serial.connectedDevice()
+	if device == nil {
+		deejDisplay.logger.Warn("Not connected, skip sending data")
+		return
+	}
 
-		_, err := deejDisplay.deej.serial.conn.Write(sendData)
+	deejDisplay.logger.Debug(fmt.Sprintf("Writing to display %d", display_idx))
+
+	if deejDisplay.deej.config.displayConfig().LegacyProtocol || !device.supports(capabilityFrames) {
+		err := serial.write(encodeLegacyFrame(display_idx, data))
 		deejDisplay.checkError("Writing data to port", err)
+
+		// legacy images go through the same buffer, without telling us whether they made it
+		deejDisplay.setSentFrame(display_idx, nil)
+
+		return
+	}
+
+	if bytes.Equal(deejDisplay.sentFrame(display_idx), data) {
+		deejDisplay.logger.Debug(fmt.Sprintf("Display %d already shows this bitmap, skipping", display_idx))
+		return
+	}
+
+	for attempt := 1; attempt <= maxFrameAttempts; attempt++ {
+		err := deejDisplay.transferFrameOnce(display_idx, data)
+		if err == nil {
+			deejDisplay.setSentFrame(display_idx, data)
+			return
+		}
+
+		// whatever the display shows now, it's not something a delta can safely be based on
+		deejDisplay.setSentFrame(display_idx, nil)
+
+		deejDisplay.logger.Debugw("Frame transfer failed",
+			"displayIdx", display_idx,
+			"attempt", attempt,
+			"error", err)
+	}
+
+	deejDisplay.logger.Warnw("Giving up on sending frame", "displayIdx", display_idx, "attempts", maxFrameAttempts)
+}
+
+// transferFrameOnce sends a single frame, and waits for the firmware to acknowledge it
+func (deejDisplay *DeejDisplay) transferFrameOnce(display_idx int, data []byte) error {
+	serial := deejDisplay.deej.serial
+
+	// forget replies to frames we've already given up on
+	for len(serial.frameReplies) > 0 {
+		<-serial.frameReplies
+	}
+
+	deejDisplay.frameSequence++
+	sequence := deejDisplay.frameSequence
+
+	frameType, payload := deejDisplay.encodeBitmap(display_idx, data)
+
+	frame := encodeFrame(frameType, display_idx, sequence, payload)
+	if err := serial.write(frame); err != nil {
+		return fmt.Errorf("write frame: %w", err)
+	}
+
+	timeout := time.After(frameReplyTimeout + deejDisplay.transferDuration(len(frame)))
+
+	for {
+		select {
+		case reply := <-serial.frameReplies:
+			if reply.Sequence != sequence {
+				continue
+			}
+
+			if !reply.Ack {
+				return errors.New("frame rejected by board")
+			}
+
+			return nil
+		case <-timeout:
+			return errors.New("timed out waiting for frame reply")
+		}
+	}
+}
+
+// encodeBitmap picks the smallest encoding of a bitmap that the board supports
+func (deejDisplay *DeejDisplay) encodeBitmap(display_idx int, data []byte) (byte, []byte) {
+	device := deejDisplay.deej.serial.identifiedDevice()
+	frameType, payload := frameTypeImage, data
+
+	if device.supports(capabilityRLE) {
+		if encoded := encodeRLE(data); len(encoded) < len(payload) {
+			frameType, payload = frameTypeRLE, encoded
+		}
+	}
+
+	if previous := deejDisplay.deltaBase(display_idx); device.supports(capabilityDelta) && len(previous) == len(data) {
+		if encoded := encodeDelta(previous, data); len(encoded) < len(payload) {
+			frameType, payload = frameTypeDelta, encoded
+		}
+	}
+
+	if deejDisplay.deej.Verbose() {
+		deejDisplay.logger.Debugw("Encoded bitmap",
+			"displayIdx", display_idx,
+			"frameType", frameType,
+			"size", len(data),
+			"encodedSize", len(payload))
+	}
+
+	return frameType, payload
+}
+
+func (deejDisplay *DeejDisplay) sentFrame(display_idx int) []byte {
+	deejDisplay.framesLock.Lock()
+	defer deejDisplay.framesLock.Unlock()
+
+	return deejDisplay.sentFrames[display_idx]
+}
+
+// deltaBase returns the bitmap a frame to the given display can be a delta of, which is only the case
+// if it's the display whose bitmap the firmware's frame buffer still holds
+func (deejDisplay *DeejDisplay) deltaBase(display_idx int) []byte {
+	deejDisplay.framesLock.Lock()
+	defer deejDisplay.framesLock.Unlock()
+
+	if display_idx != deejDisplay.bufferedDisplayIdx {
+		return nil
+	}
+
+	return deejDisplay.sentFrames[display_idx]
+}
+
+// setSentFrame records the bitmap a display acknowledged, which the firmware's frame buffer now holds.
+// nil means we can't tell what the display shows, and that the buffer holds nothing a delta can be based on
+func (deejDisplay *DeejDisplay) setSentFrame(display_idx int, data []byte) {
+	deejDisplay.framesLock.Lock()
+	defer deejDisplay.framesLock.Unlock()
+
+	if data == nil {
+		delete(deejDisplay.sentFrames, display_idx)
+		deejDisplay.bufferedDisplayIdx = noBufferedDisplay
 	} else {
-		deejDisplay.logger.Warn("Not connected, skip sending data")
+		deejDisplay.sentFrames[display_idx] = data
+		deejDisplay.bufferedDisplayIdx = display_idx
+	}
+}
+
+// transferDuration estimates how long it takes for the given amount of bytes to reach the board
+func (deejDisplay *DeejDisplay) transferDuration(size int) time.Duration {
+	connInfo := deejDisplay.deej.serial.connInfo
+
+	// network transports are fast enough not to matter
+	if connInfo.Type != transportTypeSerial || connInfo.BaudRate <= 0 {
+		return 0
 	}
+
+	// every byte takes 10 bits on the wire (8 data bits, a start bit and a stop bit)
+	return time.Duration(size*10) * time.Second / time.Duration(connInfo.BaudRate)
 }
 
 func (deejDisplay *DeejDisplay) readFile(filePath string) []byte {
@@ -238,22 +528,7 @@ func (deejDisplay *DeejDisplay) checkError(msg string, err error) {
 	}
 }
 
-func (deejDisplay *DeejDisplay) getPIDByExeName(exeName string) (uint32, error) {
-	procs, err := process.Processes()
-	if err != nil {
-		return 0, err
-	}
-	deejDisplay.logger.Debug("Got process list")
-	for _, p := range procs {
-		name, err := p.Name()
-		if err == nil && strings.ToLower(name) == strings.ToLower(exeName) {
-			return uint32(p.Pid), nil
-		}
-	}
-	return 0, fmt.Errorf("no process found with exe name: %s", exeName)
-}
-
-func (deejDisplay *DeejDisplay) convertForDisplay(src image.Image, doResize bool, dithering bool) []byte {
+func (deejDisplay *DeejDisplay) convertForDisplay(src image.Image, doResize bool, dithering bool) *image.RGBA {
 	// imageType, err := DetectImageTypeFromImage(src)
 	// if err != nil {
 	// 	fmt.Println(err)
@@ -262,16 +537,24 @@ func (deejDisplay *DeejDisplay) convertForDisplay(src image.Image, doResize bool
 	// fmt.Printf("Detected icon image type: %s\n", imageType)
 	// Resize to 50x50
 	deejDisplay.logger.Debug("Converting icon to for display")
+	width, height := deejDisplay.displaySize()
+
 	var resizedImg image.Image
 	if doResize {
-		deejDisplay.logger.Debug("Resize to 60x60")
-		resizedImg = resize.Resize(60, 60, src, resize.Lanczos3)
+		// leave a small margin around icons, like the original 60x60 on a 64px high display
+		iconSize := uint(height - height/16)
+		deejDisplay.logger.Debug(fmt.Sprintf("Resize to %dx%d", iconSize, iconSize))
+		resizedImg = resize.Resize(iconSize, iconSize, src, resize.Lanczos3)
+	} else if src.Bounds().Dx() > width || src.Bounds().Dy() > height {
+		// images drawn for another board's displays would otherwise be cropped
+		deejDisplay.logger.Debug(fmt.Sprintf("Shrink to fit %dx%d", width, height))
+		resizedImg = resize.Thumbnail(uint(width), uint(height), src, resize.Lanczos3)
 	} else {
 		resizedImg = src
 	}
-	// Create a blank canvas of size 128x64
-	deejDisplay.logger.Debug("Create new 128x64 image")
-	canvas := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	// Create a blank canvas the size of the board's displays
+	deejDisplay.logger.Debug(fmt.Sprintf("Create new %dx%d image", width, height))
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
 
 	// Compute the top-left corner coordinates for centering the image
 	startX := (canvas.Bounds().Dx() - resizedImg.Bounds().Dx()) / 2
@@ -285,58 +568,22 @@ func (deejDisplay *DeejDisplay) convertForDisplay(src image.Image, doResize bool
 		}
 	}
 
-	// canvas = deejDisplay.drawNumberOnImage(canvas, 10)
-
 	// Convert the canvas to black and white
 	if dithering {
 		deejDisplay.logger.Debug("Convert to black and white")
 		canvas = deejDisplay.floydSteinbergDithering(canvas)
 	}
-	deejDisplay.logger.Debug("Coverting to 1 bit image")
-	buf := deejDisplay.encode1Bit(canvas)
 
-	return buf
+	return canvas
 }
 
-func (deejDisplay *DeejDisplay) drawNumberOnImage(img image.Image, number int) *image.RGBA {
-	deejDisplay.logger.Debug("Drawing number on image")
-
-	// Convert the number to string
-	text := fmt.Sprintf("%d", number)
-
-	// Create a new image based on the original for drawing
-	dst := image.NewRGBA(img.Bounds())
-
-	// Copy the original image onto dst
-	draw.Draw(dst, dst.Bounds(), img, image.Point{}, draw.Over)
-
-	// Initialize the freetype context
-	c := freetype.NewContext()
-
-	// Set the font
-	f, err := freetype.ParseFont(goregular.TTF)
-	if err != nil {
-		log.Fatalf("Could not parse font: %v", err)
-	}
-	c.SetFont(f)
-
-	fontSize := float64(16)
-	// Set various properties
-	c.SetFontSize(fontSize) // Adjust as necessary
-	c.SetClip(dst.Bounds())
-	c.SetDst(dst)
-	c.SetSrc(image.NewUniform(color.RGBA{R: 255, G: 255, B: 255, A: 255})) // Red color
-
-	// Calculate the width of the text to align it to the top right corner
-	// pt := freetype.Pt(img.Bounds().Max.X-int(c.PointToFixed(24)>>6)*len(text), int(c.PointToFixed(24)>>6)-10)
-	pt := freetype.Pt(img.Bounds().Max.X-int(c.PointToFixed(fontSize)>>6)*len(text)+10, int(c.PointToFixed(fontSize)>>6))
-	// Draw the text
-	_, err = c.DrawString(text, pt)
-	if err != nil {
-		log.Fatalf("Could not draw text: %v", err)
+// displaySize returns the resolution of the board's displays, as announced during the handshake
+func (deejDisplay *DeejDisplay) displaySize() (int, int) {
+	if device := deejDisplay.deej.serial.identifiedDevice(); device != nil {
+		return device.DisplayWidth, device.DisplayHeight
 	}
 
-	return dst
+	return defaultDisplayWidth, defaultDisplayHeight
 }
 
 func otsuThreshold(img *image.RGBA) uint8 {
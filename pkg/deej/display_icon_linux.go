@@ -0,0 +1,351 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// linux executables don't carry icons like windows ones do. instead, each app installs a freedesktop desktop entry
+// (a .desktop file) that names its icon, and the icon itself is installed into one or more icon themes.
+// see https://specifications.freedesktop.org/desktop-entry-spec and https://specifications.freedesktop.org/icon-theme-spec
+
+// desktopEntry holds the parts of a desktop entry we use to tell which app it belongs to, and what its icon is
+type desktopEntry struct {
+	id       string // the file's name without its extension, e.g. "org.mozilla.firefox"
+	binary   string // the base name of the executable it runs
+	wmClass  string
+	iconName string // either an icon name to look up in themes, or an absolute path
+}
+
+const (
+
+	// scanning every desktop entry on each lookup is wasteful, but apps do get (un)installed while deej runs
+	desktopEntryCacheDuration = time.Minute
+
+	// icons are shrunk to roughly this size for the displays, so it's what we look for
+	preferredIconSize = 64
+
+	// svg icons are rasterized at this size, to leave enough detail for resizing later
+	svgRasterSize = 128
+
+	desktopEntryGroupHeader = "[Desktop Entry]"
+	desktopEntryExtension   = ".desktop"
+)
+
+var (
+	desktopEntries         []*desktopEntry
+	desktopEntriesLoadedAt time.Time
+	desktopEntriesLock     sync.Mutex
+)
+
+// icon directories are named after the icon sizes inside them, e.g. "48x48", "48x48@2" or just "48"
+var iconSizeDirPattern = regexp.MustCompile(`^(\d+)(x\d+)?(@\d+)?$`)
+
+// errIconNotFound means none of the candidate names led to an icon file
+var errIconNotFound = errors.New("icon not found")
+
+// resolveLinuxIcon finds the icon of an app by its process binary, trying any explicitly given icon names first
+// (i.e. the application.icon_name the sound server reports), then the icon of the app's desktop entry, then the binary's name itself
+func resolveLinuxIcon(processBinary string, iconNames []string) (image.Image, string, error) {
+	candidates := append([]string{}, iconNames...)
+
+	if entry := findDesktopEntry(processBinary); entry != nil && entry.iconName != "" {
+		candidates = append(candidates, entry.iconName)
+	}
+
+	// many themes name app icons after the binary, even without a desktop entry pointing at them
+	candidates = append(candidates, processBinary)
+
+	for _, iconName := range candidates {
+		iconPath := findIconFile(iconName)
+		if iconPath == "" {
+			continue
+		}
+
+		icon, err := loadIconFile(iconPath)
+		if err != nil {
+			return nil, iconPath, fmt.Errorf("load icon %s: %w", iconPath, err)
+		}
+
+		return icon, iconPath, nil
+	}
+
+	return nil, "", fmt.Errorf("resolve icon for %s: %w", processBinary, errIconNotFound)
+}
+
+// xdgDataDirs returns the directories desktop entries and icon themes are installed under, most specific first
+func xdgDataDirs() []string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	homeDir, _ := os.UserHomeDir()
+
+	if dataHome == "" && homeDir != "" {
+		dataHome = filepath.Join(homeDir, ".local", "share")
+	}
+
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = "/usr/local/share:/usr/share"
+	}
+
+	dirs := []string{}
+	if dataHome != "" {
+		dirs = append(dirs, dataHome)
+	}
+
+	dirs = append(dirs, filepath.SplitList(dataDirs)...)
+
+	// flatpak and snap apps export their entries here, which isn't always part of XDG_DATA_DIRS
+	if homeDir != "" {
+		dirs = append(dirs, filepath.Join(homeDir, ".local", "share", "flatpak", "exports", "share"))
+	}
+
+	dirs = append(dirs, "/var/lib/flatpak/exports/share", "/var/lib/snapd/desktop")
+
+	return dirs
+}
+
+// findDesktopEntry returns the desktop entry that most likely belongs to the given process binary, if any
+func findDesktopEntry(processBinary string) *desktopEntry {
+	processBinary = strings.ToLower(processBinary)
+
+	desktopEntriesLock.Lock()
+	defer desktopEntriesLock.Unlock()
+
+	if desktopEntries == nil || time.Since(desktopEntriesLoadedAt) > desktopEntryCacheDuration {
+		desktopEntries = loadDesktopEntries()
+		desktopEntriesLoadedAt = time.Now()
+	}
+
+	// matching on what the entry actually runs is the most reliable, the rest are increasingly loose guesses
+	matchers := []func(entry *desktopEntry) bool{
+		func(entry *desktopEntry) bool { return entry.binary == processBinary },
+		func(entry *desktopEntry) bool { return entry.id == processBinary },
+		func(entry *desktopEntry) bool { return entry.wmClass == processBinary },
+
+		// reverse-DNS ids, e.g. "org.mozilla.firefox" for "firefox"
+		func(entry *desktopEntry) bool { return strings.HasSuffix(entry.id, "."+processBinary) },
+	}
+
+	for _, matches := range matchers {
+		for _, entry := range desktopEntries {
+			if matches(entry) {
+				return entry
+			}
+		}
+	}
+
+	return nil
+}
+
+func loadDesktopEntries() []*desktopEntry {
+	entries := []*desktopEntry{}
+
+	// entries in more specific directories shadow those with the same id in less specific ones
+	seenIDs := map[string]bool{}
+
+	for _, dataDir := range xdgDataDirs() {
+		paths, _ := filepath.Glob(filepath.Join(dataDir, "applications", "*"+desktopEntryExtension))
+
+		for _, path := range paths {
+			entry, err := parseDesktopEntry(path)
+			if err != nil || seenIDs[entry.id] {
+				continue
+			}
+
+			seenIDs[entry.id] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+func parseDesktopEntry(path string) (*desktopEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open desktop entry: %w", err)
+	}
+	defer file.Close()
+
+	entry := &desktopEntry{
+		id: strings.ToLower(strings.TrimSuffix(filepath.Base(path), desktopEntryExtension)),
+	}
+
+	inMainGroup := false
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// entries may have other groups (i.e. for additional actions), which we don't care about
+		if strings.HasPrefix(line, "[") {
+			inMainGroup = line == desktopEntryGroupHeader
+			continue
+		}
+
+		if !inMainGroup {
+			continue
+		}
+
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+
+		key, value := strings.TrimSpace(keyValue[0]), strings.TrimSpace(keyValue[1])
+
+		switch key {
+		case "Exec":
+			entry.binary = execBinary(value)
+		case "StartupWMClass":
+			entry.wmClass = strings.ToLower(value)
+		case "Icon":
+			entry.iconName = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read desktop entry: %w", err)
+	}
+
+	return entry, nil
+}
+
+// execBinary returns the (lowercase) base name of the executable in a desktop entry's Exec key,
+// skipping over any "env VAR=value" prefix
+func execBinary(exec string) string {
+	for _, field := range strings.Fields(exec) {
+		field = strings.Trim(field, `"'`)
+
+		if field == "env" || strings.Contains(field, "=") {
+			continue
+		}
+
+		return strings.ToLower(filepath.Base(field))
+	}
+
+	return ""
+}
+
+// findIconFile returns the path to the best-suited file for the given icon name, or an empty string if there's none
+func findIconFile(iconName string) string {
+	if filepath.IsAbs(iconName) {
+		if _, err := os.Stat(iconName); err == nil {
+			return iconName
+		}
+
+		return ""
+	}
+
+	bestPath := ""
+	bestScore := 0
+
+	for _, dataDir := range xdgDataDirs() {
+
+		// the hicolor theme is where apps are required to install their icons, so it goes first.
+		// other themes can still have icons for apps that didn't install any (or nicer ones)
+		hicolorDir := filepath.Join(dataDir, "icons", "hicolor")
+		themeDirs := []string{hicolorDir}
+
+		otherThemeDirs, _ := filepath.Glob(filepath.Join(dataDir, "icons", "*"))
+		for _, themeDir := range otherThemeDirs {
+			if themeDir != hicolorDir {
+				themeDirs = append(themeDirs, themeDir)
+			}
+		}
+
+		for _, themeDir := range themeDirs {
+
+			// themes lay their directories out as either <size>/<context> or <context>/<size>
+			for _, pattern := range []string{"*/apps/%s.*", "apps/*/%s.*"} {
+				paths, _ := filepath.Glob(filepath.Join(themeDir, fmt.Sprintf(pattern, iconName)))
+
+				for _, path := range paths {
+					if score := iconFileScore(path); score > bestScore {
+						bestPath, bestScore = path, score
+					}
+				}
+			}
+		}
+
+		// the legacy location for icons that aren't part of any theme
+		for _, extension := range []string{".png", ".svg"} {
+			path := filepath.Join(dataDir, "pixmaps", iconName+extension)
+			if _, err := os.Stat(path); err == nil && bestPath == "" {
+				bestPath = path
+			}
+		}
+	}
+
+	return bestPath
+}
+
+// iconFileScore rates how well an icon file suits our displays, with 0 meaning not at all.
+// PNGs at (or just above) the preferred size are best, then svgs (which scale to any size), then other PNGs
+func iconFileScore(path string) int {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".svg":
+		return 1000
+	case ".png":
+	default:
+		return 0
+	}
+
+	// the size directory is either right above the context directory or right below it
+	size := 0
+	for _, dir := range []string{filepath.Base(filepath.Dir(path)), filepath.Base(filepath.Dir(filepath.Dir(path)))} {
+		if match := iconSizeDirPattern.FindStringSubmatch(dir); match != nil {
+			size, _ = strconv.Atoi(match[1])
+			break
+		}
+	}
+
+	if size >= preferredIconSize {
+		return 2000 - (size - preferredIconSize)
+	}
+
+	return size
+}
+
+func loadIconFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open icon file: %w", err)
+	}
+	defer file.Close()
+
+	if strings.ToLower(filepath.Ext(path)) != ".svg" {
+		icon, err := png.Decode(file)
+		if err != nil {
+			return nil, fmt.Errorf("decode png: %w", err)
+		}
+
+		return icon, nil
+	}
+
+	svgIcon, err := oksvg.ReadIconStream(file, oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("parse svg: %w", err)
+	}
+
+	svgIcon.SetTarget(0, 0, svgRasterSize, svgRasterSize)
+
+	icon := image.NewRGBA(image.Rect(0, 0, svgRasterSize, svgRasterSize))
+	scanner := rasterx.NewScannerGV(svgRasterSize, svgRasterSize, icon, icon.Bounds())
+	svgIcon.Draw(rasterx.NewDasher(svgRasterSize, svgRasterSize, scanner), 1)
+
+	return icon, nil
+}
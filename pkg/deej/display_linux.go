@@ -0,0 +1,32 @@
+package deej
+
+import (
+	"image"
+	"strings"
+)
+
+// lookupProcessIcon finds the icon of the app a process binary belongs to, through its desktop entry
+// and the installed icon themes. unlike on windows, the process doesn't need to be running for that
+func (deejDisplay *DeejDisplay) lookupProcessIcon(processName string) (image.Image, error) {
+	iconNames := []string{}
+
+	// apps playing audio may tell the sound server which icon they'd like to be shown with
+	if sessions, ok := deejDisplay.deej.sessions.get(strings.ToLower(processName)); ok {
+		for _, session := range sessions {
+			if propertySession, ok := session.(propertySession); ok {
+				if iconName, ok := propertySession.Property("application.icon_name"); ok && iconName != "" {
+					iconNames = append(iconNames, iconName)
+				}
+			}
+		}
+	}
+
+	icon, iconPath, err := resolveLinuxIcon(processName, iconNames)
+	if err != nil {
+		return nil, err
+	}
+
+	deejDisplay.logger.Debugw("Resolved process icon", "processName", processName, "iconPath", iconPath)
+
+	return icon, nil
+}
@@ -0,0 +1,173 @@
+package deej
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// the volume overlay shows the current volume of each display's slider on top of its image,
+// as a percentage in the top right corner and/or a bar along the display's right edge
+const (
+	volumeOverlayNone       = "none"
+	volumeOverlayPercentage = "percentage"
+	volumeOverlayBar        = "bar"
+	volumeOverlayBoth       = "both"
+
+	// sliders move a lot faster than frames can be sent, so changed volumes are only drawn this often
+	volumeOverlayRefreshInterval = 150 * time.Millisecond
+)
+
+var supportedVolumeOverlays = []string{volumeOverlayNone, volumeOverlayPercentage, volumeOverlayBar, volumeOverlayBoth}
+
+func (deejDisplay *DeejDisplay) setupOnSliderMove() {
+	sliderEventsChannel := deejDisplay.deej.serial.SubscribeToSliderMoveEvents()
+
+	go func() {
+		ticker := time.NewTicker(volumeOverlayRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+
+			// don't do any actual work here, this blocks the serial read loop
+			case event := <-sliderEventsChannel:
+				volume := deejDisplay.deej.config.sliderCurve(event.SliderID).apply(event.PercentValue)
+
+				deejDisplay.overlayLock.Lock()
+				deejDisplay.volumes[event.SliderID] = volume
+				deejDisplay.changedVolumes[event.SliderID] = true
+				deejDisplay.overlayLock.Unlock()
+
+			case <-ticker.C:
+				deejDisplay.renderChangedVolumes()
+			}
+		}
+	}()
+}
+
+// renderChangedVolumes redraws every display whose slider moved since the last time
+func (deejDisplay *DeejDisplay) renderChangedVolumes() {
+	deejDisplay.overlayLock.Lock()
+	changedVolumes := deejDisplay.changedVolumes
+	deejDisplay.changedVolumes = map[int]bool{}
+	deejDisplay.overlayLock.Unlock()
+
+	displayConfig := deejDisplay.deej.config.displayConfig()
+	if !displayConfig.Enabled || displayConfig.VolumeOverlay == volumeOverlayNone {
+		return
+	}
+
+	// displays are numbered the same as the sliders they belong to, but only mapped ones are drawn on
+	for _, displayMap := range displayConfig.DisplayMapping {
+		if !changedVolumes[displayMap.display_idx] {
+			continue
+		}
+
+		deejDisplay.overlayLock.Lock()
+		baseImage, ok := deejDisplay.baseImages[displayMap.display_idx]
+		deejDisplay.overlayLock.Unlock()
+
+		// if there's no image yet (i.e. its app isn't running), the volume is still worth showing
+		if !ok {
+			width, height := deejDisplay.displaySize()
+			baseImage = image.NewRGBA(image.Rect(0, 0, width, height))
+		}
+
+		deejDisplay.sendData(displayMap.display_idx, deejDisplay.encode1Bit(deejDisplay.withOverlay(displayMap.display_idx, baseImage)))
+	}
+}
+
+// showImage sends an image to a display, remembering it so that the volume overlay can be redrawn on top of it later
+func (deejDisplay *DeejDisplay) showImage(display_idx int, img *image.RGBA) {
+	deejDisplay.overlayLock.Lock()
+	deejDisplay.baseImages[display_idx] = img
+	deejDisplay.overlayLock.Unlock()
+
+	deejDisplay.sendData(display_idx, deejDisplay.encode1Bit(deejDisplay.withOverlay(display_idx, img)))
+}
+
+// withOverlay returns a copy of the given image with the volume overlay drawn on it,
+// or the image itself if there's nothing to draw (yet)
+func (deejDisplay *DeejDisplay) withOverlay(display_idx int, img *image.RGBA) *image.RGBA {
+	overlay := deejDisplay.deej.config.displayConfig().VolumeOverlay
+
+	deejDisplay.overlayLock.Lock()
+	volume, ok := deejDisplay.volumes[display_idx]
+	deejDisplay.overlayLock.Unlock()
+
+	if !ok || overlay == volumeOverlayNone {
+		return img
+	}
+
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	// the percentage goes to the left of the bar, if there is one
+	textRight := dst.Bounds().Max.X
+
+	if overlay == volumeOverlayBar || overlay == volumeOverlayBoth {
+		textRight = deejDisplay.drawVolumeBar(dst, volume)
+	}
+
+	if overlay == volumeOverlayPercentage || overlay == volumeOverlayBoth {
+		deejDisplay.drawVolumeText(dst, volume, textRight)
+	}
+
+	return dst
+}
+
+// drawVolumeBar draws a vertical bar along the right edge of the image, filled from the bottom.
+// it returns the x coordinate of the bar's left edge (including some spacing)
+func (deejDisplay *DeejDisplay) drawVolumeBar(dst *image.RGBA, volume float32) int {
+	bounds := dst.Bounds()
+
+	barWidth := bounds.Dx() / 24
+	if barWidth < 3 {
+		barWidth = 3
+	}
+
+	bar := image.Rect(bounds.Max.X-barWidth, bounds.Min.Y, bounds.Max.X, bounds.Max.Y)
+	fillHeight := int(float32(bar.Dy()-2) * volume)
+
+	// a black background keeps the bar readable on top of the image, and a white outline shows its extent
+	draw.Draw(dst, bar.Inset(-1), image.Black, image.Point{}, draw.Src)
+	draw.Draw(dst, bar, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, bar.Inset(1), image.Black, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(bar.Min.X+1, bar.Max.Y-1-fillHeight, bar.Max.X-1, bar.Max.Y-1), image.White, image.Point{}, draw.Src)
+
+	return bar.Min.X - 2
+}
+
+// drawVolumeText draws the volume as a percentage in the top right corner of the image, ending at the given x
+func (deejDisplay *DeejDisplay) drawVolumeText(dst *image.RGBA, volume float32, right int) {
+	face := truetype.NewFace(deejDisplay.overlayFont, &truetype.Options{
+		Size:    float64(dst.Bounds().Dy()) / 4,
+		Hinting: font.HintingFull,
+	})
+	defer face.Close()
+
+	text := fmt.Sprintf("%d%%", int(volume*100+0.5))
+
+	drawer := &font.Drawer{
+		Dst:  dst,
+		Src:  image.White,
+		Face: face,
+	}
+
+	metrics := face.Metrics()
+	textWidth := drawer.MeasureString(text).Ceil()
+	textHeight := (metrics.Ascent + metrics.Descent).Ceil()
+	left := right - textWidth
+
+	// clear the area behind the text so it stays readable on top of the image
+	draw.Draw(dst, image.Rect(left-1, dst.Bounds().Min.Y, right, dst.Bounds().Min.Y+textHeight), image.Black, image.Point{}, draw.Src)
+
+	drawer.Dot = fixed.P(left, dst.Bounds().Min.Y+metrics.Ascent.Ceil())
+	drawer.DrawString(text)
+}
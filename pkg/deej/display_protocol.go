@@ -0,0 +1,160 @@
+package deej
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// frames carry display data in a way that can't be confused with its contents, and that the firmware can verify.
+// each frame looks like this (multi-byte fields are big endian):
+//
+//	magic (2) | type (1) | display index (1) | sequence (1) | payload length (2) | payload | crc32 (4)
+//
+// the crc covers everything between the magic and itself. the firmware replies to every frame with a line:
+// "ACK|<sequence>" once it's been drawn, or "NAK|<sequence>" if it was damaged on the way (and should be resent)
+var frameMagic = []byte{0xDE, 0xE7}
+
+const (
+
+	// a full 1-bit bitmap, drawn row by row
+	frameTypeImage byte = 0x01
+
+	// a full bitmap, run-length encoded as (count, byte) pairs. mostly-black icons shrink to a fraction of their size
+	frameTypeRLE byte = 0x02
+
+	// only the bytes that changed since the last frame the display acknowledged, as (offset, length, bytes...) runs
+	// where the offset is 2 bytes and the length is 1. the firmware rejects these if it no longer has that frame,
+	// which is whenever it drew another display since (all of them share one buffer)
+	frameTypeDelta byte = 0x03
+
+	// the firmware's frame buffer doesn't hold any display's last frame (i.e. right after connecting)
+	noBufferedDisplay = -1
+
+	frameHeaderSize  = 7
+	frameTrailerSize = 4
+
+	// how many times we try to get a frame across before giving up on it
+	maxFrameAttempts = 3
+
+	// how long the firmware gets to reply to a frame, on top of the time it takes to transfer it
+	frameReplyTimeout = 500 * time.Millisecond
+)
+
+// frameReply represents the firmware's verdict on a single frame
+type frameReply struct {
+	Sequence byte
+	Ack      bool
+}
+
+var frameReplyPattern = regexp.MustCompile(`^(ACK|NAK)\|(\d{1,3})\r?\n$`)
+
+// encodeFrame wraps the given payload in a frame
+func encodeFrame(frameType byte, displayIdx int, sequence byte, payload []byte) []byte {
+	frame := &bytes.Buffer{}
+
+	frame.Write(frameMagic)
+	frame.WriteByte(frameType)
+	frame.WriteByte(byte(displayIdx))
+	frame.WriteByte(sequence)
+	binary.Write(frame, binary.BigEndian, uint16(len(payload)))
+	frame.Write(payload)
+
+	checksum := crc32.ChecksumIEEE(frame.Bytes()[len(frameMagic):])
+	binary.Write(frame, binary.BigEndian, checksum)
+
+	return frame.Bytes()
+}
+
+// encodeRLE run-length encodes a bitmap as (count, byte) pairs, with counts between 1 and 255
+func encodeRLE(bitmap []byte) []byte {
+	encoded := []byte{}
+
+	for idx := 0; idx < len(bitmap); {
+		value := bitmap[idx]
+
+		count := 1
+		for idx+count < len(bitmap) && bitmap[idx+count] == value && count < 255 {
+			count++
+		}
+
+		encoded = append(encoded, byte(count), value)
+		idx += count
+	}
+
+	return encoded
+}
+
+// encodeDelta encodes the runs of bytes that differ between two bitmaps of the same size
+func encodeDelta(previous []byte, current []byte) []byte {
+	const (
+		runHeaderSize = 3
+		maxRunLength  = 255
+	)
+
+	encoded := &bytes.Buffer{}
+
+	for idx := 0; idx < len(current); {
+		if current[idx] == previous[idx] {
+			idx++
+			continue
+		}
+
+		// extend the run over unchanged gaps that are cheaper to resend than to start a new run after
+		start, end := idx, idx+1
+		for end < len(current) && end-start < maxRunLength {
+			if current[end] != previous[end] {
+				end++
+				continue
+			}
+
+			gapEnd := end
+			for gapEnd < len(current) && current[gapEnd] == previous[gapEnd] && gapEnd-end < runHeaderSize {
+				gapEnd++
+			}
+
+			if gapEnd-end >= runHeaderSize || gapEnd == len(current) || gapEnd-start > maxRunLength {
+				break
+			}
+
+			end = gapEnd
+		}
+
+		binary.Write(encoded, binary.BigEndian, uint16(start))
+		encoded.WriteByte(byte(end - start))
+		encoded.Write(current[start:end])
+
+		idx = end
+	}
+
+	return encoded.Bytes()
+}
+
+// parseFrameReply reads an ACK/NAK line, which is guaranteed to match frameReplyPattern
+func parseFrameReply(line string) (frameReply, error) {
+	splitLine := strings.Split(strings.TrimRight(line, "\r\n"), "|")
+
+	sequence, err := strconv.Atoi(splitLine[1])
+	if err != nil || sequence > 255 {
+		return frameReply{}, fmt.Errorf("invalid frame sequence: %s", splitLine[1])
+	}
+
+	return frameReply{
+		Sequence: byte(sequence),
+		Ack:      splitLine[0] == "ACK",
+	}, nil
+}
+
+// encodeLegacyFrame wraps the given bitmap in the original, marker-based format.
+// it has no way of telling whether the bitmap arrived intact, and bitmaps that contain the end marker are cut short
+func encodeLegacyFrame(displayIdx int, bitmap []byte) []byte {
+	data := append([]byte(fmt.Sprintf("<<START>>%d|", displayIdx)), bitmap...)
+	data = append(data, []byte("<<END>>.....")...)
+
+	return data
+}
@@ -0,0 +1,36 @@
+package deej
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/fcjr/geticon"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// lookupProcessIcon extracts the icon embedded in the executable of a running process with the given name
+func (deejDisplay *DeejDisplay) lookupProcessIcon(processName string) (image.Image, error) {
+	pid, err := deejDisplay.getPIDByExeName(processName)
+	deejDisplay.logger.Info("Got PID: ", pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return geticon.FromPid(pid)
+}
+
+func (deejDisplay *DeejDisplay) getPIDByExeName(exeName string) (uint32, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return 0, err
+	}
+	deejDisplay.logger.Debug("Got process list")
+	for _, p := range procs {
+		name, err := p.Name()
+		if err == nil && strings.ToLower(name) == strings.ToLower(exeName) {
+			return uint32(p.Pid), nil
+		}
+	}
+	return 0, fmt.Errorf("no process found with exe name: %s", exeName)
+}
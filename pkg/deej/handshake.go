@@ -0,0 +1,184 @@
+package deej
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thoas/go-funk"
+)
+
+// deviceInfo describes the board we're connected to, as announced by its firmware during the handshake
+type deviceInfo struct {
+	ProtocolVersion int
+	NumSliders      int
+	NumDisplays     int
+	DisplayWidth    int
+	DisplayHeight   int
+	Capabilities    []string
+}
+
+const (
+
+	// firmware that never announces itself is assumed to speak this version
+	legacyProtocolVersion = 1
+
+	// the newest protocol version we know how to speak
+	maxSupportedProtocolVersion = 2
+
+	// sent by us right after connecting, to ask the firmware to announce itself.
+	// firmware should also announce itself on boot, since connecting resets most boards
+	handshakeRequest = "<<HELLO>>"
+
+	// how long to wait for an announcement before assuming we're talking to legacy firmware
+	handshakeTimeout = 3 * time.Second
+
+	// used for slider/display counts we can't know, because the firmware didn't tell us
+	unknownCount = -1
+
+	// what the original firmware's displays look like
+	defaultDisplayWidth  = 128
+	defaultDisplayHeight = 64
+)
+
+// announcements are a single line of pipe-separated key=value pairs, for instance:
+// "DEEJ|version=2|sliders=5|displays=5|resolution=128x64|capabilities=displays,buttons"
+var handshakeLinePattern = regexp.MustCompile(`^DEEJ(\|[a-z_]+=[a-zA-Z0-9_,]*)+\r?\n$`)
+
+const (
+	handshakeKeyVersion      = "version"
+	handshakeKeySliders      = "sliders"
+	handshakeKeyDisplays     = "displays"
+	handshakeKeyResolution   = "resolution"
+	handshakeKeyCapabilities = "capabilities"
+)
+
+// optional features a board can announce
+const (
+	capabilityDisplays = "displays"
+	capabilityButtons  = "buttons"
+	capabilityEncoders = "encoders"
+
+	// display data can be sent in checksummed frames (see display_protocol.go),
+	// which can in turn be run-length encoded or only hold what changed since the display's last frame
+	capabilityFrames = "frames"
+	capabilityRLE    = "rle"
+	capabilityDelta  = "delta"
+
+	// the board wants to know what its sliders' sessions are actually at, i.e. to show it on LEDs (see levelsCommandFormat)
+	capabilityLevels = "levels"
+
+	// the board's sliders are motorized faders, which deej can move (see motorCommandFormat)
+	capabilityMotors = "motors"
+)
+
+// capabilities we know how to use, anything else announced by the firmware is ignored
+var supportedCapabilities = []string{
+	capabilityDisplays,
+	capabilityButtons,
+	capabilityEncoders,
+	capabilityFrames,
+	capabilityRLE,
+	capabilityDelta,
+	capabilityLevels,
+	capabilityMotors,
+}
+
+// legacyDeviceInfo describes what we assume about firmware that doesn't announce itself,
+// which is what deej always assumed: everything is there, and the config knows best
+func legacyDeviceInfo() *deviceInfo {
+	return &deviceInfo{
+		ProtocolVersion: legacyProtocolVersion,
+		NumSliders:      unknownCount,
+		NumDisplays:     unknownCount,
+		DisplayWidth:    defaultDisplayWidth,
+		DisplayHeight:   defaultDisplayHeight,
+		Capabilities:    []string{capabilityDisplays, capabilityButtons, capabilityEncoders},
+	}
+}
+
+// parseHandshake reads a firmware announcement. unknown keys are ignored (newer firmware may add some),
+// and missing ones keep their legacy values - except for capabilities, which must be announced to be used
+func parseHandshake(line string) (*deviceInfo, error) {
+	info := legacyDeviceInfo()
+	info.Capabilities = []string{}
+
+	// the pattern guarantees at least one segment after the prefix, and an equals sign in each of them
+	segments := strings.Split(strings.TrimRight(line, "\r\n"), "|")[1:]
+
+	for _, segment := range segments {
+		keyValue := strings.SplitN(segment, "=", 2)
+		key, value := keyValue[0], keyValue[1]
+
+		var err error
+
+		switch key {
+		case handshakeKeyVersion:
+			info.ProtocolVersion, err = strconv.Atoi(value)
+		case handshakeKeySliders:
+			info.NumSliders, err = strconv.Atoi(value)
+		case handshakeKeyDisplays:
+			info.NumDisplays, err = strconv.Atoi(value)
+		case handshakeKeyResolution:
+			info.DisplayWidth, info.DisplayHeight, err = parseResolution(value)
+		case handshakeKeyCapabilities:
+			if value != "" {
+				info.Capabilities = strings.Split(strings.ToLower(value), ",")
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("parse handshake key %s: %w", key, err)
+		}
+	}
+
+	if info.ProtocolVersion < legacyProtocolVersion {
+		return nil, fmt.Errorf("invalid protocol version: %d", info.ProtocolVersion)
+	}
+
+	return info, nil
+}
+
+// parseResolution parses a "<width>x<height>" string
+func parseResolution(value string) (int, int, error) {
+	splitValue := strings.SplitN(value, "x", 2)
+	if len(splitValue) != 2 {
+		return 0, 0, fmt.Errorf("invalid resolution: %s", value)
+	}
+
+	width, widthErr := strconv.Atoi(splitValue[0])
+	height, heightErr := strconv.Atoi(splitValue[1])
+
+	if widthErr != nil || heightErr != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid resolution: %s", value)
+	}
+
+	return width, height, nil
+}
+
+// supports returns true if the board announced the given capability, and we know how to use it
+func (info *deviceInfo) supports(capability string) bool {
+	return funk.ContainsString(info.Capabilities, capability) &&
+		funk.ContainsString(supportedCapabilities, capability)
+}
+
+// hasDisplay returns true if a display with the given index exists on the board (or if we can't tell)
+func (info *deviceInfo) hasDisplay(displayIdx int) bool {
+	if !info.supports(capabilityDisplays) {
+		return false
+	}
+
+	return info.NumDisplays == unknownCount || (displayIdx >= 0 && displayIdx < info.NumDisplays)
+}
+
+func (info *deviceInfo) String() string {
+	return fmt.Sprintf("<protocol v%d, %d sliders, %d displays (%dx%d), capabilities: %s>",
+		info.ProtocolVersion,
+		info.NumSliders,
+		info.NumDisplays,
+		info.DisplayWidth,
+		info.DisplayHeight,
+		strings.Join(info.Capabilities, ","))
+}
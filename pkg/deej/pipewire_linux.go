@@ -0,0 +1,390 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pwConnection speaks PipeWire's native protocol over its unix socket. every message is addressed to an object
+// (by its id on our side of the connection), and is either a method call (sent by us) or an event (sent by the server).
+// see https://docs.pipewire.org/page_native_protocol.html
+type pwConnection struct {
+	logger *zap.SugaredLogger
+
+	conn   *net.UnixConn
+	socket *pwSocketReader
+	reader *bufio.Reader
+
+	writeLock sync.Mutex
+	sequence  uint32
+
+	// ids of objects we create on our side, which we're free to choose as long as we never skip one
+	nextObjectID uint32
+	objectIDLock sync.Mutex
+
+	// waiting roundtrips, by the sequence number of their sync
+	pendingSyncs     map[int32]chan bool
+	pendingSyncsLock sync.Mutex
+	nextSyncSequence int32
+
+	// called on the read loop for events addressed to anything other than the core itself
+	onEvent func(objectID uint32, opcode byte, payload pod)
+
+	closed chan bool
+}
+
+// object ids that exist from the start of every connection
+const (
+	pwCoreID   uint32 = 0
+	pwClientID uint32 = 1
+)
+
+// the interfaces and versions we know how to talk to
+const (
+	pwTypeNode     = "PipeWire:Interface:Node"
+	pwTypeDevice   = "PipeWire:Interface:Device"
+	pwTypeMetadata = "PipeWire:Interface:Metadata"
+
+	pwCoreVersion     = 3
+	pwRegistryVersion = 3
+	pwNodeVersion     = 3
+	pwDeviceVersion   = 3
+	pwMetadataVersion = 3
+)
+
+// method and event opcodes, per interface (see the pipewire/extensions/protocol-native headers)
+const (
+	pwCoreMethodHello       byte = 1
+	pwCoreMethodSync        byte = 2
+	pwCoreMethodPong        byte = 3
+	pwCoreMethodGetRegistry byte = 5
+
+	pwCoreEventDone  byte = 1
+	pwCoreEventPing  byte = 2
+	pwCoreEventError byte = 3
+
+	pwClientMethodUpdateProperties byte = 2
+
+	pwRegistryMethodBind byte = 1
+
+	pwRegistryEventGlobal       byte = 0
+	pwRegistryEventGlobalRemove byte = 1
+
+	// nodes and devices share these
+	pwMethodSubscribeParams byte = 1
+	pwMethodSetParam        byte = 3
+
+	pwEventInfo  byte = 0
+	pwEventParam byte = 1
+
+	pwMetadataEventProperty byte = 0
+)
+
+const (
+	pwMessageHeaderSize = 16
+
+	// message sizes share their field with the opcode, leaving them 24 bits
+	pwMaxMessageSize = 1<<24 - 1
+
+	// the most file descriptors the server attaches to a single message (see pipewire's connection.c)
+	pwMaxMessageFds = 28
+
+	pwDefaultRemoteName = "pipewire-0"
+
+	pwRoundtripTimeout = 2 * time.Second
+)
+
+var errPipeWireConnectionClosed = errors.New("PipeWire connection closed")
+
+// pipeWireSocketPath returns the path to the PipeWire socket: PIPEWIRE_REMOTE if it's a path,
+// or the remote by that name (pipewire-0 by default) in the user's runtime directory
+func pipeWireSocketPath() string {
+	remoteName := os.Getenv("PIPEWIRE_REMOTE")
+	if remoteName == "" {
+		remoteName = pwDefaultRemoteName
+	}
+
+	if filepath.IsAbs(remoteName) {
+		return remoteName
+	}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, remoteName)
+	}
+
+	return filepath.Join("/run/user", fmt.Sprint(os.Getuid()), remoteName)
+}
+
+// newPWConnection connects to the PipeWire server and introduces us to it.
+// events start being handed to onEvent right away, including some that arrive before this returns
+func newPWConnection(logger *zap.SugaredLogger, onEvent func(objectID uint32, opcode byte, payload pod)) (*pwConnection, error) {
+	socketPath := pipeWireSocketPath()
+
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to PipeWire socket %s: %w", socketPath, err)
+	}
+
+	socket := newPWSocketReader(conn)
+
+	c := &pwConnection{
+		logger:       logger,
+		conn:         conn,
+		socket:       socket,
+		reader:       bufio.NewReader(socket),
+		nextObjectID: pwClientID + 1,
+		pendingSyncs: map[int32]chan bool{},
+		onEvent:      onEvent,
+		closed:       make(chan bool),
+	}
+
+	go c.readLoop()
+
+	if err := c.send(pwCoreID, pwCoreMethodHello, podStruct(podInt(pwCoreVersion))); err != nil {
+		c.close()
+		return nil, fmt.Errorf("say hello to PipeWire: %w", err)
+	}
+
+	clientProperties := map[string]string{
+		"application.name": "deej",
+	}
+
+	if err := c.send(pwClientID, pwClientMethodUpdateProperties, podStruct(podDict(clientProperties))); err != nil {
+		c.close()
+		return nil, fmt.Errorf("update PipeWire client properties: %w", err)
+	}
+
+	return c, nil
+}
+
+// newObjectID reserves an id for an object we're about to create or bind
+func (c *pwConnection) newObjectID() uint32 {
+	c.objectIDLock.Lock()
+	defer c.objectIDLock.Unlock()
+
+	id := c.nextObjectID
+	c.nextObjectID++
+
+	return id
+}
+
+// getRegistry creates the registry object, which tells us about every global object on the server (and lets us bind them)
+func (c *pwConnection) getRegistry() (uint32, error) {
+	registryID := c.newObjectID()
+
+	if err := c.send(pwCoreID, pwCoreMethodGetRegistry, podStruct(podInt(pwRegistryVersion), podInt(int32(registryID)))); err != nil {
+		return 0, fmt.Errorf("get PipeWire registry: %w", err)
+	}
+
+	return registryID, nil
+}
+
+// bind creates an object on our side for the given global, through which we can call its methods and get its events
+func (c *pwConnection) bind(registryID uint32, globalID uint32, globalType string, version int32) (uint32, error) {
+	objectID := c.newObjectID()
+
+	payload := podStruct(podInt(int32(globalID)), podString(globalType), podInt(version), podInt(int32(objectID)))
+
+	if err := c.send(registryID, pwRegistryMethodBind, payload); err != nil {
+		return 0, fmt.Errorf("bind PipeWire global %d: %w", globalID, err)
+	}
+
+	return objectID, nil
+}
+
+// roundtrip waits until the server has processed everything we sent so far, and we've read everything it sent in return
+func (c *pwConnection) roundtrip() error {
+	c.pendingSyncsLock.Lock()
+	c.nextSyncSequence++
+	syncSequence := c.nextSyncSequence
+	done := make(chan bool, 1)
+	c.pendingSyncs[syncSequence] = done
+	c.pendingSyncsLock.Unlock()
+
+	defer func() {
+		c.pendingSyncsLock.Lock()
+		delete(c.pendingSyncs, syncSequence)
+		c.pendingSyncsLock.Unlock()
+	}()
+
+	if err := c.send(pwCoreID, pwCoreMethodSync, podStruct(podInt(int32(pwCoreID)), podInt(syncSequence))); err != nil {
+		return fmt.Errorf("sync with PipeWire: %w", err)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-c.closed:
+		return errPipeWireConnectionClosed
+	case <-time.After(pwRoundtripTimeout):
+		return fmt.Errorf("sync with PipeWire: timed out after %s", pwRoundtripTimeout)
+	}
+}
+
+// send calls a method on one of our objects
+func (c *pwConnection) send(objectID uint32, opcode byte, payload []byte) error {
+	if len(payload) > pwMaxMessageSize {
+		return fmt.Errorf("message too large: %d bytes", len(payload))
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.sequence++
+
+	// header: object id, opcode and payload size, sequence number, number of attached file descriptors
+	message := make([]byte, pwMessageHeaderSize, pwMessageHeaderSize+len(payload))
+	podByteOrder.PutUint32(message[0:], objectID)
+	podByteOrder.PutUint32(message[4:], uint32(opcode)<<24|uint32(len(payload)))
+	podByteOrder.PutUint32(message[8:], c.sequence)
+	podByteOrder.PutUint32(message[12:], 0)
+
+	if _, err := c.conn.Write(append(message, payload...)); err != nil {
+		return fmt.Errorf("write PipeWire message: %w", err)
+	}
+
+	return nil
+}
+
+func (c *pwConnection) readLoop() {
+	defer close(c.closed)
+
+	header := make([]byte, pwMessageHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(c.reader, header); err != nil {
+			c.logger.Debugw("PipeWire connection closed", "error", err)
+			return
+		}
+
+		objectID := podByteOrder.Uint32(header[0:])
+		opcode := byte(podByteOrder.Uint32(header[4:]) >> 24)
+		size := podByteOrder.Uint32(header[4:]) & pwMaxMessageSize
+		fdCount := int(podByteOrder.Uint32(header[12:]))
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			c.logger.Debugw("PipeWire connection closed", "error", err)
+			return
+		}
+
+		// none of the messages we handle carry file descriptors (they're for shared memory and the like),
+		// but they still arrive along with their message and would otherwise stay open for good
+		if fdCount > 0 {
+			closed := c.socket.closeFds(fdCount)
+			c.logger.Debugw("Closed file descriptors attached to PipeWire message",
+				"objectID", objectID, "opcode", opcode, "count", closed)
+		}
+
+		// newer servers may follow the payload with a footer, which we have no use for
+		message, _, err := decodePod(payload)
+		if err != nil {
+			c.logger.Warnw("Failed to decode PipeWire message", "objectID", objectID, "opcode", opcode, "error", err)
+			continue
+		}
+
+		if objectID == pwCoreID {
+			c.handleCoreEvent(opcode, message)
+			continue
+		}
+
+		c.onEvent(objectID, opcode, message)
+	}
+}
+
+func (c *pwConnection) handleCoreEvent(opcode byte, message pod) {
+	fields, err := message.structFields()
+	if err != nil || len(fields) < 2 {
+		return
+	}
+
+	id, _ := fields[0].intValue()
+	sequence, _ := fields[1].intValue()
+
+	switch opcode {
+	case pwCoreEventDone:
+		c.pendingSyncsLock.Lock()
+		done, ok := c.pendingSyncs[sequence]
+		c.pendingSyncsLock.Unlock()
+
+		if ok {
+			done <- true
+		}
+
+	// the server pings clients to make sure they're still around, and disconnects them if they don't answer
+	case pwCoreEventPing:
+		if err := c.send(pwCoreID, pwCoreMethodPong, podStruct(podInt(id), podInt(sequence))); err != nil {
+			c.logger.Warnw("Failed to answer PipeWire ping", "error", err)
+		}
+
+	case pwCoreEventError:
+		errorMessage := ""
+		if len(fields) >= 4 {
+			errorMessage, _ = fields[3].stringValue()
+		}
+
+		c.logger.Warnw("PipeWire reported an error", "objectID", id, "message", errorMessage)
+	}
+}
+
+func (c *pwConnection) close() error {
+	return c.conn.Close()
+}
+
+// pwSocketReader reads from the PipeWire socket while collecting the file descriptors that come with its messages.
+// the server sends each message's descriptors along with its first byte, so by the time a message's header
+// has been read (even through a buffer), its descriptors are always next in line
+type pwSocketReader struct {
+	conn *net.UnixConn
+	oob  []byte
+	fds  []int
+}
+
+func newPWSocketReader(conn *net.UnixConn) *pwSocketReader {
+	return &pwSocketReader{
+		conn: conn,
+		oob:  make([]byte, syscall.CmsgSpace(pwMaxMessageFds*4)),
+	}
+}
+
+func (r *pwSocketReader) Read(p []byte) (int, error) {
+	n, oobn, _, _, err := r.conn.ReadMsgUnix(p, r.oob)
+
+	if oobn > 0 {
+		messages, parseErr := syscall.ParseSocketControlMessage(r.oob[:oobn])
+		if parseErr == nil {
+			for _, message := range messages {
+				if fds, rightsErr := syscall.ParseUnixRights(&message); rightsErr == nil {
+					r.fds = append(r.fds, fds...)
+				}
+			}
+		}
+	}
+
+	return n, err
+}
+
+// closeFds closes the next count received file descriptors, returning how many there were
+func (r *pwSocketReader) closeFds(count int) int {
+	if count > len(r.fds) {
+		count = len(r.fds)
+	}
+
+	for _, fd := range r.fds[:count] {
+		syscall.Close(fd)
+	}
+
+	r.fds = r.fds[count:]
+
+	return count
+}
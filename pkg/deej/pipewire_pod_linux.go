@@ -0,0 +1,371 @@
+package deej
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// PipeWire messages are made of SPA PODs ("plain old data"): self-describing values that start with their
+// body's size and type, and are padded to 8 bytes. only the types we actually send or receive are handled here.
+// see https://docs.pipewire.org/page_spa_pod.html
+
+// POD types (see spa/utils/type.h)
+const (
+	podTypeNone   uint32 = 1
+	podTypeBool   uint32 = 2
+	podTypeID     uint32 = 3
+	podTypeInt    uint32 = 4
+	podTypeLong   uint32 = 5
+	podTypeFloat  uint32 = 6
+	podTypeString uint32 = 8
+	podTypeArray  uint32 = 13
+	podTypeStruct uint32 = 14
+	podTypeObject uint32 = 15
+	podTypeChoice uint32 = 19
+
+	podHeaderSize = 8
+)
+
+// pipewire runs on little endian machines in practice, and its protocol uses the machine's byte order
+var podByteOrder = binary.LittleEndian
+
+var errMalformedPod = errors.New("malformed pod")
+
+// pod is a single decoded POD, whose body is interpreted according to its type by the methods below
+type pod struct {
+	Type uint32
+	Body []byte
+}
+
+// podProperty is a single key-value pair of a decoded object POD
+type podProperty struct {
+	Key   uint32
+	Flags uint32
+	Value pod
+}
+
+// podEntry is a single key-value pair of an object POD that's about to be encoded
+type podEntry struct {
+	Key   uint32
+	Value []byte // an already-encoded POD
+}
+
+func podPaddedSize(size int) int {
+	return (size + 7) &^ 7
+}
+
+// encodePod wraps the given body in a POD of the given type, padding it as needed
+func encodePod(podType uint32, body []byte) []byte {
+	encoded := make([]byte, podHeaderSize+podPaddedSize(len(body)))
+
+	podByteOrder.PutUint32(encoded[0:], uint32(len(body)))
+	podByteOrder.PutUint32(encoded[4:], podType)
+	copy(encoded[podHeaderSize:], body)
+
+	return encoded
+}
+
+func encodeUint32(value uint32) []byte {
+	body := make([]byte, 4)
+	podByteOrder.PutUint32(body, value)
+
+	return body
+}
+
+func podBool(value bool) []byte {
+	if value {
+		return encodePod(podTypeBool, encodeUint32(1))
+	}
+
+	return encodePod(podTypeBool, encodeUint32(0))
+}
+
+func podID(value uint32) []byte {
+	return encodePod(podTypeID, encodeUint32(value))
+}
+
+func podInt(value int32) []byte {
+	return encodePod(podTypeInt, encodeUint32(uint32(value)))
+}
+
+func podString(value string) []byte {
+	return encodePod(podTypeString, append([]byte(value), 0))
+}
+
+// podStruct wraps already-encoded PODs in a struct POD
+func podStruct(children ...[]byte) []byte {
+	body := []byte{}
+	for _, child := range children {
+		body = append(body, child...)
+	}
+
+	return encodePod(podTypeStruct, body)
+}
+
+// podDict encodes a dictionary the way PipeWire does: a struct of its size, followed by its keys and values
+func podDict(dict map[string]string) []byte {
+	children := [][]byte{podInt(int32(len(dict)))}
+
+	for key, value := range dict {
+		children = append(children, podString(key), podString(value))
+	}
+
+	return podStruct(children...)
+}
+
+// podArray encodes an array of 4-byte values of the given type (i.e. ids or floats)
+func podArray(childType uint32, values []uint32) []byte {
+	body := make([]byte, podHeaderSize+4*len(values))
+
+	podByteOrder.PutUint32(body[0:], 4)
+	podByteOrder.PutUint32(body[4:], childType)
+
+	for idx, value := range values {
+		podByteOrder.PutUint32(body[podHeaderSize+4*idx:], value)
+	}
+
+	return encodePod(podTypeArray, body)
+}
+
+func podFloatArray(values []float32) []byte {
+	bits := make([]uint32, len(values))
+	for idx, value := range values {
+		bits[idx] = math.Float32bits(value)
+	}
+
+	return podArray(podTypeFloat, bits)
+}
+
+// podObject encodes an object POD of the given object type and id, with the given properties
+func podObject(objectType uint32, objectID uint32, entries ...podEntry) []byte {
+	body := append(encodeUint32(objectType), encodeUint32(objectID)...)
+
+	for _, entry := range entries {
+		body = append(body, encodeUint32(entry.Key)...)
+		body = append(body, encodeUint32(0)...) // flags
+		body = append(body, entry.Value...)
+	}
+
+	return encodePod(podTypeObject, body)
+}
+
+// decodePod reads a single POD from the start of the given data, returning it along with the rest of the data
+func decodePod(data []byte) (pod, []byte, error) {
+	if len(data) < podHeaderSize {
+		return pod{}, nil, errMalformedPod
+	}
+
+	size := int(podByteOrder.Uint32(data[0:]))
+	podType := podByteOrder.Uint32(data[4:])
+
+	if size > len(data)-podHeaderSize {
+		return pod{}, nil, errMalformedPod
+	}
+
+	decoded := pod{Type: podType, Body: data[podHeaderSize : podHeaderSize+size]}
+
+	// the last POD in a message may not be padded
+	rest := data[podHeaderSize+size:]
+	if padding := podPaddedSize(size) - size; padding <= len(rest) {
+		rest = rest[padding:]
+	} else {
+		rest = nil
+	}
+
+	return decoded, rest, nil
+}
+
+// unwrapChoice returns the default value of a choice POD, or the POD itself if it isn't one
+func (p pod) unwrapChoice() pod {
+	if p.Type != podTypeChoice || len(p.Body) < 8+podHeaderSize {
+		return p
+	}
+
+	// a choice's body holds its kind and flags, followed by an array-like list of values (the first being the default)
+	childSize := int(podByteOrder.Uint32(p.Body[8:]))
+	childType := podByteOrder.Uint32(p.Body[12:])
+
+	values := p.Body[8+podHeaderSize:]
+	if childSize > len(values) {
+		return p
+	}
+
+	return pod{Type: childType, Body: values[:childSize]}
+}
+
+func (p pod) uint32Value(expectedType uint32) (uint32, error) {
+	p = p.unwrapChoice()
+
+	if p.Type != expectedType || len(p.Body) < 4 {
+		return 0, fmt.Errorf("expected pod of type %d, got %d: %w", expectedType, p.Type, errMalformedPod)
+	}
+
+	return podByteOrder.Uint32(p.Body), nil
+}
+
+func (p pod) intValue() (int32, error) {
+	value, err := p.uint32Value(podTypeInt)
+	return int32(value), err
+}
+
+func (p pod) idValue() (uint32, error) {
+	return p.uint32Value(podTypeID)
+}
+
+func (p pod) boolValue() (bool, error) {
+	value, err := p.uint32Value(podTypeBool)
+	return value != 0, err
+}
+
+func (p pod) longValue() (int64, error) {
+	p = p.unwrapChoice()
+
+	if p.Type != podTypeLong || len(p.Body) < 8 {
+		return 0, fmt.Errorf("expected long pod, got %d: %w", p.Type, errMalformedPod)
+	}
+
+	return int64(podByteOrder.Uint64(p.Body)), nil
+}
+
+// stringValue returns the value of a string POD. PipeWire sends null strings as none PODs, which read as empty
+func (p pod) stringValue() (string, error) {
+	if p.Type == podTypeNone {
+		return "", nil
+	}
+
+	if p.Type != podTypeString || len(p.Body) == 0 {
+		return "", fmt.Errorf("expected string pod, got %d: %w", p.Type, errMalformedPod)
+	}
+
+	// strings are null-terminated
+	return string(p.Body[:len(p.Body)-1]), nil
+}
+
+// structFields returns the PODs inside a struct POD
+func (p pod) structFields() ([]pod, error) {
+	if p.Type != podTypeStruct {
+		return nil, fmt.Errorf("expected struct pod, got %d: %w", p.Type, errMalformedPod)
+	}
+
+	fields := []pod{}
+
+	for rest := p.Body; len(rest) > 0; {
+		field, remaining, err := decodePod(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, field)
+		rest = remaining
+	}
+
+	return fields, nil
+}
+
+// dictValue reads a dictionary encoded by podDict
+func (p pod) dictValue() (map[string]string, error) {
+	fields, err := p.structFields()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty dict: %w", errMalformedPod)
+	}
+
+	count, err := fields[0].intValue()
+	if err != nil || int(count)*2 != len(fields)-1 {
+		return nil, fmt.Errorf("invalid dict size: %w", errMalformedPod)
+	}
+
+	dict := map[string]string{}
+
+	for idx := 1; idx < len(fields); idx += 2 {
+		key, keyErr := fields[idx].stringValue()
+		value, valueErr := fields[idx+1].stringValue()
+
+		if keyErr != nil || valueErr != nil {
+			return nil, fmt.Errorf("invalid dict entry: %w", errMalformedPod)
+		}
+
+		dict[key] = value
+	}
+
+	return dict, nil
+}
+
+// arrayValue reads an array POD of 4-byte values of the given type (i.e. ids or floats)
+func (p pod) arrayValue(childType uint32) ([]uint32, error) {
+	p = p.unwrapChoice()
+
+	if p.Type != podTypeArray || len(p.Body) < podHeaderSize {
+		return nil, fmt.Errorf("expected array pod, got %d: %w", p.Type, errMalformedPod)
+	}
+
+	childSize := int(podByteOrder.Uint32(p.Body[0:]))
+	actualChildType := podByteOrder.Uint32(p.Body[4:])
+
+	if actualChildType != childType || childSize != 4 {
+		return nil, fmt.Errorf("expected array of %d, got %d: %w", childType, actualChildType, errMalformedPod)
+	}
+
+	elements := p.Body[podHeaderSize:]
+	values := make([]uint32, len(elements)/4)
+
+	for idx := range values {
+		values[idx] = podByteOrder.Uint32(elements[4*idx:])
+	}
+
+	return values, nil
+}
+
+func (p pod) floatArrayValue() ([]float32, error) {
+	bits, err := p.arrayValue(podTypeFloat)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]float32, len(bits))
+	for idx, value := range bits {
+		values[idx] = math.Float32frombits(value)
+	}
+
+	return values, nil
+}
+
+func (p pod) idArrayValue() ([]uint32, error) {
+	return p.arrayValue(podTypeID)
+}
+
+// objectValue reads an object POD, returning its object type and id along with its properties
+func (p pod) objectValue() (uint32, uint32, []podProperty, error) {
+	if p.Type != podTypeObject || len(p.Body) < 8 {
+		return 0, 0, nil, fmt.Errorf("expected object pod, got %d: %w", p.Type, errMalformedPod)
+	}
+
+	objectType := podByteOrder.Uint32(p.Body[0:])
+	objectID := podByteOrder.Uint32(p.Body[4:])
+
+	properties := []podProperty{}
+
+	for rest := p.Body[8:]; len(rest) > 0; {
+		if len(rest) < 8 {
+			return 0, 0, nil, errMalformedPod
+		}
+
+		key := podByteOrder.Uint32(rest[0:])
+		flags := podByteOrder.Uint32(rest[4:])
+
+		value, remaining, err := decodePod(rest[8:])
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		properties = append(properties, podProperty{Key: key, Flags: flags, Value: value})
+		rest = remaining
+	}
+
+	return objectType, objectID, properties, nil
+}
@@ -0,0 +1,179 @@
+package deej
+
+import (
+	"reflect"
+	"testing"
+)
+
+func decodeSinglePod(t *testing.T, encoded []byte) pod {
+	t.Helper()
+
+	if len(encoded)%8 != 0 {
+		t.Fatalf("encoded pod isn't padded to 8 bytes: %d bytes", len(encoded))
+	}
+
+	decoded, rest, err := decodePod(encoded)
+	if err != nil {
+		t.Fatalf("decode pod: %v", err)
+	}
+
+	if len(rest) != 0 {
+		t.Fatalf("expected nothing after the pod, got %d bytes", len(rest))
+	}
+
+	return decoded
+}
+
+func TestPodBoolRoundTrip(t *testing.T) {
+	for _, value := range []bool{true, false} {
+		decoded, err := decodeSinglePod(t, podBool(value)).boolValue()
+		if err != nil {
+			t.Fatalf("bool value: %v", err)
+		}
+
+		if decoded != value {
+			t.Errorf("expected %v, got %v", value, decoded)
+		}
+	}
+}
+
+func TestPodIDRoundTrip(t *testing.T) {
+	for _, value := range []uint32{0, spaParamProps, 0xffffffff} {
+		decoded, err := decodeSinglePod(t, podID(value)).idValue()
+		if err != nil {
+			t.Fatalf("id value: %v", err)
+		}
+
+		if decoded != value {
+			t.Errorf("expected %d, got %d", value, decoded)
+		}
+	}
+
+	// ids and ints share a size, but not a type
+	if _, err := decodeSinglePod(t, podInt(1)).idValue(); err == nil {
+		t.Error("expected an int pod not to read as an id")
+	}
+}
+
+func TestPodStructRoundTrip(t *testing.T) {
+	decoded := decodeSinglePod(t, podStruct(podInt(-3), podString("deej"), podBool(true), podID(7)))
+
+	fields, err := decoded.structFields()
+	if err != nil {
+		t.Fatalf("struct fields: %v", err)
+	}
+
+	if len(fields) != 4 {
+		t.Fatalf("expected 4 fields, got %d", len(fields))
+	}
+
+	if value, err := fields[0].intValue(); err != nil || value != -3 {
+		t.Errorf("expected int -3, got %d (%v)", value, err)
+	}
+
+	// odd-sized strings are padded, which mustn't throw off the fields after them
+	if value, err := fields[1].stringValue(); err != nil || value != "deej" {
+		t.Errorf("expected string \"deej\", got %q (%v)", value, err)
+	}
+
+	if value, err := fields[2].boolValue(); err != nil || !value {
+		t.Errorf("expected bool true, got %v (%v)", value, err)
+	}
+
+	if value, err := fields[3].idValue(); err != nil || value != 7 {
+		t.Errorf("expected id 7, got %d (%v)", value, err)
+	}
+
+	if empty, err := decodeSinglePod(t, podStruct()).structFields(); err != nil || len(empty) != 0 {
+		t.Errorf("expected an empty struct, got %d fields (%v)", len(empty), err)
+	}
+}
+
+func TestPodDictRoundTrip(t *testing.T) {
+	dict := map[string]string{
+		"application.name": "deej",
+		"media.role":       "",
+	}
+
+	decoded, err := decodeSinglePod(t, podDict(dict)).dictValue()
+	if err != nil {
+		t.Fatalf("dict value: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded, dict) {
+		t.Errorf("expected %v, got %v", dict, decoded)
+	}
+}
+
+func TestPodArrayRoundTrip(t *testing.T) {
+	ids := []uint32{1, 2, 0xffffffff}
+
+	decodedIDs, err := decodeSinglePod(t, podArray(podTypeID, ids)).idArrayValue()
+	if err != nil {
+		t.Fatalf("id array value: %v", err)
+	}
+
+	if !reflect.DeepEqual(decodedIDs, ids) {
+		t.Errorf("expected %v, got %v", ids, decodedIDs)
+	}
+
+	floats := []float32{0, 0.25, 1}
+
+	decodedFloats, err := decodeSinglePod(t, podFloatArray(floats)).floatArrayValue()
+	if err != nil {
+		t.Fatalf("float array value: %v", err)
+	}
+
+	if !reflect.DeepEqual(decodedFloats, floats) {
+		t.Errorf("expected %v, got %v", floats, decodedFloats)
+	}
+
+	// an array of ids isn't an array of floats
+	if _, err := decodeSinglePod(t, podArray(podTypeID, ids)).floatArrayValue(); err == nil {
+		t.Error("expected an id array not to read as a float array")
+	}
+}
+
+func TestPodObjectRoundTrip(t *testing.T) {
+	encoded := podObject(spaTypeObjectProps, spaParamProps,
+		podEntry{Key: spaPropMute, Value: podBool(true)},
+		podEntry{Key: spaPropChannelVolumes, Value: podFloatArray([]float32{0.5, 1})},
+	)
+
+	objectType, objectID, properties, err := decodeSinglePod(t, encoded).objectValue()
+	if err != nil {
+		t.Fatalf("object value: %v", err)
+	}
+
+	if objectType != spaTypeObjectProps || objectID != spaParamProps {
+		t.Errorf("expected object %d/%d, got %d/%d", spaTypeObjectProps, spaParamProps, objectType, objectID)
+	}
+
+	if len(properties) != 2 {
+		t.Fatalf("expected 2 properties, got %d", len(properties))
+	}
+
+	if properties[0].Key != spaPropMute || properties[1].Key != spaPropChannelVolumes {
+		t.Errorf("expected keys %d and %d, got %d and %d",
+			spaPropMute, spaPropChannelVolumes, properties[0].Key, properties[1].Key)
+	}
+
+	if mute, err := properties[0].Value.boolValue(); err != nil || !mute {
+		t.Errorf("expected mute to be true, got %v (%v)", mute, err)
+	}
+
+	volumes, err := properties[1].Value.floatArrayValue()
+	if err != nil || !reflect.DeepEqual(volumes, []float32{0.5, 1}) {
+		t.Errorf("expected volumes [0.5 1], got %v (%v)", volumes, err)
+	}
+}
+
+func TestDecodePodRejectsTruncatedData(t *testing.T) {
+	encoded := podStruct(podInt(1), podInt(2))
+
+	for _, data := range [][]byte{encoded[:4], encoded[:len(encoded)-8]} {
+		if _, _, err := decodePod(data); err == nil {
+			t.Errorf("expected %d truncated bytes not to decode", len(data))
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package deej
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// profileRule switches to a profile while one of its processes is running (or has the focused window).
+// when more than one rule applies, the one with the highest priority wins (or the first, for equal priorities)
+type profileRule struct {
+	profile   string
+	processes []string
+	focused   bool
+	priority  int
+}
+
+const (
+	configKeyProfileRules    = "profile_rules"
+	configKeyFallbackProfile = "fallback_profile"
+
+	// the keys of each rule
+	profileRuleKeyProfile  = "profile"
+	profileRuleKeyProcess  = "process"
+	profileRuleKeyWhen     = "when"
+	profileRuleKeyPriority = "priority"
+
+	// when a rule applies: while any of its processes is running, or while one of them has the focused window
+	profileRuleWhenRunning = "running"
+	profileRuleWhenFocused = "focused"
+
+	// how often rules are checked. looking through every running process isn't free, so this isn't too often
+	profileRuleCheckInterval = 3 * time.Second
+)
+
+// profileSwitcher switches between profiles on its own, according to the rules in the config
+type profileSwitcher struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	// the profile the rules picked last time. profiles are only switched when that changes,
+	// so a profile picked by hand sticks around until whatever the rules look at changes too
+	lastPick string
+
+	// closed once deej stops, which both ends the rule checks and keeps one that's underway from switching profiles
+	stopChannel chan struct{}
+}
+
+func newProfileSwitcher(deej *Deej, logger *zap.SugaredLogger) (*profileSwitcher, error) {
+	logger = logger.Named("profiles")
+
+	ps := &profileSwitcher{
+		deej:        deej,
+		logger:      logger,
+		stopChannel: make(chan struct{}),
+	}
+
+	logger.Debug("Created profile switcher instance")
+
+	return ps, nil
+}
+
+func (ps *profileSwitcher) initialize() {
+	ps.setupRuleChecks()
+}
+
+func (ps *profileSwitcher) setupRuleChecks() {
+	ticker := time.NewTicker(profileRuleCheckInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ps.stopChannel:
+				ps.logger.Debug("Stopped checking profile rules")
+				return
+			case <-ticker.C:
+				ps.checkRules()
+			}
+		}
+	}()
+}
+
+// stop signals the rule checks to stop. it doesn't wait for them, since a check can take a while
+func (ps *profileSwitcher) stop() {
+	close(ps.stopChannel)
+}
+
+// checkRules switches to the profile of the most important rule that applies right now (or to the fallback profile,
+// if none of them do), unless that's the same profile they picked last time
+func (ps *profileSwitcher) checkRules() {
+	rules, pick := ps.deej.config.profileRules()
+	if len(rules) == 0 {
+		ps.lastPick = ""
+		return
+	}
+
+	// performance: processes are only looked up once a rule needs them, and at most once per check
+	var runningProcesses, focusedProcesses []string
+	var runningChecked, focusedChecked bool
+
+	for _, rule := range rules {
+		var processes []string
+
+		if rule.focused {
+			if !focusedChecked {
+				focusedProcesses = ps.focusedProcessNames()
+				focusedChecked = true
+			}
+
+			processes = focusedProcesses
+		} else {
+			if !runningChecked {
+				runningProcesses = ps.runningProcessNames()
+				runningChecked = true
+			}
+
+			processes = runningProcesses
+		}
+
+		if rule.matches(processes) {
+			pick = rule.profile
+			break
+		}
+	}
+
+	if pick == ps.lastPick {
+		return
+	}
+
+	// looking through processes takes long enough for deej to have started stopping in the meantime
+	select {
+	case <-ps.stopChannel:
+		return
+	default:
+	}
+
+	ps.lastPick = pick
+
+	ps.logger.Infow("Profile rules picked a different profile", "profile", pick)
+
+	if err := ps.deej.config.switchProfile(pick, false); err != nil {
+		ps.logger.Warnw("Failed to switch to profile picked by rules", "profile", pick, "error", err)
+	}
+}
+
+// profileRules returns the profile rules along with the fallback profile, which is the default profile unless set.
+// both are replaced whenever the config is reloaded
+func (cc *CanonicalConfig) profileRules() ([]*profileRule, string) {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	if cc.FallbackProfile == "" {
+		return cc.ProfileRules, defaultProfileName
+	}
+
+	return cc.ProfileRules, cc.FallbackProfile
+}
+
+// runningProcessNames returns the lowercase names of all running processes
+func (ps *profileSwitcher) runningProcessNames() []string {
+	processes, err := process.Processes()
+	if err != nil {
+		ps.logger.Warnw("Failed to list running processes", "error", err)
+		return nil
+	}
+
+	names := make([]string, 0, len(processes))
+
+	for _, p := range processes {
+
+		// processes come and go (or belong to someone we can't look at), so errors are expected here
+		if name, err := p.Name(); err == nil {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+
+	return names
+}
+
+// focusedProcessNames returns the lowercase names of the focused window's process and its children
+func (ps *profileSwitcher) focusedProcessNames() []string {
+	processNames, err := util.GetCurrentWindowProcessNames()
+	if err != nil {
+		if ps.deej.Verbose() {
+			ps.logger.Debugw("Failed to get focused window's processes", "error", err)
+		}
+
+		return nil
+	}
+
+	names := make([]string, len(processNames))
+	for idx, name := range processNames {
+		names[idx] = strings.ToLower(name)
+	}
+
+	return names
+}
+
+func (rule *profileRule) matches(processNames []string) bool {
+	for _, processName := range rule.processes {
+		if funk.ContainsString(processNames, processName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule *profileRule) String() string {
+	when := profileRuleWhenRunning
+	if rule.focused {
+		when = profileRuleWhenFocused
+	}
+
+	return fmt.Sprintf("%s when %v %s (priority %d)", rule.profile, rule.processes, when, rule.priority)
+}
+
+// profileRulesFromConfig reads the profile rules from the config, most important first. each rule is a map, i.e.:
+//
+//	profile_rules:
+//	  - profile: streaming
+//	    process: obs64.exe
+//	    when: running
+//	    priority: 10
+//
+// where process can also be a list of process names. rules that are invalid (or name a profile that doesn't exist)
+// are warned about and ignored
+func profileRulesFromConfig(logger *zap.SugaredLogger, value interface{}, profileNames []string) []*profileRule {
+	rules := []*profileRule{}
+
+	if value == nil {
+		return rules
+	}
+
+	userRules, ok := value.([]interface{})
+	if !ok {
+		logger.Warnw("Profile rules must be a list, ignoring them", "key", configKeyProfileRules, "invalidValue", value)
+		return rules
+	}
+
+	for idx, userRule := range userRules {
+		rule, err := parseProfileRule(userRule, profileNames)
+		if err != nil {
+			logger.Warnw("Invalid profile rule specified, ignoring it",
+				"key", configKeyProfileRules,
+				"ruleIdx", idx,
+				"invalidValue", userRule,
+				"error", err)
+
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	// keeps the order of rules with the same priority
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].priority > rules[j].priority
+	})
+
+	return rules
+}
+
+func parseProfileRule(value interface{}, profileNames []string) (*profileRule, error) {
+	options, ok := stringKeyedMap(value)
+	if !ok {
+		return nil, fmt.Errorf("expected a map, got %T", value)
+	}
+
+	profile, ok := options[profileRuleKeyProfile]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing", profileRuleKeyProfile)
+	}
+
+	rule := &profileRule{
+		profile: strings.ToLower(fmt.Sprint(profile)),
+	}
+
+	if !funk.ContainsString(profileNames, rule.profile) {
+		return nil, fmt.Errorf("unknown profile %q", rule.profile)
+	}
+
+	switch processes := options[profileRuleKeyProcess].(type) {
+	case string:
+		rule.processes = []string{strings.ToLower(processes)}
+
+	case []interface{}:
+		for _, processName := range processes {
+			rule.processes = append(rule.processes, strings.ToLower(fmt.Sprint(processName)))
+		}
+
+	default:
+		return nil, fmt.Errorf("%s must be a process name or a list of them", profileRuleKeyProcess)
+	}
+
+	if len(rule.processes) == 0 {
+		return nil, fmt.Errorf("%s must name at least one process", profileRuleKeyProcess)
+	}
+
+	if when, ok := options[profileRuleKeyWhen]; ok {
+		switch strings.ToLower(fmt.Sprint(when)) {
+		case profileRuleWhenRunning:
+		case profileRuleWhenFocused:
+			rule.focused = true
+		default:
+			return nil, fmt.Errorf("%s must be %q or %q, got %v", profileRuleKeyWhen, profileRuleWhenRunning, profileRuleWhenFocused, when)
+		}
+	}
+
+	if priority, ok := options[profileRuleKeyPriority]; ok {
+		number, ok := priority.(int)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a whole number, got %v", profileRuleKeyPriority, priority)
+		}
+
+		rule.priority = number
+	}
+
+	return rule, nil
+}
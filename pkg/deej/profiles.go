@@ -0,0 +1,202 @@
+package deej
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thoas/go-funk"
+)
+
+// profiles are named sets of settings that can be switched between at runtime (from the tray menu, or with a button).
+// each one can override any of the keys below, in the same shape they have at the top of the config file, e.g.:
+//
+//	profiles:
+//	  gaming:
+//	    slider_mapping:
+//	      1: game.exe
+//	    display_config:
+//	      display_mapping:
+//	        1: auto
+//
+// the top-level settings make up the default profile, which also supplies whatever other profiles leave out
+const (
+	configKeyProfiles = "profiles"
+
+	// the profile deej starts with, made of the top-level settings
+	defaultProfileName = "default"
+)
+
+// the settings a profile can override, anything else is shared by all profiles
+var profileKeys = []string{
+	configKeySliderMapping,
+	configKeyInvertSliders,
+	configKeySliderCurves,
+	configKeyDisplayConfigDisplayMapping,
+}
+
+// ActiveProfile returns the name of the profile whose settings are currently in effect
+func (cc *CanonicalConfig) ActiveProfile() string {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.activeProfile
+}
+
+// ProfileNames returns the names of all profiles, starting with the default one
+func (cc *CanonicalConfig) ProfileNames() []string {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return append([]string{}, cc.profileNames...)
+}
+
+// the settings that profiles override are replaced whenever the profile changes, which can happen at any time.
+// they're only ever replaced (never changed in place) with the profile lock held, and read through these
+
+func (cc *CanonicalConfig) sliderMapping() *sliderMap {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.SliderMapping
+}
+
+func (cc *CanonicalConfig) invertSliders() bool {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.InvertSliders
+}
+
+func (cc *CanonicalConfig) sliderCurves() map[int]*sliderCurve {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.SliderCurves
+}
+
+func (cc *CanonicalConfig) displayConfig() *DisplayConfig {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	return cc.DisplayConfig
+}
+
+// SwitchProfile puts the given profile's settings into effect, and lets everyone who watches the config know
+// (just like when the config file is reloaded). the user is notified, since this is meant for switching by hand
+func (cc *CanonicalConfig) SwitchProfile(name string) error {
+	return cc.switchProfile(name, true)
+}
+
+// switchProfile is SwitchProfile, only without notifying the user unless notify is set.
+// profile rules switch profiles often enough that a notification every time would get in the way
+func (cc *CanonicalConfig) switchProfile(name string, notify bool) error {
+	name = strings.ToLower(name)
+
+	cc.profileLock.Lock()
+
+	if name == cc.activeProfile {
+		cc.profileLock.Unlock()
+		return nil
+	}
+
+	if !funk.ContainsString(cc.profileNames, name) {
+		cc.profileLock.Unlock()
+		return fmt.Errorf("unknown profile: %s", name)
+	}
+
+	previousProfile := cc.activeProfile
+	cc.activeProfile = name
+
+	cc.populateProfileFields()
+	sliderMapping := cc.SliderMapping
+	cc.profileLock.Unlock()
+
+	cc.logger.Infow("Switched profile", "from", previousProfile, "to", name, "sliderMapping", sliderMapping)
+
+	if notify {
+		cc.notifier.Notify("Profile switched!", fmt.Sprintf("Now using the %s profile.", name))
+	}
+
+	cc.onConfigReloaded()
+
+	return nil
+}
+
+// NextProfile switches to the profile after the active one, going back to the first after the last
+func (cc *CanonicalConfig) NextProfile() error {
+	profileNames := cc.ProfileNames()
+	activeProfile := cc.ActiveProfile()
+
+	for idx, name := range profileNames {
+		if name == activeProfile {
+			return cc.SwitchProfile(profileNames[(idx+1)%len(profileNames)])
+		}
+	}
+
+	return cc.SwitchProfile(defaultProfileName)
+}
+
+// populateProfiles reads the names of all profiles from the config, and falls back to the default profile
+// if the active one is gone. it's expected to be called with the profile lock held
+func (cc *CanonicalConfig) populateProfiles() {
+	cc.profileNames = []string{defaultProfileName}
+
+	otherNames := []string{}
+	for name := range cc.userConfig.GetStringMap(configKeyProfiles) {
+
+		// profile names end up in key paths, where dots would mean something else
+		if strings.Contains(name, ".") {
+			cc.logger.Warnw("Profile names can't contain dots, ignoring profile", "key", configKeyProfiles, "profile", name)
+			continue
+		}
+
+		if name != defaultProfileName {
+			otherNames = append(otherNames, name)
+		}
+	}
+
+	sort.Strings(otherNames)
+	cc.profileNames = append(cc.profileNames, otherNames...)
+
+	if !funk.ContainsString(cc.profileNames, cc.activeProfile) {
+		cc.logger.Warnw("Active profile no longer exists, switching to the default profile",
+			"profile", cc.activeProfile,
+			"defaultProfile", defaultProfileName)
+
+		cc.activeProfile = defaultProfileName
+	}
+}
+
+// populateProfileFields reads the settings that profiles can override (see profileKeys) for the active profile.
+// it's expected to be called with the profile lock held
+func (cc *CanonicalConfig) populateProfileFields() {
+
+	// merge the slider mappings from the user config (or the active profile) and internal config
+	cc.SliderMapping = sliderMapFromConfigs(
+		cc.userConfig.GetStringMapStringSlice(cc.profileKey(configKeySliderMapping)),
+		cc.internalConfig.GetStringMapStringSlice(configKeySliderMapping),
+	)
+
+	cc.InvertSliders = cc.userConfig.GetBool(cc.profileKey(configKeyInvertSliders))
+	cc.SliderCurves = sliderCurvesFromConfig(cc.logger, cc.userConfig.GetStringMap(cc.profileKey(configKeySliderCurves)))
+
+	// the rest of the display config stays as it is. it's copied rather than changed in place, since it might be in use
+	displayConfig := *cc.DisplayConfig
+	displayConfig.DisplayMapping = createDisplayMapFromConfig(
+		cc.userConfig.GetStringMapStringSlice(cc.profileKey(configKeyDisplayConfigDisplayMapping)),
+		cc.SliderMapping,
+	)
+
+	cc.DisplayConfig = &displayConfig
+}
+
+// profileKey returns the key to read the given setting from: the active profile's own, if it has one
+func (cc *CanonicalConfig) profileKey(key string) string {
+	profileKey := strings.Join([]string{configKeyProfiles, cc.activeProfile, key}, ".")
+	if cc.userConfig.IsSet(profileKey) {
+		return profileKey
+	}
+
+	return key
+}
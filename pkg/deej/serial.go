@@ -4,35 +4,65 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
-	"io"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/jacobsa/go-serial/serial"
 	"go.uber.org/zap"
 
 	"github.com/omriharel/deej/pkg/deej/util"
 )
 
-// SerialIO provides a deej-aware abstraction layer to managing serial I/O
+// SerialIO provides a deej-aware abstraction layer to managing serial I/O.
+// Despite its name, the actual connection can go through any Transport (not just a serial port)
 type SerialIO struct {
-	comPort  string
-	baudRate uint
-
 	deej   *Deej
 	logger *zap.SugaredLogger
 
 	stopChannel chan bool
-	connected   bool
-	connOptions serial.OpenOptions
-	conn        io.ReadWriteCloser
+	connInfo    ConnectionInfo
+	transport   Transport
+	writeLock   sync.Locker
+
+	// the connection's state is looked at from several goroutines (i.e. the display's), so it's only touched
+	// through the accessors below, which hold stateLock
+	running   bool // true from Start until Stop, even while (re)connecting
+	connected bool // true only while the link is actually up
+	stateLock sync.Locker
+
+	// what the board told us about itself during the handshake, nil until it did (or until we gave up waiting)
+	device *deviceInfo
+
+	// the firmware's replies to display frames, for whoever's waiting on them
+	frameReplies chan frameReply
 
 	lastKnownNumSliders        int
 	currentSliderPercentValues []float32
 
-	sliderMoveConsumers []chan SliderMoveEvent
+	lastKnownNumButtons int
+	currentButtonStates []bool
+
+	// capabilities the board sent segments for without announcing them, so that it's only warned about once
+	unannouncedSegments map[string]bool
+
+	// commands waiting to be written to the board (see serial_commands.go)
+	commands chan string
+
+	// the levels last sent to the board, in percent by slider index. they're sent again whenever it identifies itself
+	levels     map[int]int
+	levelsLock sync.Locker
+
+	// where each slider last reported to be (as a raw value), and where the motorized faders we moved are headed
+	rawSliderValues map[int]int
+	motorMoves      map[int]motorMove
+	motorLock       sync.Locker
+
+	sliderMoveConsumers  []chan SliderMoveEvent
+	encoderMoveConsumers []chan EncoderMoveEvent
+	buttonConsumers      []chan ButtonEvent
 }
 
 // SliderMoveEvent represents a single slider move captured by deej
@@ -41,7 +71,36 @@ type SliderMoveEvent struct {
 	PercentValue float32
 }
 
-var expectedLinePattern = regexp.MustCompile(`^\d{1,4}(\|\d{1,4})*\r\n$`)
+// EncoderMoveEvent represents a single relative move of a rotary encoder captured by deej.
+// Delta is the (signed) number of steps the encoder was turned since the last line
+type EncoderMoveEvent struct {
+	EncoderID int
+	Delta     int
+}
+
+// ButtonEvent represents a single button press/release (or switch flip) captured by deej
+type ButtonEvent struct {
+	ButtonID int
+	Pressed  bool
+}
+
+// lines are made of pipe-separated slider values and encoder deltas (which carry their own index),
+// optionally followed by a segment holding the state of every button/switch on the board.
+// for instance, "512|1023|E2:+3|B:0100" has two sliders, one encoder turned 3 steps up and four buttons
+var expectedLinePattern = regexp.MustCompile(`^(\d{1,4}|E\d{1,2}:[+-]\d{1,3})(\|(\d{1,4}|E\d{1,2}:[+-]\d{1,3}))*(\|B:[01]+)?\r\n$`)
+
+const (
+
+	// identifies the segment of a line that carries button states
+	buttonSegmentPrefix = "B:"
+
+	// identifies a segment that carries an encoder delta, and separates its index from the delta itself
+	encoderSegmentPrefix    = "E"
+	encoderSegmentSeparator = ":"
+
+	// replies nobody waits for (i.e. late ones, for frames that already timed out) are dropped once this many pile up
+	frameReplyBufferSize = 8
+)
 
 // NewSerialIO creates a SerialIO instance that uses the provided deej
 // instance's connection info to establish communications with the arduino chip
@@ -49,12 +108,24 @@ func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
 	logger = logger.Named("serial")
 
 	sio := &SerialIO{
-		deej:                deej,
-		logger:              logger,
-		stopChannel:         make(chan bool),
-		connected:           false,
-		conn:                nil,
-		sliderMoveConsumers: []chan SliderMoveEvent{},
+		deej:                 deej,
+		logger:               logger,
+		stopChannel:          make(chan bool),
+		connected:            false,
+		transport:            nil,
+		writeLock:            &sync.Mutex{},
+		stateLock:            &sync.Mutex{},
+		frameReplies:         make(chan frameReply, frameReplyBufferSize),
+		commands:             make(chan string, commandQueueSize),
+		levels:               map[int]int{},
+		levelsLock:           &sync.Mutex{},
+		rawSliderValues:      map[int]int{},
+		motorMoves:           map[int]motorMove{},
+		motorLock:            &sync.Mutex{},
+		unannouncedSegments:  map[string]bool{},
+		sliderMoveConsumers:  []chan SliderMoveEvent{},
+		encoderMoveConsumers: []chan EncoderMoveEvent{},
+		buttonConsumers:      []chan ButtonEvent{},
 	}
 
 	logger.Debug("Created serial i/o instance")
@@ -62,79 +133,74 @@ func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
 	// respond to config changes
 	sio.setupOnConfigReload()
 
+	// write whatever commands we have for the board as they come in
+	sio.setupCommandWriter()
+
 	return sio, nil
 }
 
-// Start attempts to connect to our arduino chip
+// Start attempts to connect to our arduino chip. The connection is then supervised in the background: if it drops
+// (or couldn't be opened in the first place, i.e. because the board isn't plugged in yet), SerialIO keeps trying
+// to (re)connect with backoff until it succeeds or Stop is called. only errors that retrying won't fix are returned
 func (sio *SerialIO) Start() error {
 
 	// don't allow multiple concurrent connections
-	if sio.connected {
+	sio.stateLock.Lock()
+	if sio.running {
+		sio.stateLock.Unlock()
 		sio.logger.Warn("Already connected, can't start another without closing first")
 		return errors.New("serial: connection already active")
 	}
 
-	// set minimum read size according to platform (0 for windows, 1 for linux)
-	// this prevents a rare bug on windows where serial reads get congested,
-	// resulting in significant lag
-	minimumReadSize := 0
-	if util.Linux() {
-		minimumReadSize = 1
-	}
-
-	sio.connOptions = serial.OpenOptions{
-		PortName:        sio.deej.config.ConnectionInfo.COMPort,
-		BaudRate:        uint(sio.deej.config.ConnectionInfo.BaudRate),
-		DataBits:        8,
-		StopBits:        1,
-		MinimumReadSize: uint(minimumReadSize),
-	}
+	sio.running = true
+	sio.stateLock.Unlock()
 
-	sio.logger.Debugw("Attempting serial connection",
-		"comPort", sio.connOptions.PortName,
-		"baudRate", sio.connOptions.BaudRate,
-		"minReadSize", minimumReadSize)
+	// remember which parameters we connected with, to later tell whether they've changed
+	sio.connInfo = sio.deej.config.ConnectionInfo
 
-	var err error
-	sio.conn, err = serial.Open(sio.connOptions)
+	transport, err := newTransport(sio.logger, sio.connInfo)
 	if err != nil {
+		sio.logger.Warnw("Failed to create transport", "error", err)
+		sio.setRunning(false)
 
-		// might need a user notification here, TBD
-		sio.logger.Warnw("Failed to open serial connection", "error", err)
-		return fmt.Errorf("open serial connection: %w", err)
+		return fmt.Errorf("create transport: %w", err)
 	}
 
-	namedLogger := sio.logger.Named(strings.ToLower(sio.connOptions.PortName))
+	namedLogger := sio.logger.Named(strings.ToLower(transport.String()))
 
-	namedLogger.Infow("Connected", "conn", sio.conn)
-	sio.connected = true
+	if err := transport.Open(); err != nil {
+		if !transport.Reconnectable(err) {
+			sio.logger.Warnw("Failed to open connection", "transport", transport, "error", err)
+			sio.setRunning(false)
 
-	// init displays
-	if sio.deej.config.DisplayConfig.Enabled {
-		sio.deej.display.initDisplays()
+			return fmt.Errorf("open connection: %w", err)
+		}
+
+		// the board might just not be plugged in (or on the network) yet, so keep trying until it is
+		sio.logger.Warnw("Failed to open connection, will keep trying in the background", "transport", transport, "error", err)
+		sio.notifyWaitingForBoard(err)
+
+		go sio.superviseConnection(namedLogger, false)
+
+		return nil
 	}
 
-	// read lines or await a stop
-	go func() {
-		connReader := bufio.NewReader(sio.conn)
-		lineChannel := sio.readLine(namedLogger, connReader)
+	sio.writeLock.Lock()
+	sio.transport = transport
+	sio.writeLock.Unlock()
 
-		for {
-			select {
-			case <-sio.stopChannel:
-				sio.close(namedLogger)
-			case line := <-lineChannel:
-				sio.handleLine(namedLogger, line)
-			}
-		}
-	}()
+	namedLogger.Infow("Connected", "transport", transport)
+	sio.onConnected()
+
+	// read lines or await a stop, reconnecting whenever needed
+	go sio.superviseConnection(namedLogger, true)
 
 	return nil
 }
 
-// Stop signals us to shut down our serial connection, if one is active
+// Stop signals us to shut down our serial connection, if one is active (or being re-established)
 func (sio *SerialIO) Stop() {
-	if sio.connected {
+	if sio.isRunning() {
 		sio.logger.Debug("Shutting down serial connection")
 		sio.stopChannel <- true
 	} else {
@@ -142,6 +208,47 @@ func (sio *SerialIO) Stop() {
 	}
 }
 
+func (sio *SerialIO) isRunning() bool {
+	sio.stateLock.Lock()
+	defer sio.stateLock.Unlock()
+
+	return sio.running
+}
+
+func (sio *SerialIO) setRunning(running bool) {
+	sio.stateLock.Lock()
+	defer sio.stateLock.Unlock()
+
+	sio.running = running
+}
+
+func (sio *SerialIO) isConnected() bool {
+	sio.stateLock.Lock()
+	defer sio.stateLock.Unlock()
+
+	return sio.connected
+}
+
+// identifiedDevice returns what the board told us about itself, or nil if it hasn't (yet)
+func (sio *SerialIO) identifiedDevice() *deviceInfo {
+	sio.stateLock.Lock()
+	defer sio.stateLock.Unlock()
+
+	return sio.device
+}
+
+// connectedDevice is identifiedDevice, but only while the link is up
+func (sio *SerialIO) connectedDevice() *deviceInfo {
+	sio.stateLock.Lock()
+	defer sio.stateLock.Unlock()
+
+	if !sio.connected {
+		return nil
+	}
+
+	return sio.device
+}
+
 // SubscribeToSliderMoveEvents returns an unbuffered channel that receives
 // a sliderMoveEvent struct every time a slider moves
 func (sio *SerialIO) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
@@ -151,6 +258,24 @@ func (sio *SerialIO) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
 	return ch
 }
 
+// SubscribeToEncoderMoveEvents returns an unbuffered channel that receives
+// an EncoderMoveEvent struct every time an encoder is turned
+func (sio *SerialIO) SubscribeToEncoderMoveEvents() chan EncoderMoveEvent {
+	ch := make(chan EncoderMoveEvent)
+	sio.encoderMoveConsumers = append(sio.encoderMoveConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToButtonEvents returns an unbuffered channel that receives
+// a ButtonEvent struct every time a button or switch changes its state
+func (sio *SerialIO) SubscribeToButtonEvents() chan ButtonEvent {
+	ch := make(chan ButtonEvent)
+	sio.buttonConsumers = append(sio.buttonConsumers, ch)
+
+	return ch
+}
+
 func (sio *SerialIO) setupOnConfigReload() {
 	configReloadedChannel := sio.deej.config.SubscribeToChanges()
 
@@ -165,15 +290,17 @@ func (sio *SerialIO) setupOnConfigReload() {
 				// (the next read line will emit SliderMoveEvent instances for all sliders)\
 				// this needs to happen after a small delay, because the session map will also re-acquire sessions
 				// whenever the config file is reloaded, and we don't want it to receive these move events while the map
-				// is still cleared. this is kind of ugly, but shouldn't cause any issues
-				go func() {
-					<-time.After(stopDelay)
-					sio.lastKnownNumSliders = 0
-				}()
+				// is still cleared. this is kind of ugly, but shouldn't cause any issues.
+				// boards with motorized faders are the exception: their faders are moved to the sessions instead
+				if !sio.boardSupports(capabilityMotors) {
+					go func() {
+						<-time.After(stopDelay)
+						sio.lastKnownNumSliders = 0
+					}()
+				}
 
 				// if connection params have changed, attempt to stop and start the connection
-				if sio.deej.config.ConnectionInfo.COMPort != sio.connOptions.PortName ||
-					uint(sio.deej.config.ConnectionInfo.BaudRate) != sio.connOptions.BaudRate {
+				if sio.deej.config.ConnectionInfo != sio.connInfo {
 
 					sio.logger.Info("Detected change in connection parameters, attempting to renew connection")
 					sio.Stop()
@@ -192,19 +319,254 @@ func (sio *SerialIO) setupOnConfigReload() {
 	}()
 }
 
+// write sends raw data to the board. it's safe to call from multiple goroutines
+func (sio *SerialIO) write(data []byte) error {
+	sio.writeLock.Lock()
+	defer sio.writeLock.Unlock()
+
+	if !sio.isConnected() {
+		return errors.New("serial: not connected")
+	}
+
+	if _, err := sio.transport.Write(data); err != nil {
+		return fmt.Errorf("write to %s: %w", sio.transport, err)
+	}
+
+	return nil
+}
+
 func (sio *SerialIO) close(logger *zap.SugaredLogger) {
-	if err := sio.conn.Close(); err != nil {
+	sio.writeLock.Lock()
+	defer sio.writeLock.Unlock()
+
+	if err := sio.transport.Close(); err != nil {
 		logger.Warnw("Failed to close serial connection", "error", err)
 	} else {
 		logger.Debug("Serial connection closed")
 	}
 
-	sio.conn = nil
+	sio.transport = nil
+
+	sio.stateLock.Lock()
 	sio.connected = false
+	sio.stateLock.Unlock()
+}
+
+// superviseConnection reads lines from the current connection until we're stopped. whenever the connection drops
+// (or if it isn't up to begin with), it's marked as down and re-established with exponential backoff
+// (unless the transport deems that pointless)
+func (sio *SerialIO) superviseConnection(logger *zap.SugaredLogger, connected bool) {
+	defer sio.setRunning(false)
+
+	// whether to tell the user their board is back, or that it's finally there
+	everConnected := connected
+
+	for {
+		if connected {
+			err := sio.readUntilDisconnected(logger)
+
+			// no error means we were asked to stop, and the connection is already closed
+			if err == nil {
+				return
+			}
+
+			logger.Warnw("Lost connection", "error", err)
+			sio.close(logger)
+
+			sio.deej.notifier.Notify(fmt.Sprintf("Lost connection to %s!", sio.connInfo.target()),
+				"deej will keep trying to reconnect in the background.")
+		}
+
+		if !sio.reconnect(logger) {
+			return
+		}
+
+		if everConnected {
+			logger.Info("Reconnected")
+			sio.deej.notifier.Notify(fmt.Sprintf("Reconnected to %s!", sio.connInfo.target()),
+				"Your board is back online.")
+		} else {
+			logger.Info("Connected")
+			sio.deej.notifier.Notify(fmt.Sprintf("Connected to %s!", sio.connInfo.target()),
+				"Your board is online.")
+		}
+
+		connected, everConnected = true, true
+		sio.onConnected()
+	}
+}
+
+// notifyWaitingForBoard lets the user know why we couldn't connect on the first try, and that we'll keep trying
+func (sio *SerialIO) notifyWaitingForBoard(err error) {
+	target := sio.connInfo.target()
+
+	switch {
+
+	// if we were supposed to find the board ourselves and couldn't, it's probably not plugged in
+	case errors.Is(err, errNoBoardDetected):
+		sio.deej.notifier.Notify("Can't find your deej board!",
+			"Make sure it's plugged in, or set com_port in your configuration to its serial port. deej will keep looking for it.")
+
+	// the COM port they gave isn't there (yet), maybe their config is wrong
+	case errors.Is(err, os.ErrNotExist) && sio.connInfo.Type == transportTypeSerial:
+		sio.deej.notifier.Notify(fmt.Sprintf("Can't connect to %s!", target),
+			"This serial port doesn't exist. Check your configuration, or plug your board in - deej will keep trying.")
+
+	default:
+		sio.deej.notifier.Notify(fmt.Sprintf("Can't connect to %s!", target),
+			"deej will keep trying to connect in the background.")
+	}
 }
 
-func (sio *SerialIO) readLine(logger *zap.SugaredLogger, reader *bufio.Reader) chan string {
+// readUntilDisconnected handles incoming lines until either the connection fails (returning its error),
+// or we're asked to stop (closing the connection and returning nil)
+func (sio *SerialIO) readUntilDisconnected(logger *zap.SugaredLogger) error {
+	done := make(chan bool)
+	defer close(done)
+
+	connReader := bufio.NewReader(sio.transport)
+	lineChannel, errChannel := sio.readLine(logger, connReader, done)
+
+	// firmware that predates the handshake will never announce itself
+	handshakeDeadline := time.After(handshakeTimeout)
+
+	for {
+		select {
+		case <-sio.stopChannel:
+			sio.close(logger)
+			return nil
+		case err := <-errChannel:
+			return err
+		case <-handshakeDeadline:
+			if sio.identifiedDevice() == nil {
+				logger.Info("Board didn't announce itself, assuming legacy firmware")
+				sio.onDeviceIdentified(logger, legacyDeviceInfo())
+			}
+		case line := <-lineChannel:
+			sio.handleLine(logger, line)
+		}
+	}
+}
+
+// reconnect keeps re-opening the transport until it succeeds (returning true), or until we're
+// either asked to stop or the transport reports an error that retrying won't fix (returning false)
+func (sio *SerialIO) reconnect(logger *zap.SugaredLogger) bool {
+	const (
+		initialBackoff = 1 * time.Second
+		maxBackoff     = 30 * time.Second
+	)
+
+	backoff := initialBackoff
+
+	for {
+		logger.Debugw("Waiting before reconnection attempt", "backoff", backoff)
+
+		select {
+		case <-sio.stopChannel:
+			logger.Debug("Stopped while waiting to reconnect")
+			return false
+		case <-time.After(backoff):
+		}
+
+		transport, err := newTransport(sio.logger, sio.connInfo)
+		if err == nil {
+			err = transport.Open()
+		}
+
+		if err == nil {
+			sio.writeLock.Lock()
+			sio.transport = transport
+			sio.writeLock.Unlock()
+
+			return true
+		}
+
+		if transport != nil && !transport.Reconnectable(err) {
+			logger.Warnw("Reconnection failed permanently, giving up", "error", err)
+			sio.deej.notifier.Notify(fmt.Sprintf("Can't reconnect to %s!", sio.connInfo.target()),
+				"Please check deej's logs for more details, and restart deej once the problem is fixed.")
+
+			return false
+		}
+
+		logger.Debugw("Reconnection attempt failed", "error", err)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// onConnected marks the link as up and asks the board to identify itself.
+// the rest of the board is brought up to date with us once it does, in onDeviceIdentified
+func (sio *SerialIO) onConnected() {
+
+	// this might be a different board (or firmware) than before
+	sio.stateLock.Lock()
+	sio.connected = true
+	sio.device = nil
+	sio.stateLock.Unlock()
+
+	// forget slider and button states, since they might've changed while we weren't connected
+	// (the next read line will emit SliderMoveEvent instances for all sliders)
+	sio.lastKnownNumSliders = 0
+	sio.lastKnownNumButtons = 0
+
+	// and its faders aren't going anywhere we sent them before
+	sio.motorLock.Lock()
+	sio.rawSliderValues = map[int]int{}
+	sio.motorMoves = map[int]motorMove{}
+	sio.motorLock.Unlock()
+
+	if err := sio.write([]byte(handshakeRequest)); err != nil {
+		sio.logger.Warnw("Failed to request handshake", "error", err)
+	}
+}
+
+// onDeviceIdentified adapts to what the board told us about itself (or to what we assume about legacy firmware)
+func (sio *SerialIO) onDeviceIdentified(logger *zap.SugaredLogger, device *deviceInfo) {
+	logger.Infow("Identified board", "device", device)
+
+	// we can't know what a newer protocol changed, so only the baseline (plain slider values) is relied on
+	if device.ProtocolVersion > maxSupportedProtocolVersion {
+		logger.Warnw("Board uses a newer protocol version than we support, falling back to slider values only",
+			"boardVersion", device.ProtocolVersion,
+			"supportedVersion", maxSupportedProtocolVersion,
+			"ignoredCapabilities", device.Capabilities)
+
+		sio.deej.notifier.Notify("Your board's firmware is newer than deej!",
+			"Only its sliders will work for now. Please update deej to the latest version.")
+
+		device.Capabilities = []string{}
+	}
+
+	sio.stateLock.Lock()
+	sio.device = device
+	sio.stateLock.Unlock()
+
+	// a board that was reset (or replaced) deserves to be warned about again
+	sio.unannouncedSegments = map[string]bool{}
+
+	// the board might've just booted, in which case it knows nothing about our levels
+	sio.writeLevels(logger)
+
+	// init displays, but only if there's anything to draw on
+	if sio.deej.config.displayConfig().Enabled {
+		if !device.supports(capabilityDisplays) {
+			logger.Warn("Displays are enabled in the config, but the board doesn't have any - not sending images")
+			return
+		}
+
+		sio.deej.display.initDisplays()
+	}
+}
+
+// readLine delivers lines read from the given reader until a read fails, in which case its error is delivered instead.
+// closing done releases the reading goroutine once nobody is interested in its lines anymore
+func (sio *SerialIO) readLine(logger *zap.SugaredLogger, reader *bufio.Reader, done chan bool) (chan string, chan error) {
 	ch := make(chan string)
+	errCh := make(chan error, 1)
 
 	go func() {
 		for {
@@ -215,7 +577,8 @@ func (sio *SerialIO) readLine(logger *zap.SugaredLogger, reader *bufio.Reader) c
 					logger.Warnw("Failed to read line from serial", "error", err, "line", line)
 				}
 
-				// just ignore the line, the read loop will stop after this
+				// deliver the error instead of the line, the read loop will stop after this
+				errCh <- err
 				return
 			}
 
@@ -224,15 +587,37 @@ func (sio *SerialIO) readLine(logger *zap.SugaredLogger, reader *bufio.Reader) c
 			}
 
 			// deliver the line to the channel
-			ch <- line
+			select {
+			case ch <- line:
+			case <-done:
+				return
+			}
 		}
 	}()
 
-	return ch
+	return ch, errCh
 }
 
 func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string) {
 
+	// the board may (re-)announce itself at any time, i.e. if it was reset
+	if handshakeLinePattern.MatchString(line) {
+		device, err := parseHandshake(line)
+		if err != nil {
+			logger.Warnw("Failed to parse board handshake, ignoring", "line", line, "error", err)
+			return
+		}
+
+		sio.onDeviceIdentified(logger, device)
+		return
+	}
+
+	// so may replies to the display frames we send it
+	if frameReplyPattern.MatchString(line) {
+		sio.handleFrameReply(logger, line)
+		return
+	}
+
 	// this function receives an unsanitized line which is guaranteed to end with LF,
 	// but most lines will end with CRLF. it may also have garbage instead of
 	// deej-formatted values, so we must check for that! just ignore bad ones
@@ -243,10 +628,93 @@ func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string) {
 	// trim the suffix
 	line = strings.TrimSuffix(line, "\r\n")
 
-	// split on pipe (|), this gives a slice of numerical strings between "0" and "1023"
+	// split on pipe (|), this gives a slice of numerical strings between "0" and "1023" and encoder deltas,
+	// possibly followed by a single button segment (which the pattern guarantees to be last)
 	splitLine := strings.Split(line, "|")
+
+	buttonStates := ""
+	if lastSegment := splitLine[len(splitLine)-1]; strings.HasPrefix(lastSegment, buttonSegmentPrefix) {
+		buttonStates = strings.TrimPrefix(lastSegment, buttonSegmentPrefix)
+		splitLine = splitLine[:len(splitLine)-1]
+	}
+
+	// set encoder deltas aside - sliders are numbered by their position among the absolute values only
+	sliderValues := []string{}
+	encoderDeltas := []string{}
+
+	for _, segment := range splitLine {
+		if strings.HasPrefix(segment, encoderSegmentPrefix) {
+			encoderDeltas = append(encoderDeltas, segment)
+		} else {
+			sliderValues = append(sliderValues, segment)
+		}
+	}
+
+	// slider values go first, since they're what tells us whether the line is malformed
+	if !sio.handleSliderValues(logger, line, sliderValues) {
+		return
+	}
+
+	if len(encoderDeltas) > 0 && sio.acceptsSegments(logger, capabilityEncoders) {
+		sio.handleEncoderDeltas(logger, encoderDeltas)
+	}
+
+	if buttonStates != "" && sio.acceptsSegments(logger, capabilityButtons) {
+		sio.handleButtonStates(logger, buttonStates)
+	}
+}
+
+// acceptsSegments tells whether line segments that need the given capability (i.e. button states) should be handled.
+// they're ignored until the board identifies itself, and for good if it didn't announce the capability
+func (sio *SerialIO) acceptsSegments(logger *zap.SugaredLogger, capability string) bool {
+	device := sio.identifiedDevice()
+	if device == nil {
+		return false
+	}
+
+	if device.supports(capability) {
+		return true
+	}
+
+	if !sio.unannouncedSegments[capability] {
+		logger.Warnw("Board sent data for a capability it didn't announce, ignoring it",
+			"capability", capability,
+			"device", device)
+
+		sio.unannouncedSegments[capability] = true
+	}
+
+	return false
+}
+
+// handleFrameReply passes an ACK/NAK on to whoever's sending frames, without ever blocking the read loop
+func (sio *SerialIO) handleFrameReply(logger *zap.SugaredLogger, line string) {
+	reply, err := parseFrameReply(line)
+	if err != nil {
+		logger.Warnw("Failed to parse frame reply, ignoring", "line", line, "error", err)
+		return
+	}
+
+	select {
+	case sio.frameReplies <- reply:
+	default:
+		logger.Debugw("Nobody's waiting for frame replies, dropping", "reply", reply)
+	}
+}
+
+// handleSliderValues emits move events for the given slider values, returning false if they seem malformed
+func (sio *SerialIO) handleSliderValues(logger *zap.SugaredLogger, line string, splitLine []string) bool {
 	numSliders := len(splitLine)
 
+	// boards that announced their slider count can't send us any other amount - that's a garbled line
+	if device := sio.identifiedDevice(); device != nil && device.NumSliders != unknownCount && numSliders != device.NumSliders {
+		sio.logger.Debugw("Got line with unexpected slider count, ignoring",
+			"line", line,
+			"expected", device.NumSliders)
+
+		return false
+	}
+
 	// update our slider count, if needed - this will send slider move events for all
 	if numSliders != sio.lastKnownNumSliders {
 		logger.Infow("Detected sliders", "amount", numSliders)
@@ -270,7 +738,7 @@ func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string) {
 		// so let's check the first number for correctness just in case
 		if sliderIdx == 0 && number > 1023 {
 			sio.logger.Debugw("Got malformed line from serial, ignoring", "line", line)
-			return
+			return false
 		}
 
 		// map the value from raw to a "dirty" float between 0 and 1 (e.g. 0.15451...)
@@ -280,10 +748,16 @@ func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string) {
 		normalizedScalar := util.NormalizeScalar(dirtyFloat)
 
 		// if sliders are inverted, take the complement of 1.0
-		if sio.deej.config.InvertSliders {
+		if sio.deej.config.invertSliders() {
 			normalizedScalar = 1 - normalizedScalar
 		}
 
+		// a motorized fader on its way to where we sent it isn't being moved by the user, so that stays between us
+		if sio.motorMoving(sliderIdx, number) {
+			sio.currentSliderPercentValues[sliderIdx] = normalizedScalar
+			continue
+		}
+
 		// check if it changes the desired state (could just be a jumpy raw slider value)
 		if util.SignificantlyDifferent(sio.currentSliderPercentValues[sliderIdx], normalizedScalar, sio.deej.config.NoiseReductionLevel) {
 
@@ -309,4 +783,95 @@ func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string) {
 			}
 		}
 	}
+
+	return true
+}
+
+// handleEncoderDeltas emits move events for every encoder segment (e.g. "E2:+3") that holds a non-zero delta
+func (sio *SerialIO) handleEncoderDeltas(logger *zap.SugaredLogger, segments []string) {
+	moveEvents := []EncoderMoveEvent{}
+
+	for _, segment := range segments {
+
+		// the pattern guarantees both parts are there and numeric, with an explicit sign on the delta
+		splitSegment := strings.SplitN(strings.TrimPrefix(segment, encoderSegmentPrefix), encoderSegmentSeparator, 2)
+
+		encoderIdx, _ := strconv.Atoi(splitSegment[0])
+		delta, _ := strconv.Atoi(splitSegment[1])
+
+		// boards may choose to report idle encoders, just skip them
+		if delta == 0 {
+			continue
+		}
+
+		// inverting applies to encoders as well, so that turning them works in the same direction as sliders
+		if sio.deej.config.invertSliders() {
+			delta = -delta
+		}
+
+		moveEvents = append(moveEvents, EncoderMoveEvent{
+			EncoderID: encoderIdx,
+			Delta:     delta,
+		})
+
+		if sio.deej.Verbose() {
+			logger.Debugw("Encoder moved", "event", moveEvents[len(moveEvents)-1])
+		}
+	}
+
+	// deliver move events if there are any, towards all potential consumers
+	if len(moveEvents) > 0 {
+		for _, consumer := range sio.encoderMoveConsumers {
+			for _, moveEvent := range moveEvents {
+				consumer <- moveEvent
+			}
+		}
+	}
+}
+
+// handleButtonStates emits button events for every button whose state differs from the last line's.
+// states is a string of '0' (released) and '1' (pressed) characters, one per button
+func (sio *SerialIO) handleButtonStates(logger *zap.SugaredLogger, states string) {
+	numButtons := len(states)
+
+	// unlike sliders, a changed button count doesn't emit any events. we only take note of the
+	// current states so that switches that are already flipped on don't trigger actions on connect
+	if numButtons != sio.lastKnownNumButtons {
+		logger.Infow("Detected buttons", "amount", numButtons)
+		sio.lastKnownNumButtons = numButtons
+		sio.currentButtonStates = make([]bool, numButtons)
+
+		for idx, state := range states {
+			sio.currentButtonStates[idx] = state == '1'
+		}
+
+		return
+	}
+
+	buttonEvents := []ButtonEvent{}
+	for buttonIdx, state := range states {
+		pressed := state == '1'
+
+		if pressed != sio.currentButtonStates[buttonIdx] {
+			sio.currentButtonStates[buttonIdx] = pressed
+
+			buttonEvents = append(buttonEvents, ButtonEvent{
+				ButtonID: buttonIdx,
+				Pressed:  pressed,
+			})
+
+			if sio.deej.Verbose() {
+				logger.Debugw("Button state changed", "event", buttonEvents[len(buttonEvents)-1])
+			}
+		}
+	}
+
+	// deliver button events if there are any, towards all potential consumers
+	if len(buttonEvents) > 0 {
+		for _, consumer := range sio.buttonConsumers {
+			for _, buttonEvent := range buttonEvents {
+				consumer <- buttonEvent
+			}
+		}
+	}
 }
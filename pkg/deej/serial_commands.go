@@ -0,0 +1,185 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// besides the handshake request and display images, deej sends the board short commands about its sliders.
+// these are queued up and written in the order they were sent by a single goroutine, so whoever sends them
+// (i.e. the session map) never has to wait on the connection
+
+// motorMove is a motorized fader on its way to where we sent it
+type motorMove struct {
+	target   int
+	deadline time.Time
+}
+
+const (
+
+	// commands beyond this many are dropped until the board catches up
+	commandQueueSize = 32
+
+	// sent to boards with the levels capability, holding the volume each slider's sessions are at in percent,
+	// e.g. "<<LEVELS|80|35|-1>>". sliders we don't know a level for (no sessions, or not mapped) get unknownLevel
+	levelsCommandFormat = "<<LEVELS|%s>>"
+	unknownLevel        = -1
+
+	// sent to boards with the motors capability, moving a slider's fader to a raw position (0-1023), e.g. "<<M2:512>>"
+	motorCommandFormat = "<<M%d:%d>>"
+
+	// a fader counts as being where we sent it once it's within this many raw steps of it
+	motorArrivalTolerance = 10
+
+	// faders that haven't arrived by then are assumed to be held in place (or stuck), and are listened to again
+	motorMoveTimeout = 2 * time.Second
+)
+
+func (sio *SerialIO) setupCommandWriter() {
+	go func() {
+		for command := range sio.commands {
+			if err := sio.write([]byte(command)); err != nil {
+				sio.logger.Debugw("Failed to send command to board", "command", command, "error", err)
+			}
+		}
+	}()
+}
+
+func (sio *SerialIO) sendCommand(command string) {
+	select {
+	case sio.commands <- command:
+	default:
+		sio.logger.Debugw("Command queue full, dropping command", "command", command)
+	}
+}
+
+// boardSupports is true while we're connected to a board that announced the given capability
+func (sio *SerialIO) boardSupports(capability string) bool {
+	device := sio.connectedDevice()
+	return device != nil && device.supports(capability)
+}
+
+// SendLevels lets boards that want them (see capabilityLevels) know the volume each slider's sessions are at,
+// as values between 0 and 1 by slider index. they're only sent if they changed since the last time
+func (sio *SerialIO) SendLevels(levels map[int]float32) {
+	percents := make(map[int]int, len(levels))
+	for sliderIdx, level := range levels {
+		percents[sliderIdx] = int(math.Round(float64(level) * 100))
+	}
+
+	sio.levelsLock.Lock()
+	changed := len(percents) != len(sio.levels)
+
+	for sliderIdx, percent := range percents {
+		if previous, ok := sio.levels[sliderIdx]; !ok || previous != percent {
+			changed = true
+		}
+	}
+
+	sio.levels = percents
+	sio.levelsLock.Unlock()
+
+	if changed {
+		sio.writeLevels(sio.logger)
+	}
+}
+
+func (sio *SerialIO) writeLevels(logger *zap.SugaredLogger) {
+	device := sio.connectedDevice()
+	if device == nil || !device.supports(capabilityLevels) {
+		return
+	}
+
+	sio.levelsLock.Lock()
+
+	// one value for each of the board's sliders, or for as many as we have levels for if it didn't say
+	numSliders := device.NumSliders
+	for sliderIdx := range sio.levels {
+		if sliderIdx >= numSliders {
+			numSliders = sliderIdx + 1
+		}
+	}
+
+	values := make([]string, numSliders)
+	for sliderIdx := range values {
+		level, ok := sio.levels[sliderIdx]
+		if !ok {
+			level = unknownLevel
+		}
+
+		values[sliderIdx] = strconv.Itoa(level)
+	}
+
+	sio.levelsLock.Unlock()
+
+	if len(values) == 0 {
+		return
+	}
+
+	if sio.deej.Verbose() {
+		logger.Debugw("Sending levels to board", "levels", values)
+	}
+
+	sio.sendCommand(fmt.Sprintf(levelsCommandFormat, strings.Join(values, "|")))
+}
+
+// MoveSlider sends a slider's motorized fader to the given position (between 0 and 1, as in SliderMoveEvent),
+// on boards that have them (see capabilityMotors). the fader's moves on its way there aren't reported as slider moves
+func (sio *SerialIO) MoveSlider(sliderID int, position float32) {
+	if !sio.boardSupports(capabilityMotors) {
+		return
+	}
+
+	if sio.deej.config.invertSliders() {
+		position = 1 - position
+	}
+
+	target := int(math.Round(float64(position) * 1023))
+
+	sio.motorLock.Lock()
+
+	// don't fight the user over a fader that's already there (give or take its own noise)
+	if current, ok := sio.rawSliderValues[sliderID]; ok && absInt(current-target) <= motorArrivalTolerance {
+		sio.motorLock.Unlock()
+		return
+	}
+
+	sio.motorMoves[sliderID] = motorMove{target: target, deadline: time.Now().Add(motorMoveTimeout)}
+	sio.motorLock.Unlock()
+
+	sio.logger.Debugw("Moving motorized fader", "sliderID", sliderID, "target", target)
+	sio.sendCommand(fmt.Sprintf(motorCommandFormat, sliderID, target))
+}
+
+// motorMoving records a slider's raw value, and tells whether that's just its fader moving to where we sent it
+// (up to and including its arrival there). faders that take too long are assumed to be held by the user
+func (sio *SerialIO) motorMoving(sliderIdx int, value int) bool {
+	sio.motorLock.Lock()
+	defer sio.motorLock.Unlock()
+
+	sio.rawSliderValues[sliderIdx] = value
+
+	move, ok := sio.motorMoves[sliderIdx]
+	if !ok {
+		return false
+	}
+
+	if absInt(value-move.target) <= motorArrivalTolerance {
+		delete(sio.motorMoves, sliderIdx)
+		return true
+	}
+
+	if time.Now().After(move.deadline) {
+		delete(sio.motorMoves, sliderIdx)
+		sio.logger.Debugw("Motorized fader didn't arrive in time, listening to it again", "sliderID", sliderIdx, "value", value)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,205 @@
+package deej
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
+)
+
+const (
+
+	// setting com_port to this value makes deej look for the board on its own
+	comPortAuto = "auto"
+
+	// how long to listen on each candidate port. this needs to account for boards that reset
+	// when a connection is opened (most arduinos do), which can take a second or two
+	serialDetectionTimeout = 3 * time.Second
+)
+
+var errNoBoardDetected = errors.New("no deej board detected on any serial port")
+
+// serialPortInfo is a serial port that might have a deej board behind it, along with the (lowercase, hex)
+// IDs of the usb device it belongs to. these are empty if it isn't one, or if the platform can't tell
+type serialPortInfo struct {
+	name         string
+	usbVendorID  string
+	usbProductID string
+}
+
+// the usb vendors whose boards (and usb serial chips) deej boards are built with. when the config doesn't name
+// a vendor, usb devices from anyone else (i.e. phones, modems, gps receivers) are left alone
+var boardUSBVendorIDs = []string{
+	"2341", // arduino
+	"2a03", // arduino.org
+	"1b4f", // sparkfun (pro micro)
+	"239a", // adafruit
+	"16c0", // teensy
+	"2886", // seeed
+	"2e8a", // raspberry pi (pico)
+	"303a", // espressif (native usb)
+	"1a86", // wch (ch340)
+	"0403", // ftdi
+	"10c4", // silicon labs (cp210x)
+	"067b", // prolific
+}
+
+// the port a board was last detected on. it's probed on its own first the next time around (i.e. when reconnecting),
+// so that other ports - which might have arduinos of their own, that reset when opened - are left alone
+var lastDetectedPort = struct {
+	sync.Mutex
+	name string
+}{}
+
+// detectSerialPort looks for the serial port a deej board is connected to. it listens on all candidate ports
+// at once (optionally filtered by USB vendor/product ID), and picks the first one that emits deej-formatted lines
+func detectSerialPort(logger *zap.SugaredLogger, baudRate uint, usbVendorID string, usbProductID string) (string, error) {
+	ports, err := listSerialPorts()
+	if err != nil {
+		logger.Warnw("Failed to list serial ports", "error", err)
+		return "", fmt.Errorf("list serial ports: %w", err)
+	}
+
+	candidates := candidateSerialPorts(logger, ports, strings.ToLower(usbVendorID), strings.ToLower(usbProductID))
+
+	lastDetectedPort.Lock()
+	previousPort := lastDetectedPort.name
+	lastDetectedPort.Unlock()
+
+	if previousPort != "" && funk.ContainsString(candidates, previousPort) {
+		logger.Debugw("Probing the port the board was last detected on", "comPort", previousPort)
+
+		if probeSerialPort(logger, previousPort, baudRate) {
+			logger.Infow("Detected deej board on the same port as before", "comPort", previousPort)
+			return previousPort, nil
+		}
+
+		candidates = funk.FilterString(candidates, func(candidate string) bool {
+			return candidate != previousPort
+		})
+	}
+
+	logger.Debugw("Probing serial ports for a deej board",
+		"candidates", candidates,
+		"usbVendorID", usbVendorID,
+		"usbProductID", usbProductID)
+
+	results := make([]chan bool, len(candidates))
+
+	for candidateIdx, candidate := range candidates {
+		results[candidateIdx] = make(chan bool, 1)
+
+		go func(portName string, result chan bool) {
+			result <- probeSerialPort(logger, portName, baudRate)
+		}(candidate, results[candidateIdx])
+	}
+
+	// prefer earlier candidates when more than one board responds, to keep our choice consistent
+	detectedPort := ""
+
+	for candidateIdx, candidate := range candidates {
+		if <-results[candidateIdx] && detectedPort == "" {
+			detectedPort = candidate
+		}
+	}
+
+	if detectedPort == "" {
+		logger.Infow("No deej board detected", "candidates", candidates)
+		return "", errNoBoardDetected
+	}
+
+	logger.Infow("Detected deej board", "comPort", detectedPort)
+
+	lastDetectedPort.Lock()
+	lastDetectedPort.name = detectedPort
+	lastDetectedPort.Unlock()
+
+	return detectedPort, nil
+}
+
+// candidateSerialPorts returns the names of the ports worth probing: those of the given usb device if the config
+// names one, or otherwise all of them except ports of usb devices that are known not to be deej boards
+func candidateSerialPorts(logger *zap.SugaredLogger, ports []serialPortInfo, usbVendorID string, usbProductID string) []string {
+	candidates := []string{}
+
+	for _, port := range ports {
+		if usbVendorID != "" || usbProductID != "" {
+			if (usbVendorID != "" && port.usbVendorID != usbVendorID) || (usbProductID != "" && port.usbProductID != usbProductID) {
+				continue
+			}
+		} else if port.usbVendorID != "" && !funk.ContainsString(boardUSBVendorIDs, port.usbVendorID) {
+			logger.Debugw("Skipping serial port of a usb device that isn't a deej board",
+				"comPort", port.name,
+				"usbVendorID", port.usbVendorID,
+				"usbProductID", port.usbProductID)
+
+			continue
+		}
+
+		candidates = append(candidates, port.name)
+	}
+
+	return candidates
+}
+
+// probeSerialPort returns true if the given port emits at least one deej-formatted line within the detection timeout
+func probeSerialPort(logger *zap.SugaredLogger, portName string, baudRate uint) bool {
+
+	// unlike regular connections, reads here must time out on their own so that silent ports don't block us forever
+	conn, err := serial.Open(serial.OpenOptions{
+		PortName:              portName,
+		BaudRate:              baudRate,
+		DataBits:              8,
+		StopBits:              1,
+		InterCharacterTimeout: 100,
+		MinimumReadSize:       0,
+	})
+
+	if err != nil {
+		logger.Debugw("Failed to open candidate serial port", "comPort", portName, "error", err)
+		return false
+	}
+
+	defer conn.Close()
+
+	deadline := time.Now().Add(serialDetectionTimeout)
+	received := []byte{}
+	chunk := make([]byte, 256)
+
+	for time.Now().Before(deadline) {
+		n, err := conn.Read(chunk)
+
+		// timed out reads can either come back empty or with an EOF, both just mean there's nothing to read yet
+		if err != nil && err != io.EOF {
+			logger.Debugw("Failed to read from candidate serial port", "comPort", portName, "error", err)
+			return false
+		}
+
+		received = append(received, chunk[:n]...)
+
+		// look at every complete line we have so far
+		for {
+			lineEnd := bytes.IndexByte(received, '\n')
+			if lineEnd == -1 {
+				break
+			}
+
+			line := string(received[:lineEnd+1])
+			received = received[lineEnd+1:]
+
+			// boards that announce themselves on boot will usually do so before sending any values
+			if expectedLinePattern.MatchString(line) || handshakeLinePattern.MatchString(line) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
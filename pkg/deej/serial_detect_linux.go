@@ -0,0 +1,59 @@
+package deej
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// usb serial adapters (i.e. CH340, FTDI) show up as ttyUSB, while native usb boards (i.e. pro micro) show up as ttyACM
+var serialPortGlobs = []string{"/dev/ttyUSB*", "/dev/ttyACM*"}
+
+func listSerialPorts() ([]serialPortInfo, error) {
+	ports := []serialPortInfo{}
+
+	for _, pattern := range serialPortGlobs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("glob %s: %w", pattern, err)
+		}
+
+		for _, port := range matches {
+			vendorID, productID := readUSBIDs(filepath.Base(port))
+
+			ports = append(ports, serialPortInfo{
+				name:         port,
+				usbVendorID:  vendorID,
+				usbProductID: productID,
+			})
+		}
+	}
+
+	return ports, nil
+}
+
+// readUSBIDs returns the (lowercase, hex) vendor and product IDs of the usb device behind a tty, as found in sysfs.
+// the tty's device directory is either the usb interface (for ttyACM) or a port below it (for ttyUSB),
+// so we walk up from it until we hit the directory of the usb device itself
+func readUSBIDs(ttyName string) (string, string) {
+	devicePath, err := filepath.EvalSymlinks(filepath.Join("/sys/class/tty", ttyName, "device"))
+	if err != nil {
+		return "", ""
+	}
+
+	const maxDepth = 4
+
+	for depth := 0; depth < maxDepth; depth++ {
+		vendorID, err := ioutil.ReadFile(filepath.Join(devicePath, "idVendor"))
+		if err == nil {
+			productID, _ := ioutil.ReadFile(filepath.Join(devicePath, "idProduct"))
+
+			return strings.ToLower(strings.TrimSpace(string(vendorID))), strings.ToLower(strings.TrimSpace(string(productID)))
+		}
+
+		devicePath = filepath.Dir(devicePath)
+	}
+
+	return "", ""
+}
@@ -0,0 +1,118 @@
+package deej
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+
+	// lists every serial port currently present, as value name -> port name (e.g. "\Device\USBSER000" -> "COM4")
+	serialCommRegistryPath = `HARDWARE\DEVICEMAP\SERIALCOMM`
+
+	// usb devices are listed here by "VID_xxxx&PID_xxxx", with the port name found under each instance's parameters
+	usbEnumRegistryPath     = `SYSTEM\CurrentControlSet\Enum\USB`
+	usbDeviceParametersPath = "Device Parameters"
+)
+
+// usb device key names carry the device's IDs, e.g. "VID_2341&PID_8037" (or "VID_2341&PID_8037&MI_00" for composite ones)
+var usbDeviceIDsPattern = regexp.MustCompile(`(?i)vid_([0-9a-f]{4})&pid_([0-9a-f]{4})`)
+
+func listSerialPorts() ([]serialPortInfo, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, serialCommRegistryPath, registry.QUERY_VALUE)
+	if err != nil {
+
+		// this key doesn't exist at all when there are no serial ports
+		if err == registry.ErrNotExist {
+			return []serialPortInfo{}, nil
+		}
+
+		return nil, fmt.Errorf("open registry key %s: %w", serialCommRegistryPath, err)
+	}
+	defer key.Close()
+
+	valueNames, err := key.ReadValueNames(0)
+	if err != nil {
+		return nil, fmt.Errorf("read registry value names: %w", err)
+	}
+
+	usbPorts := listUSBSerialPorts()
+	ports := []serialPortInfo{}
+
+	for _, valueName := range valueNames {
+		port, _, err := key.GetStringValue(valueName)
+		if err != nil {
+			continue
+		}
+
+		// ports that don't belong to a usb device (i.e. bluetooth or built-in ones) have no IDs
+		info := usbPorts[strings.ToUpper(port)]
+		info.name = port
+
+		ports = append(ports, info)
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].name < ports[j].name
+	})
+
+	return ports, nil
+}
+
+// listUSBSerialPorts returns the serial ports belonging to usb devices, by their (uppercase) name
+func listUSBSerialPorts() map[string]serialPortInfo {
+	ports := map[string]serialPortInfo{}
+
+	usbKey, err := registry.OpenKey(registry.LOCAL_MACHINE, usbEnumRegistryPath, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return ports
+	}
+	defer usbKey.Close()
+
+	deviceNames, err := usbKey.ReadSubKeyNames(0)
+	if err != nil {
+		return ports
+	}
+
+	for _, deviceName := range deviceNames {
+		ids := usbDeviceIDsPattern.FindStringSubmatch(deviceName)
+		if ids == nil {
+			continue
+		}
+
+		deviceKey, err := registry.OpenKey(usbKey, deviceName, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+
+		instanceNames, _ := deviceKey.ReadSubKeyNames(0)
+
+		for _, instanceName := range instanceNames {
+			parametersKey, err := registry.OpenKey(deviceKey,
+				instanceName+`\`+usbDeviceParametersPath,
+				registry.QUERY_VALUE)
+
+			if err != nil {
+				continue
+			}
+
+			if port, _, err := parametersKey.GetStringValue("PortName"); err == nil {
+				ports[strings.ToUpper(port)] = serialPortInfo{
+					name:         port,
+					usbVendorID:  strings.ToLower(ids[1]),
+					usbProductID: strings.ToLower(ids[2]),
+				}
+			}
+
+			parametersKey.Close()
+		}
+
+		deviceKey.Close()
+	}
+
+	return ports
+}
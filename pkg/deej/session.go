@@ -11,14 +11,44 @@ type Session interface {
 	GetVolume() float32
 	SetVolume(v float32) error
 
-	// TODO: future mute support
-	// GetMute() bool
-	// SetMute(m bool) error
+	GetMute() bool
+	SetMute(m bool) error
 
 	Key() string
 	Release()
 }
 
+// propertySession is implemented by sessions that carry their audio server's properties (currently on Linux),
+// allowing them to be targeted by those rather than just their process name
+type propertySession interface {
+	Property(name string) (string, bool)
+}
+
+// deviceSession is implemented by sessions of a specific audio device (currently on Linux), which can also be
+// targeted by the device's human-readable description rather than just its name
+type deviceSession interface {
+	Description() string
+}
+
+// balanceSession is implemented by sessions whose left/right balance can be adjusted (currently on Linux).
+// balance goes from 0 (left only) through 0.5 (centered) to 1 (right only)
+type balanceSession interface {
+	GetBalance() float32
+	SetBalance(b float32) error
+}
+
+// streamSession is implemented by sessions of a single app stream (currently on Linux). a stream can
+// outlive the session objects made for it, so it's what tells two of them apart
+type streamSession interface {
+	streamID() uint32
+}
+
+// processSession is implemented by sessions of a single process (currently on Windows). a process can have more than
+// one of them (i.e. one per device), so unlike a stream this doesn't tell them apart, but does tell them from others
+type processSession interface {
+	processID() uint32
+}
+
 const (
 
 	// ideally these would share a common ground in baseSession
@@ -0,0 +1,171 @@
+package deej
+
+import "math"
+
+// linux sound servers let each channel of a stream or device have its own volume. the helpers below work on those
+// as levels between 0 and 1 (on PulseAudio's cubic scale), so that they can be shared between PulseAudio and PipeWire
+
+// channelSide is which side of the listener a channel plays on, as far as balance is concerned
+type channelSide int
+
+const (
+	channelSideNone channelSide = iota // i.e. mono, center or lfe channels, which balance doesn't touch
+	channelSideLeft
+	channelSideRight
+)
+
+const (
+	centerBalance = 0.5
+
+	// how far a channel's level can be from what its ratio says before the ratios are considered changed elsewhere.
+	// sound servers round the levels we set (PulseAudio to whole volume steps), so they never come back exactly
+	channelRatioTolerance = 0.005
+)
+
+// channelRatios is how loud each of a session's channels is compared to its loudest one. sessions hold on to these
+// rather than working them out from their current levels every time, as those lose the balance once they're all
+// silent and drift a little further from it with every rounded volume change
+type channelRatios []float64
+
+func maxChannelLevel(levels []float64) float64 {
+	var loudest float64
+
+	for _, level := range levels {
+		loudest = math.Max(loudest, level)
+	}
+
+	return loudest
+}
+
+// scale returns channel levels with the loudest one at the given level, keeping the balance between them.
+// the ratios are first updated from the given current levels, but only if those are audible and were changed
+// by more than rounding since the ratios were last used (i.e. by another app). if there are still no ratios
+// to go by, every channel is set to the given level
+func (r *channelRatios) scale(levels []float64, level float64) []float64 {
+	r.update(levels)
+
+	scaled := make([]float64, len(levels))
+
+	for idx := range levels {
+		if *r == nil {
+			scaled[idx] = level
+		} else {
+			scaled[idx] = (*r)[idx] * level
+		}
+	}
+
+	return scaled
+}
+
+func (r *channelRatios) update(levels []float64) {
+	loudest := maxChannelLevel(levels)
+	if loudest == 0 {
+
+		// nothing to learn from silent channels, but they may not even be the same channels anymore
+		if len(*r) != len(levels) {
+			*r = nil
+		}
+
+		return
+	}
+
+	if len(*r) == len(levels) {
+		changed := false
+
+		for idx, channelLevel := range levels {
+			if math.Abs(channelLevel-(*r)[idx]*loudest) > channelRatioTolerance {
+				changed = true
+				break
+			}
+		}
+
+		if !changed {
+			return
+		}
+	}
+
+	ratios := make(channelRatios, len(levels))
+	for idx, channelLevel := range levels {
+		ratios[idx] = channelLevel / loudest
+	}
+
+	*r = ratios
+}
+
+// channelBalance returns the balance between the given channels' left and right sides, from 0 (left only)
+// through 0.5 (centered) to 1 (right only). this is PulseAudio's own definition (see pa_cvolume_get_balance)
+func channelBalance(levels []float64, sides []channelSide) float32 {
+	left, right, ok := sideLevels(levels, sides)
+	if !ok || left == right {
+		return centerBalance
+	}
+
+	if left > right {
+		return float32(right / left / 2)
+	}
+
+	return float32(1 - left/right/2)
+}
+
+// balanceChannelLevels returns the given channel levels with their balance set to the given one. the louder side
+// stays at the loudest channel's level, while the other is turned down. channels without a side are left as-is
+func balanceChannelLevels(levels []float64, sides []channelSide, balance float32) []float64 {
+	left, right, ok := sideLevels(levels, sides)
+	if !ok {
+		return levels
+	}
+
+	loudest := math.Max(left, right)
+	newLeft, newRight := loudest, loudest
+
+	if balance < centerBalance {
+		newRight = loudest * float64(balance) * 2
+	} else {
+		newLeft = loudest * float64(1-balance) * 2
+	}
+
+	balanced := make([]float64, len(levels))
+
+	for idx, level := range levels {
+		switch sides[idx] {
+		case channelSideLeft:
+			balanced[idx] = rescaleChannelLevel(level, left, newLeft)
+		case channelSideRight:
+			balanced[idx] = rescaleChannelLevel(level, right, newRight)
+		default:
+			balanced[idx] = level
+		}
+	}
+
+	return balanced
+}
+
+// sideLevels returns the loudest left and right channel levels.
+// the third return value is false unless there's at least one channel on each side
+func sideLevels(levels []float64, sides []channelSide) (float64, float64, bool) {
+	if len(levels) != len(sides) {
+		return 0, 0, false
+	}
+
+	left, right := -1.0, -1.0
+
+	for idx, level := range levels {
+		switch sides[idx] {
+		case channelSideLeft:
+			left = math.Max(left, level)
+		case channelSideRight:
+			right = math.Max(right, level)
+		}
+	}
+
+	return left, right, left >= 0 && right >= 0
+}
+
+// rescaleChannelLevel moves a channel from a group whose loudest level was from to one whose loudest level is to
+func rescaleChannelLevel(level float64, from float64, to float64) float64 {
+	if from == 0 {
+		return to
+	}
+
+	return level * to / from
+}
@@ -6,3 +6,34 @@ type SessionFinder interface {
 
 	Release() error
 }
+
+// the sound servers deej can talk to on linux (windows only has the one)
+const (
+	audioBackendPulseAudio = "pulseaudio"
+	audioBackendPipeWire   = "pipewire"
+)
+
+var supportedAudioBackends = []string{audioBackendPulseAudio, audioBackendPipeWire}
+
+// sessionEventSource is implemented by session finders that get notified when sessions come and go,
+// which spares the session map from having to re-acquire all sessions just to look for new ones
+type sessionEventSource interface {
+	SubscribeToSessionEvents() chan sessionEvent
+}
+
+type sessionEventType int
+
+const (
+	sessionAdded sessionEventType = iota
+	sessionRemoved
+
+	// something changed that can't be expressed as a single session coming or going (i.e. a device appeared),
+	// so all sessions should be re-acquired
+	sessionsChanged
+)
+
+// sessionEvent describes a change in the available audio sessions. session is nil for sessionsChanged
+type sessionEvent struct {
+	Type    sessionEventType
+	Session Session
+}
@@ -3,6 +3,7 @@ package deej
 import (
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/jfreymuth/pulse/proto"
 	"go.uber.org/zap"
@@ -14,9 +15,62 @@ type paSessionFinder struct {
 
 	client *proto.Client
 	conn   net.Conn
+
+	// the last session created for each sink input, so that it can be found again once the sink input goes away
+	sinkInputSessions     map[uint32]*paSession
+	sinkInputSessionsLock sync.Mutex
+
+	// subscription events arrive on the client's read loop, which can't make requests of its own,
+	// so they're handed off to processPulseEvents. if too many pile up, we ask for a full resync instead
+	pulseEvents          chan *proto.SubscribeEvent
+	pulseEventsOverflown chan bool
+
+	// events are already being processed by the time anyone subscribes to them
+	sessionEventConsumers     []chan sessionEvent
+	sessionEventConsumersLock sync.Mutex
+
+	// the devices our master sessions were created for, to tell when the defaults change
+	defaultSinkName   string
+	defaultSourceName string
+	defaultsLock      sync.Mutex
+}
+
+// PulseAudio's subscription masks and event bits (see pulse/def.h), which the proto package doesn't define
+const (
+	paSubscriptionMaskSink      = 0x0001
+	paSubscriptionMaskSource    = 0x0002
+	paSubscriptionMaskSinkInput = 0x0004
+	paSubscriptionMaskServer    = 0x0080
+
+	paEventFacilityMask      = 0x000F
+	paEventFacilitySink      = 0x0000
+	paEventFacilitySource    = 0x0001
+	paEventFacilitySinkInput = 0x0002
+	paEventFacilityServer    = 0x0007
+
+	paEventTypeMask   = 0x0030
+	paEventTypeNew    = 0x0000
+	paEventTypeRemove = 0x0020
+
+	pulseEventBufferSize = 64
+)
+
+// newSessionFinder creates a session finder for the configured audio backend. PipeWire's own protocol is only
+// spoken when asked for - if that fails, we fall back to PulseAudio (which PipeWire also speaks, through pipewire-pulse)
+func newSessionFinder(logger *zap.SugaredLogger, config *CanonicalConfig) (SessionFinder, error) {
+	if config.AudioBackend == audioBackendPipeWire {
+		sf, err := newPWSessionFinder(logger)
+		if err == nil {
+			return sf, nil
+		}
+
+		logger.Warnw("Failed to create PipeWire session finder, falling back to PulseAudio", "error", err)
+	}
+
+	return newPASessionFinder(logger)
 }
 
-func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
+func newPASessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
 	client, conn, err := proto.Connect("")
 	if err != nil {
 		logger.Warnw("Failed to establish PulseAudio connection", "error", err)
@@ -35,10 +89,18 @@ func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
 	}
 
 	sf := &paSessionFinder{
-		logger:        logger.Named("session_finder"),
-		sessionLogger: logger.Named("sessions"),
-		client:        client,
-		conn:          conn,
+		logger:               logger.Named("session_finder"),
+		sessionLogger:        logger.Named("sessions"),
+		client:               client,
+		conn:                 conn,
+		sinkInputSessions:    map[uint32]*paSession{},
+		pulseEvents:          make(chan *proto.SubscribeEvent, pulseEventBufferSize),
+		pulseEventsOverflown: make(chan bool, 1),
+	}
+
+	// subscribing is only an optimization - without it, the session map falls back to periodically re-acquiring sessions
+	if err := sf.subscribe(); err != nil {
+		sf.logger.Warnw("Failed to subscribe to PulseAudio events", "error", err)
 	}
 
 	sf.logger.Debug("Created PA session finder instance")
@@ -65,6 +127,11 @@ func (sf *paSessionFinder) GetAllSessions() ([]Session, error) {
 		sf.logger.Warnw("Failed to get master audio source session", "error", err)
 	}
 
+	// enumerate sinks and sources, so that each device can be targeted regardless of which is the default
+	if err := sf.enumerateAndAddDevices(&sessions); err != nil {
+		sf.logger.Warnw("Failed to enumerate audio devices", "error", err)
+	}
+
 	// enumerate sink inputs and add sessions along the way
 	if err := sf.enumerateAndAddSessions(&sessions); err != nil {
 		sf.logger.Warnw("Failed to enumerate audio sessions", "error", err)
@@ -74,6 +141,18 @@ func (sf *paSessionFinder) GetAllSessions() ([]Session, error) {
 	return sessions, nil
 }
 
+// SubscribeToSessionEvents returns an unbuffered channel that receives
+// a sessionEvent struct every time a sink input, sink or source appears or goes away
+func (sf *paSessionFinder) SubscribeToSessionEvents() chan sessionEvent {
+	ch := make(chan sessionEvent)
+
+	sf.sessionEventConsumersLock.Lock()
+	sf.sessionEventConsumers = append(sf.sessionEventConsumers, ch)
+	sf.sessionEventConsumersLock.Unlock()
+
+	return ch
+}
+
 func (sf *paSessionFinder) Release() error {
 	if err := sf.conn.Close(); err != nil {
 		sf.logger.Warnw("Failed to close PulseAudio connection", "error", err)
@@ -96,6 +175,10 @@ func (sf *paSessionFinder) getMasterSinkSession() (Session, error) {
 		return nil, fmt.Errorf("get master sink info: %w", err)
 	}
 
+	sf.defaultsLock.Lock()
+	sf.defaultSinkName = reply.SinkName
+	sf.defaultsLock.Unlock()
+
 	// create the master sink session
 	sink := newMasterSession(sf.sessionLogger, sf.client, reply.SinkIndex, reply.Channels, true)
 
@@ -113,12 +196,50 @@ func (sf *paSessionFinder) getMasterSourceSession() (Session, error) {
 		return nil, fmt.Errorf("get master source info: %w", err)
 	}
 
+	sf.defaultsLock.Lock()
+	sf.defaultSourceName = reply.SourceName
+	sf.defaultsLock.Unlock()
+
 	// create the master source session
 	source := newMasterSession(sf.sessionLogger, sf.client, reply.SourceIndex, reply.Channels, false)
 
 	return source, nil
 }
 
+func (sf *paSessionFinder) enumerateAndAddDevices(sessions *[]Session) error {
+	sinkRequest := proto.GetSinkInfoList{}
+	sinkReply := proto.GetSinkInfoListReply{}
+
+	if err := sf.client.Request(&sinkRequest, &sinkReply); err != nil {
+		return fmt.Errorf("get sink list: %w", err)
+	}
+
+	for _, info := range sinkReply {
+		*sessions = append(*sessions, newDeviceSession(sf.sessionLogger, sf.client,
+			info.SinkIndex, info.Channels, true, info.SinkName, info.Device))
+	}
+
+	sourceRequest := proto.GetSourceInfoList{}
+	sourceReply := proto.GetSourceInfoListReply{}
+
+	if err := sf.client.Request(&sourceRequest, &sourceReply); err != nil {
+		return fmt.Errorf("get source list: %w", err)
+	}
+
+	for _, info := range sourceReply {
+
+		// every sink has a monitor source, which records whatever it plays. they're rarely what anyone means by a source
+		if info.MonitorSourceIndex != proto.Undefined {
+			continue
+		}
+
+		*sessions = append(*sessions, newDeviceSession(sf.sessionLogger, sf.client,
+			info.SourceIndex, info.Channels, false, info.SourceName, info.Device))
+	}
+
+	return nil
+}
+
 func (sf *paSessionFinder) enumerateAndAddSessions(sessions *[]Session) error {
 	request := proto.GetSinkInputInfoList{}
 	reply := proto.GetSinkInputInfoListReply{}
@@ -128,19 +249,17 @@ func (sf *paSessionFinder) enumerateAndAddSessions(sessions *[]Session) error {
 		return fmt.Errorf("get sink input list: %w", err)
 	}
 
-	for _, info := range reply {
-		name, ok := info.Properties["application.process.binary"]
+	sf.sinkInputSessionsLock.Lock()
+	defer sf.sinkInputSessionsLock.Unlock()
 
-		if !ok {
-			sf.logger.Warnw("Failed to get sink input's process name",
-				"sinkInputIndex", info.SinkInputIndex)
+	sf.sinkInputSessions = map[uint32]*paSession{}
 
+	for _, info := range reply {
+		newSession, ok := sf.newSessionFromSinkInput(info)
+		if !ok {
 			continue
 		}
 
-		// create the deej session object
-		newSession := newPASession(sf.sessionLogger, sf.client, info.SinkInputIndex, info.Channels, name.String())
-
 		// add it to our slice
 		*sessions = append(*sessions, newSession)
 
@@ -148,3 +267,188 @@ func (sf *paSessionFinder) enumerateAndAddSessions(sessions *[]Session) error {
 
 	return nil
 }
+
+// newSessionFromSinkInput creates a deej session object for the given sink input.
+// the second return value is false if the sink input can't be told apart from others
+func (sf *paSessionFinder) newSessionFromSinkInput(info *proto.GetSinkInputInfoReply) (*paSession, bool) {
+	properties := parsePropList(info.Properties)
+
+	// sandboxed apps (i.e. flatpaks) don't always tell PulseAudio which binary they're running,
+	// but practically every app at least has a name
+	name, ok := properties["application.process.binary"]
+	if !ok {
+		name, ok = properties["application.name"]
+	}
+
+	if !ok {
+		sf.logger.Warnw("Failed to get sink input's process name",
+			"sinkInputIndex", info.SinkInputIndex)
+
+		return nil, false
+	}
+
+	newSession := newPASession(sf.sessionLogger, sf.client, info.SinkInputIndex, info.Channels, name, properties)
+	sf.sinkInputSessions[info.SinkInputIndex] = newSession
+
+	return newSession, true
+}
+
+func (sf *paSessionFinder) subscribe() error {
+	sf.client.Callback = sf.onPulseMessage
+
+	request := proto.Subscribe{
+		Mask: paSubscriptionMaskSink | paSubscriptionMaskSource | paSubscriptionMaskSinkInput | paSubscriptionMaskServer,
+	}
+
+	if err := sf.client.Request(&request, nil); err != nil {
+		sf.client.Callback = nil
+		return fmt.Errorf("subscribe to PulseAudio events: %w", err)
+	}
+
+	go sf.processPulseEvents()
+
+	return nil
+}
+
+// onPulseMessage is called on the client's read loop for anything the server sends on its own accord.
+// it mustn't block, and mustn't make requests (their replies would never be read)
+func (sf *paSessionFinder) onPulseMessage(message interface{}) {
+	event, ok := message.(*proto.SubscribeEvent)
+	if !ok {
+		return
+	}
+
+	select {
+	case sf.pulseEvents <- event:
+	default:
+		select {
+		case sf.pulseEventsOverflown <- true:
+		default:
+		}
+	}
+}
+
+func (sf *paSessionFinder) processPulseEvents() {
+	for {
+		select {
+		case event := <-sf.pulseEvents:
+			sf.handlePulseEvent(event)
+
+		case <-sf.pulseEventsOverflown:
+			sf.logger.Warn("Missed some PulseAudio events, asking for all sessions to be re-acquired")
+			sf.notifySessionEvent(sessionEvent{Type: sessionsChanged})
+		}
+	}
+}
+
+func (sf *paSessionFinder) handlePulseEvent(event *proto.SubscribeEvent) {
+	facility := event.Event & paEventFacilityMask
+	eventType := event.Event & paEventTypeMask
+
+	// the server only ever changes, which is how we learn that the default sink or source moved
+	if facility == paEventFacilityServer {
+		sf.handleServerChanged()
+		return
+	}
+
+	// changes to existing streams (volumes, mostly - including our own) don't affect which sessions there are
+	if eventType != paEventTypeNew && eventType != paEventTypeRemove {
+		return
+	}
+
+	switch facility {
+	case paEventFacilitySink, paEventFacilitySource:
+		sf.logger.Debugw("Audio device added or removed", "index", event.Index)
+		sf.notifySessionEvent(sessionEvent{Type: sessionsChanged})
+
+	case paEventFacilitySinkInput:
+		if eventType == paEventTypeNew {
+			sf.handleSinkInputAdded(event.Index)
+		} else {
+			sf.handleSinkInputRemoved(event.Index)
+		}
+	}
+}
+
+// handleServerChanged checks whether the default sink or source changed, and if so, asks for all sessions to be
+// re-acquired. this re-creates the master and mic sessions for the new defaults, like the windows finder does
+func (sf *paSessionFinder) handleServerChanged() {
+	request := proto.GetServerInfo{}
+	reply := proto.GetServerInfoReply{}
+
+	if err := sf.client.Request(&request, &reply); err != nil {
+		sf.logger.Warnw("Failed to get server info", "error", err)
+		return
+	}
+
+	sf.defaultsLock.Lock()
+	changed := reply.DefaultSinkName != sf.defaultSinkName || reply.DefaultSourceName != sf.defaultSourceName
+	sf.defaultsLock.Unlock()
+
+	if !changed {
+		return
+	}
+
+	sf.logger.Debugw("Default audio device changed, re-acquiring master sessions",
+		"sink", reply.DefaultSinkName,
+		"source", reply.DefaultSourceName)
+
+	sf.notifySessionEvent(sessionEvent{Type: sessionsChanged})
+}
+
+func (sf *paSessionFinder) handleSinkInputAdded(sinkInputIndex uint32) {
+	request := proto.GetSinkInputInfo{
+		SinkInputIndex: sinkInputIndex,
+	}
+	reply := proto.GetSinkInputInfoReply{}
+
+	// the sink input may already be gone by now, which isn't worth more than a debug line
+	if err := sf.client.Request(&request, &reply); err != nil {
+		sf.logger.Debugw("Failed to get new sink input info", "sinkInputIndex", sinkInputIndex, "error", err)
+		return
+	}
+
+	sf.sinkInputSessionsLock.Lock()
+	newSession, ok := sf.newSessionFromSinkInput(&reply)
+	sf.sinkInputSessionsLock.Unlock()
+
+	if ok {
+		sf.notifySessionEvent(sessionEvent{Type: sessionAdded, Session: newSession})
+	}
+}
+
+func (sf *paSessionFinder) handleSinkInputRemoved(sinkInputIndex uint32) {
+	sf.sinkInputSessionsLock.Lock()
+	session, ok := sf.sinkInputSessions[sinkInputIndex]
+	delete(sf.sinkInputSessions, sinkInputIndex)
+	sf.sinkInputSessionsLock.Unlock()
+
+	if ok {
+		sf.notifySessionEvent(sessionEvent{Type: sessionRemoved, Session: session})
+	}
+}
+
+func (sf *paSessionFinder) notifySessionEvent(event sessionEvent) {
+	sf.sessionEventConsumersLock.Lock()
+	consumers := sf.sessionEventConsumers
+	sf.sessionEventConsumersLock.Unlock()
+
+	for _, consumer := range consumers {
+		consumer <- event
+	}
+}
+
+// parsePropList returns the string-valued entries of a PulseAudio property list, skipping binary ones
+func parsePropList(propList proto.PropList) map[string]string {
+	properties := map[string]string{}
+
+	for key, entry := range propList {
+		if len(entry) == 0 || entry[len(entry)-1] != 0 {
+			continue
+		}
+
+		properties[key] = entry.String()
+	}
+
+	return properties
+}
@@ -0,0 +1,895 @@
+package deej
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// pwSessionFinder talks to PipeWire directly rather than through its PulseAudio compatibility layer. it keeps track of
+// every audio node (streams, sinks and sources, including virtual ones and filter chains) and their volumes as the
+// server reports them, so getting sessions and their volumes doesn't take a roundtrip to the server
+type pwSessionFinder struct {
+	logger        *zap.SugaredLogger
+	sessionLogger *zap.SugaredLogger
+
+	conn       *pwConnection
+	registryID uint32
+
+	// everything below is updated by the connection's read loop
+	lock sync.Mutex
+
+	nodes   map[uint32]*pwNode   // by global id
+	devices map[uint32]*pwDevice // by global id
+
+	// what each of our bound objects stands for, by its object id
+	boundObjects map[uint32]pwBoundObject
+
+	defaultSinkName   string
+	defaultSourceName string
+
+	// set once the initial state has been received, before which changes aren't worth reporting
+	ready bool
+
+	// the last session created for each stream node, so that it can be found again once the stream goes away
+	streamSessions map[uint32]*pwStreamSession
+
+	// node events are queued up by the read loop (which mustn't block, or create sessions while holding the lock)
+	// and turned into session events by processNodeEvents. if too many pile up, we ask for a full resync instead
+	nodeEvents          chan pwNodeEvent
+	nodeEventsOverflown chan bool
+
+	// events are already being processed by the time anyone subscribes to them
+	sessionEventConsumers     []chan sessionEvent
+	sessionEventConsumersLock sync.Mutex
+}
+
+// pwNodeEvent is a stream, sink or source node coming or going
+type pwNodeEvent struct {
+	eventType sessionEventType
+	node      pwNode
+}
+
+// pwNode holds what we know about a single node
+type pwNode struct {
+	globalID   uint32
+	properties map[string]string
+
+	// linear, as PipeWire has them (unlike PulseAudio's cubic volumes)
+	channelVolumes []float32
+	channelMap     []uint32 // which channel is which, as SPA audio channel positions
+	channelRatios  channelRatios
+	mute           bool
+
+	hasProperties bool
+	hasVolumes    bool
+	announced     bool
+}
+
+// pwDevice holds the active routes of a single device (i.e. a sound card's "speakers" or "headphones" port).
+// nodes that belong to a device have their volume set through its route, so that hardware volume is used
+type pwDevice struct {
+	globalID uint32
+	routes   map[int32]int32 // route index, by the route's device index (which nodes refer to as card.profile.device)
+}
+
+type pwBoundObject struct {
+	globalType string
+	globalID   uint32
+}
+
+// what SPA calls the parameters and properties we use (see spa/param/param.h, spa/param/props.h and spa/param/route.h)
+const (
+	spaParamProps = 2
+	spaParamRoute = 13
+
+	spaTypeObjectProps      = 0x40002
+	spaTypeObjectParamRoute = 0x40009
+
+	spaPropMute           = 0x10004
+	spaPropChannelVolumes = 0x10008
+	spaPropChannelMap     = 0x1000b
+
+	spaParamRouteIndex  = 1
+	spaParamRouteDevice = 3
+	spaParamRouteProps  = 10
+	spaParamRouteSave   = 13
+
+	// SPA audio channel positions (see spa/param/audio/raw.h)
+	spaAudioChannelFL  = 3
+	spaAudioChannelFR  = 4
+	spaAudioChannelSL  = 7
+	spaAudioChannelSR  = 8
+	spaAudioChannelFLC = 9
+	spaAudioChannelFRC = 10
+	spaAudioChannelRL  = 12
+	spaAudioChannelRR  = 13
+	spaAudioChannelTFL = 15
+	spaAudioChannelTFR = 17
+	spaAudioChannelTRL = 18
+	spaAudioChannelTRR = 20
+
+	pwNodeChangeMaskProps = 1 << 3
+
+	// node info events are structs of: id, max input ports, max output ports, change mask,
+	// input ports, output ports, state, error, properties and params
+	pwNodeInfoFieldChangeMask = 3
+	pwNodeInfoFieldProperties = 8
+)
+
+// media classes of the nodes we expose as sessions
+const (
+	pwMediaClassStream = "Stream/Output/Audio"
+	pwMediaClassSink   = "Audio/Sink"
+	pwMediaClassSource = "Audio/Source"
+	pwMediaClassDevice = "Audio/Device"
+
+	pwDefaultsMetadataName = "default"
+	pwDefaultSinkKey       = "default.audio.sink"
+	pwDefaultSourceKey     = "default.audio.source"
+
+	pwNodeEventBufferSize = 64
+)
+
+var errPipeWireNodeGone = errors.New("PipeWire node no longer exists")
+
+func newPWSessionFinder(logger *zap.SugaredLogger) (*pwSessionFinder, error) {
+	sf := &pwSessionFinder{
+		logger:              logger.Named("session_finder"),
+		sessionLogger:       logger.Named("sessions"),
+		nodes:               map[uint32]*pwNode{},
+		devices:             map[uint32]*pwDevice{},
+		boundObjects:        map[uint32]pwBoundObject{},
+		streamSessions:      map[uint32]*pwStreamSession{},
+		nodeEvents:          make(chan pwNodeEvent, pwNodeEventBufferSize),
+		nodeEventsOverflown: make(chan bool, 1),
+	}
+
+	// the registry's id must be known before its first events are handled
+	sf.lock.Lock()
+
+	conn, err := newPWConnection(sf.logger, sf.handleEvent)
+	if err != nil {
+		sf.lock.Unlock()
+		return nil, fmt.Errorf("establish PipeWire connection: %w", err)
+	}
+
+	sf.conn = conn
+
+	sf.registryID, err = conn.getRegistry()
+	sf.lock.Unlock()
+
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	// the first roundtrip gets us every global (which we bind as they come), the second gets us what we bound them for
+	for i := 0; i < 2; i++ {
+		if err := conn.roundtrip(); err != nil {
+			conn.close()
+			return nil, fmt.Errorf("get initial PipeWire state: %w", err)
+		}
+	}
+
+	sf.lock.Lock()
+	sf.ready = true
+	sf.lock.Unlock()
+
+	go sf.processNodeEvents()
+
+	sf.logger.Debug("Created PW session finder instance")
+
+	return sf, nil
+}
+
+func (sf *pwSessionFinder) GetAllSessions() ([]Session, error) {
+	select {
+	case <-sf.conn.closed:
+		return nil, errPipeWireConnectionClosed
+	default:
+	}
+
+	sf.lock.Lock()
+
+	nodes := []pwNode{}
+	for _, node := range sf.nodes {
+		if node.hasProperties {
+			nodes = append(nodes, *node)
+		}
+	}
+
+	defaultSinkName, defaultSourceName := sf.defaultSinkName, sf.defaultSourceName
+
+	sf.lock.Unlock()
+
+	sessions := []Session{}
+	streamSessions := map[uint32]*pwStreamSession{}
+
+	for _, node := range nodes {
+		nodeName := node.properties["node.name"]
+
+		switch pwNodeMediaClass(node.properties) {
+		case pwMediaClassStream:
+			session, ok := sf.newStreamSession(node)
+			if !ok {
+				continue
+			}
+
+			streamSessions[node.globalID] = session
+			sessions = append(sessions, session)
+
+		case pwMediaClassSink:
+			sessions = append(sessions, newPWDeviceSession(sf.sessionLogger, sf, node.globalID,
+				sinkTargetPrefix+nodeName, node.properties["node.description"]))
+
+			if nodeName == defaultSinkName {
+				sessions = append(sessions, newPWDeviceSession(sf.sessionLogger, sf, node.globalID, masterSessionName, ""))
+			}
+
+		case pwMediaClassSource:
+			sessions = append(sessions, newPWDeviceSession(sf.sessionLogger, sf, node.globalID,
+				sourceTargetPrefix+nodeName, node.properties["node.description"]))
+
+			if nodeName == defaultSourceName {
+				sessions = append(sessions, newPWDeviceSession(sf.sessionLogger, sf, node.globalID, inputSessionName, ""))
+			}
+		}
+	}
+
+	sf.lock.Lock()
+	sf.streamSessions = streamSessions
+	sf.lock.Unlock()
+
+	return sessions, nil
+}
+
+// SubscribeToSessionEvents returns an unbuffered channel that receives
+// a sessionEvent struct every time a stream, sink or source appears or goes away
+func (sf *pwSessionFinder) SubscribeToSessionEvents() chan sessionEvent {
+	ch := make(chan sessionEvent)
+
+	sf.sessionEventConsumersLock.Lock()
+	sf.sessionEventConsumers = append(sf.sessionEventConsumers, ch)
+	sf.sessionEventConsumersLock.Unlock()
+
+	return ch
+}
+
+func (sf *pwSessionFinder) Release() error {
+	if err := sf.conn.close(); err != nil {
+		sf.logger.Warnw("Failed to close PipeWire connection", "error", err)
+		return fmt.Errorf("close PipeWire connection: %w", err)
+	}
+
+	sf.logger.Debug("Released PW session finder instance")
+
+	return nil
+}
+
+// newStreamSession creates a deej session object for the given stream node.
+// the second return value is false if the stream can't be told apart from others
+func (sf *pwSessionFinder) newStreamSession(node pwNode) (*pwStreamSession, bool) {
+
+	// same as with PulseAudio, sandboxed apps may not say which binary they're running.
+	// streams of filter chains and loopbacks don't belong to an app at all, but do have a node name
+	for _, key := range []string{"application.process.binary", "application.name", "node.name"} {
+		if name, ok := node.properties[key]; ok && name != "" {
+			return newPWStreamSession(sf.sessionLogger, sf, node.globalID, name, node.properties), true
+		}
+	}
+
+	sf.logger.Warnw("Failed to get stream's process name", "nodeID", node.globalID)
+
+	return nil, false
+}
+
+// pwNodeMediaClass returns which of the media classes we care about a node belongs to, if any
+func pwNodeMediaClass(properties map[string]string) string {
+	mediaClass := properties["media.class"]
+
+	switch {
+	case mediaClass == pwMediaClassStream:
+		return pwMediaClassStream
+
+	// virtual sinks and sources have their own sub-classes
+	case strings.HasPrefix(mediaClass, pwMediaClassSink):
+		return pwMediaClassSink
+	case strings.HasPrefix(mediaClass, pwMediaClassSource):
+		return pwMediaClassSource
+	}
+
+	return ""
+}
+
+// nodeChannels returns the given node's channel levels on PulseAudio's (cubic) scale, to keep sliders feeling the same,
+// along with which side each channel is on
+func (sf *pwSessionFinder) nodeChannels(globalID uint32) ([]float64, []channelSide, bool) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	node, ok := sf.nodes[globalID]
+	if !ok || len(node.channelVolumes) == 0 {
+		return nil, nil, false
+	}
+
+	return pwChannelLevels(node.channelVolumes), pwChannelSides(node.channelMap), true
+}
+
+func (sf *pwSessionFinder) nodeMute(globalID uint32) (bool, bool) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	node, ok := sf.nodes[globalID]
+	if !ok || !node.hasVolumes {
+		return false, false
+	}
+
+	return node.mute, true
+}
+
+// setNodeVolume sets the given node's volume (on PulseAudio's cubic scale), keeping its channels' balance
+func (sf *pwSessionFinder) setNodeVolume(globalID uint32, volume float32) error {
+	return sf.updateNodeChannels(globalID, func(node *pwNode, levels []float64, _ []channelSide) []float64 {
+		return node.channelRatios.scale(levels, float64(volume))
+	})
+}
+
+func (sf *pwSessionFinder) setNodeBalance(globalID uint32, balance float32) error {
+	return sf.updateNodeChannels(globalID, func(_ *pwNode, levels []float64, sides []channelSide) []float64 {
+		return balanceChannelLevels(levels, sides, balance)
+	})
+}
+
+// updateNodeChannels changes the given node's channel volumes, with update working on their levels
+// (on PulseAudio's cubic scale) and the side each one is on. update is called with the lock held
+func (sf *pwSessionFinder) updateNodeChannels(
+	globalID uint32,
+	update func(*pwNode, []float64, []channelSide) []float64,
+) error {
+	sf.lock.Lock()
+
+	node, ok := sf.nodes[globalID]
+	if !ok {
+		sf.lock.Unlock()
+		return errPipeWireNodeGone
+	}
+
+	if len(node.channelVolumes) == 0 {
+		sf.lock.Unlock()
+		return fmt.Errorf("node %d has no known channels", globalID)
+	}
+
+	channelVolumes := pwChannelVolumes(update(node, pwChannelLevels(node.channelVolumes), pwChannelSides(node.channelMap)))
+
+	// assume it worked, so that the next slider move compares against the right volume. if it didn't,
+	// the server reports the actual volume soon enough
+	node.channelVolumes = channelVolumes
+
+	sf.lock.Unlock()
+
+	return sf.setNodeProps(node, podEntry{Key: spaPropChannelVolumes, Value: podFloatArray(channelVolumes)})
+}
+
+func (sf *pwSessionFinder) setNodeMute(globalID uint32, mute bool) error {
+	sf.lock.Lock()
+
+	node, ok := sf.nodes[globalID]
+	if !ok {
+		sf.lock.Unlock()
+		return errPipeWireNodeGone
+	}
+
+	node.mute = mute
+
+	sf.lock.Unlock()
+
+	return sf.setNodeProps(node, podEntry{Key: spaPropMute, Value: podBool(mute)})
+}
+
+// setNodeProps changes the given node's props, through its device's route if it has one
+// (this is how PipeWire's own PulseAudio layer does it, and what makes the change stick across restarts)
+func (sf *pwSessionFinder) setNodeProps(node *pwNode, entry podEntry) error {
+	sf.lock.Lock()
+	deviceObjectID, routeIndex, routeDevice, hasRoute := sf.nodeRoute(node)
+	nodeObjectID, hasNodeObject := sf.objectIDOf(node.globalID)
+	sf.lock.Unlock()
+
+	if hasRoute {
+		route := podObject(spaTypeObjectParamRoute, spaParamRoute,
+			podEntry{Key: spaParamRouteIndex, Value: podInt(routeIndex)},
+			podEntry{Key: spaParamRouteDevice, Value: podInt(routeDevice)},
+			podEntry{Key: spaParamRouteProps, Value: podObject(spaTypeObjectProps, spaParamRoute, entry)},
+			podEntry{Key: spaParamRouteSave, Value: podBool(true)},
+		)
+
+		if err := sf.conn.send(deviceObjectID, pwMethodSetParam, podStruct(podID(spaParamRoute), podInt(0), route)); err != nil {
+			return fmt.Errorf("set device route param: %w", err)
+		}
+
+		return nil
+	}
+
+	if !hasNodeObject {
+		return errPipeWireNodeGone
+	}
+
+	props := podObject(spaTypeObjectProps, spaParamProps, entry)
+
+	if err := sf.conn.send(nodeObjectID, pwMethodSetParam, podStruct(podID(spaParamProps), podInt(0), props)); err != nil {
+		return fmt.Errorf("set node props param: %w", err)
+	}
+
+	return nil
+}
+
+// nodeRoute returns the object id of the device the given node belongs to, along with the index and device index
+// of the device's route that the node plays to (or records from). assumes the lock is held
+func (sf *pwSessionFinder) nodeRoute(node *pwNode) (uint32, int32, int32, bool) {
+	deviceID, err := strconv.ParseUint(node.properties["device.id"], 10, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	routeDevice, err := strconv.ParseInt(node.properties["card.profile.device"], 10, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	device, ok := sf.devices[uint32(deviceID)]
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	routeIndex, ok := device.routes[int32(routeDevice)]
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	deviceObjectID, ok := sf.objectIDOf(device.globalID)
+
+	return deviceObjectID, routeIndex, int32(routeDevice), ok
+}
+
+// pwChannelLevels converts PipeWire's linear channel volumes to PulseAudio's cubic scale
+func pwChannelLevels(channelVolumes []float32) []float64 {
+	levels := make([]float64, len(channelVolumes))
+
+	for idx, volume := range channelVolumes {
+		levels[idx] = math.Cbrt(float64(volume))
+	}
+
+	return levels
+}
+
+func pwChannelVolumes(levels []float64) []float32 {
+	channelVolumes := make([]float32, len(levels))
+
+	for idx, level := range levels {
+		channelVolumes[idx] = float32(math.Pow(level, 3))
+	}
+
+	return channelVolumes
+}
+
+// pwChannelSides tells which of a node's channels are on the left and which are on the right
+func pwChannelSides(channelMap []uint32) []channelSide {
+	sides := make([]channelSide, len(channelMap))
+
+	for idx, position := range channelMap {
+		switch position {
+		case spaAudioChannelFL, spaAudioChannelSL, spaAudioChannelFLC, spaAudioChannelRL, spaAudioChannelTFL, spaAudioChannelTRL:
+			sides[idx] = channelSideLeft
+		case spaAudioChannelFR, spaAudioChannelSR, spaAudioChannelFRC, spaAudioChannelRR, spaAudioChannelTFR, spaAudioChannelTRR:
+			sides[idx] = channelSideRight
+		}
+	}
+
+	return sides
+}
+
+// objectIDOf returns the id of the object we bound the given global to. assumes the lock is held
+func (sf *pwSessionFinder) objectIDOf(globalID uint32) (uint32, bool) {
+	for objectID, boundObject := range sf.boundObjects {
+		if boundObject.globalID == globalID {
+			return objectID, true
+		}
+	}
+
+	return 0, false
+}
+
+// handleEvent is called on the connection's read loop for every event that isn't addressed to the core
+func (sf *pwSessionFinder) handleEvent(objectID uint32, opcode byte, payload pod) {
+	fields, err := payload.structFields()
+	if err != nil {
+		sf.logger.Warnw("Failed to parse PipeWire event", "objectID", objectID, "opcode", opcode, "error", err)
+		return
+	}
+
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	if objectID == sf.registryID {
+		switch opcode {
+		case pwRegistryEventGlobal:
+			sf.handleGlobal(fields)
+		case pwRegistryEventGlobalRemove:
+			sf.handleGlobalRemove(fields)
+		}
+
+		return
+	}
+
+	boundObject, ok := sf.boundObjects[objectID]
+	if !ok {
+		return
+	}
+
+	switch {
+	case boundObject.globalType == pwTypeNode && opcode == pwEventInfo:
+		sf.handleNodeInfo(boundObject.globalID, fields)
+	case boundObject.globalType == pwTypeNode && opcode == pwEventParam:
+		sf.handleNodeParam(boundObject.globalID, fields)
+	case boundObject.globalType == pwTypeDevice && opcode == pwEventParam:
+		sf.handleDeviceParam(boundObject.globalID, fields)
+	case boundObject.globalType == pwTypeMetadata && opcode == pwMetadataEventProperty:
+		sf.handleMetadataProperty(fields)
+	}
+}
+
+// handleGlobal binds the globals we're interested in as they're announced: audio nodes, audio devices and the
+// metadata that holds the default sink and source. the announcement only carries a few of each global's properties
+func (sf *pwSessionFinder) handleGlobal(fields []pod) {
+	if len(fields) < 5 {
+		return
+	}
+
+	globalID, idErr := fields[0].intValue()
+	globalType, typeErr := fields[2].stringValue()
+	properties, propertiesErr := fields[4].dictValue()
+
+	if idErr != nil || typeErr != nil || propertiesErr != nil {
+		sf.logger.Warnw("Failed to parse PipeWire global", "fields", len(fields))
+		return
+	}
+
+	var version int32
+	var subscribeTo uint32
+
+	// globals that don't say what they are get the benefit of the doubt
+	mediaClass, hasMediaClass := properties["media.class"]
+
+	switch globalType {
+	case pwTypeNode:
+		if hasMediaClass && pwNodeMediaClass(properties) == "" {
+			return
+		}
+
+		version, subscribeTo = pwNodeVersion, spaParamProps
+		sf.nodes[uint32(globalID)] = &pwNode{globalID: uint32(globalID)}
+
+	case pwTypeDevice:
+		if hasMediaClass && mediaClass != pwMediaClassDevice {
+			return
+		}
+
+		version, subscribeTo = pwDeviceVersion, spaParamRoute
+		sf.devices[uint32(globalID)] = &pwDevice{globalID: uint32(globalID), routes: map[int32]int32{}}
+
+	case pwTypeMetadata:
+		if properties["metadata.name"] != pwDefaultsMetadataName {
+			return
+		}
+
+		version = pwMetadataVersion
+
+	default:
+		return
+	}
+
+	objectID, err := sf.conn.bind(sf.registryID, uint32(globalID), globalType, version)
+	if err != nil {
+		sf.logger.Warnw("Failed to bind PipeWire global", "globalID", globalID, "type", globalType, "error", err)
+		return
+	}
+
+	sf.boundObjects[objectID] = pwBoundObject{globalType: globalType, globalID: uint32(globalID)}
+
+	// ask to be told about the params we care about, now and whenever they change
+	if subscribeTo != 0 {
+		if err := sf.conn.send(objectID, pwMethodSubscribeParams, podStruct(podArray(podTypeID, []uint32{subscribeTo}))); err != nil {
+			sf.logger.Warnw("Failed to subscribe to PipeWire params", "globalID", globalID, "error", err)
+		}
+	}
+}
+
+func (sf *pwSessionFinder) handleGlobalRemove(fields []pod) {
+	if len(fields) < 1 {
+		return
+	}
+
+	globalID, err := fields[0].intValue()
+	if err != nil {
+		return
+	}
+
+	// the server gets rid of our bound object along with the global
+	for objectID, boundObject := range sf.boundObjects {
+		if boundObject.globalID == uint32(globalID) {
+			delete(sf.boundObjects, objectID)
+
+			if boundObject.globalType == pwTypeMetadata {
+				sf.setDefaults("", "")
+			}
+		}
+	}
+
+	delete(sf.devices, uint32(globalID))
+
+	node, ok := sf.nodes[uint32(globalID)]
+	if !ok {
+		return
+	}
+
+	delete(sf.nodes, uint32(globalID))
+
+	if !node.announced {
+		return
+	}
+
+	switch pwNodeMediaClass(node.properties) {
+	case pwMediaClassStream:
+		sf.queueNodeEvent(pwNodeEvent{eventType: sessionRemoved, node: *node})
+
+	case pwMediaClassSink, pwMediaClassSource:
+		sf.logger.Debugw("Audio device removed", "nodeID", node.globalID)
+		sf.queueNodeEvent(pwNodeEvent{eventType: sessionsChanged})
+	}
+}
+
+func (sf *pwSessionFinder) handleNodeInfo(globalID uint32, fields []pod) {
+	node, ok := sf.nodes[globalID]
+	if !ok || len(fields) <= pwNodeInfoFieldProperties {
+		return
+	}
+
+	changeMask, err := fields[pwNodeInfoFieldChangeMask].longValue()
+	if err != nil || changeMask&pwNodeChangeMaskProps == 0 {
+		return
+	}
+
+	properties, err := fields[pwNodeInfoFieldProperties].dictValue()
+	if err != nil {
+		sf.logger.Warnw("Failed to parse PipeWire node properties", "nodeID", globalID, "error", err)
+		return
+	}
+
+	node.properties = properties
+	node.hasProperties = true
+
+	sf.announceNode(node)
+}
+
+func (sf *pwSessionFinder) handleNodeParam(globalID uint32, fields []pod) {
+	node, ok := sf.nodes[globalID]
+	if !ok || len(fields) < 5 {
+		return
+	}
+
+	// param events are structs of: sequence, param id, index, next index and the param itself
+	if paramID, err := fields[1].idValue(); err != nil || paramID != spaParamProps {
+		return
+	}
+
+	_, _, properties, err := fields[4].objectValue()
+	if err != nil {
+		sf.logger.Warnw("Failed to parse PipeWire node props", "nodeID", globalID, "error", err)
+		return
+	}
+
+	for _, property := range properties {
+		switch property.Key {
+		case spaPropChannelVolumes:
+			if channelVolumes, err := property.Value.floatArrayValue(); err == nil {
+				node.channelVolumes = channelVolumes
+				node.hasVolumes = true
+			}
+
+		case spaPropChannelMap:
+			if channelMap, err := property.Value.idArrayValue(); err == nil {
+				node.channelMap = channelMap
+			}
+
+		case spaPropMute:
+			if mute, err := property.Value.boolValue(); err == nil {
+				node.mute = mute
+			}
+		}
+	}
+
+	sf.announceNode(node)
+}
+
+// announceNode lets the session map know about a node once we know enough about it: its properties (to tell
+// which session it is) and its volumes (so its slider's value can be applied). assumes the lock is held
+func (sf *pwSessionFinder) announceNode(node *pwNode) {
+	if node.announced || !node.hasProperties || !node.hasVolumes {
+		return
+	}
+
+	node.announced = true
+
+	// everything that's there from the start is picked up by the first GetAllSessions call
+	if !sf.ready {
+		return
+	}
+
+	switch pwNodeMediaClass(node.properties) {
+	case pwMediaClassStream:
+		sf.queueNodeEvent(pwNodeEvent{eventType: sessionAdded, node: *node})
+
+	case pwMediaClassSink, pwMediaClassSource:
+		sf.logger.Debugw("Audio device added", "nodeID", node.globalID)
+		sf.queueNodeEvent(pwNodeEvent{eventType: sessionsChanged})
+	}
+}
+
+func (sf *pwSessionFinder) handleDeviceParam(globalID uint32, fields []pod) {
+	device, ok := sf.devices[globalID]
+	if !ok || len(fields) < 5 {
+		return
+	}
+
+	if paramID, err := fields[1].idValue(); err != nil || paramID != spaParamRoute {
+		return
+	}
+
+	_, _, properties, err := fields[4].objectValue()
+	if err != nil {
+		sf.logger.Warnw("Failed to parse PipeWire device route", "deviceID", globalID, "error", err)
+		return
+	}
+
+	routeIndex, routeDevice := int32(-1), int32(-1)
+
+	for _, property := range properties {
+		switch property.Key {
+		case spaParamRouteIndex:
+			routeIndex, _ = property.Value.intValue()
+		case spaParamRouteDevice:
+			routeDevice, _ = property.Value.intValue()
+		}
+	}
+
+	if routeIndex >= 0 && routeDevice >= 0 {
+		device.routes[routeDevice] = routeIndex
+	}
+}
+
+// handleMetadataProperty looks for changes to the default sink and source, which the metadata holds as json
+// objects like {"name":"alsa_output.pci-0000_00_1f.3.analog-stereo"}
+func (sf *pwSessionFinder) handleMetadataProperty(fields []pod) {
+	if len(fields) < 4 {
+		return
+	}
+
+	// metadata properties are structs of: subject, key, type and value. the defaults apply to the whole server (subject 0)
+	subject, _ := fields[0].intValue()
+	key, _ := fields[1].stringValue()
+	value, _ := fields[3].stringValue()
+
+	if subject != 0 {
+		return
+	}
+
+	defaultNode := struct {
+		Name string `json:"name"`
+	}{}
+
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &defaultNode); err != nil {
+			sf.logger.Warnw("Failed to parse PipeWire default node", "key", key, "value", value, "error", err)
+			return
+		}
+	}
+
+	switch key {
+	case pwDefaultSinkKey:
+		sf.setDefaults(defaultNode.Name, sf.defaultSourceName)
+	case pwDefaultSourceKey:
+		sf.setDefaults(sf.defaultSinkName, defaultNode.Name)
+
+	// a null key means all of the metadata's properties were cleared
+	case "":
+		sf.setDefaults("", "")
+	}
+}
+
+// setDefaults updates the default sink and source names, asking for all sessions to be re-acquired if either changed
+// (which re-creates the master and mic sessions). assumes the lock is held
+func (sf *pwSessionFinder) setDefaults(sinkName string, sourceName string) {
+	if sinkName == sf.defaultSinkName && sourceName == sf.defaultSourceName {
+		return
+	}
+
+	sf.defaultSinkName, sf.defaultSourceName = sinkName, sourceName
+
+	if sf.ready {
+		sf.logger.Debugw("Default audio device changed, re-acquiring master sessions", "sink", sinkName, "source", sourceName)
+		sf.queueNodeEvent(pwNodeEvent{eventType: sessionsChanged})
+	}
+}
+
+// queueNodeEvent hands an event over to processNodeEvents without blocking
+func (sf *pwSessionFinder) queueNodeEvent(event pwNodeEvent) {
+	select {
+	case sf.nodeEvents <- event:
+	default:
+		select {
+		case sf.nodeEventsOverflown <- true:
+		default:
+		}
+	}
+}
+
+func (sf *pwSessionFinder) processNodeEvents() {
+	for {
+		select {
+		case event := <-sf.nodeEvents:
+			sf.handleNodeEvent(event)
+
+		case <-sf.nodeEventsOverflown:
+			sf.logger.Warn("Missed some PipeWire events, asking for all sessions to be re-acquired")
+			sf.notifySessionEvent(sessionEvent{Type: sessionsChanged})
+
+		case <-sf.conn.closed:
+			return
+		}
+	}
+}
+
+func (sf *pwSessionFinder) handleNodeEvent(event pwNodeEvent) {
+	switch event.eventType {
+	case sessionAdded:
+		session, ok := sf.newStreamSession(event.node)
+		if !ok {
+			return
+		}
+
+		sf.lock.Lock()
+		sf.streamSessions[event.node.globalID] = session
+		sf.lock.Unlock()
+
+		sf.notifySessionEvent(sessionEvent{Type: sessionAdded, Session: session})
+
+	case sessionRemoved:
+		sf.lock.Lock()
+		session, ok := sf.streamSessions[event.node.globalID]
+		delete(sf.streamSessions, event.node.globalID)
+		sf.lock.Unlock()
+
+		if ok {
+			sf.notifySessionEvent(sessionEvent{Type: sessionRemoved, Session: session})
+		}
+
+	case sessionsChanged:
+		sf.notifySessionEvent(sessionEvent{Type: sessionsChanged})
+	}
+}
+
+func (sf *pwSessionFinder) notifySessionEvent(event sessionEvent) {
+	sf.sessionEventConsumersLock.Lock()
+	consumers := sf.sessionEventConsumers
+	sf.sessionEventConsumersLock.Unlock()
+
+	for _, consumer := range consumers {
+		consumer <- event
+	}
+}
@@ -42,7 +42,8 @@ const (
 	deviceSessionFormat = "device.%s"
 )
 
-func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
+// the config's audio backend doesn't apply to windows, which only has the one
+func newSessionFinder(logger *zap.SugaredLogger, _ *CanonicalConfig) (SessionFinder, error) {
 	sf := &wcaSessionFinder{
 		logger:        logger.Named("session_finder"),
 		sessionLogger: logger.Named("sessions"),
@@ -3,6 +3,7 @@ package deej
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"go.uber.org/zap"
 
@@ -19,20 +20,32 @@ type paSession struct {
 
 	processName string
 
+	// the sink input's string properties (i.e. application.name, media.role), used by property targets
+	properties map[string]string
+
 	client *proto.Client
 
 	sinkInputIndex    uint32
 	sinkInputChannels byte
+
+	channelRatios     channelRatios
+	channelRatiosLock sync.Mutex
 }
 
 type masterSession struct {
 	baseSession
 
+	// set for sessions of a specific device rather than the default one, which can also be targeted by it
+	description string
+
 	client *proto.Client
 
 	streamIndex    uint32
 	streamChannels byte
 	isOutput       bool
+
+	channelRatios     channelRatios
+	channelRatiosLock sync.Mutex
 }
 
 func newPASession(
@@ -41,12 +54,14 @@ func newPASession(
 	sinkInputIndex uint32,
 	sinkInputChannels byte,
 	processName string,
+	properties map[string]string,
 ) *paSession {
 
 	s := &paSession{
 		client:            client,
 		sinkInputIndex:    sinkInputIndex,
 		sinkInputChannels: sinkInputChannels,
+		properties:        properties,
 	}
 
 	s.processName = processName
@@ -93,29 +108,64 @@ func newMasterSession(
 	return s
 }
 
-func (s *paSession) GetVolume() float32 {
-	request := proto.GetSinkInputInfo{
-		SinkInputIndex: s.sinkInputIndex,
+// newDeviceSession creates a session for a specific sink or source, which (unlike master and mic)
+// keeps controlling the same device regardless of which device is the default
+func newDeviceSession(
+	logger *zap.SugaredLogger,
+	client *proto.Client,
+	streamIndex uint32,
+	streamChannels byte,
+	isOutput bool,
+	deviceName string,
+	description string,
+) *masterSession {
+
+	s := &masterSession{
+		client:         client,
+		streamIndex:    streamIndex,
+		streamChannels: streamChannels,
+		isOutput:       isOutput,
+		description:    description,
 	}
-	reply := proto.GetSinkInputInfoReply{}
 
-	if err := s.client.Request(&request, &reply); err != nil {
+	prefix := sinkTargetPrefix
+	if !isOutput {
+		prefix = sourceTargetPrefix
+	}
+
+	s.logger = logger.Named(prefix + deviceName)
+	s.master = true
+	s.name = prefix + deviceName
+	s.humanReadableDesc = description
+
+	s.logger.Debugw(sessionCreationLogMessage, "session", s)
+
+	return s
+}
+
+func (s *paSession) GetVolume() float32 {
+	volumes, _, err := s.getChannels()
+	if err != nil {
 		s.logger.Warnw("Failed to get session volume", "error", err)
+		return 0
 	}
 
-	level := parseChannelVolumes(reply.ChannelVolumes)
+	level := parseChannelVolumes(volumes)
 
 	return level
 }
 
 func (s *paSession) SetVolume(v float32) error {
+
+	// keep the channels' balance by scaling their current volumes, if we can get them
 	volumes := createChannelVolumes(s.sinkInputChannels, v)
-	request := proto.SetSinkInputVolume{
-		SinkInputIndex: s.sinkInputIndex,
-		ChannelVolumes: volumes,
+	if currentVolumes, _, err := s.getChannels(); err == nil {
+		s.channelRatiosLock.Lock()
+		volumes = scaleChannelVolumes(&s.channelRatios, currentVolumes, v)
+		s.channelRatiosLock.Unlock()
 	}
 
-	if err := s.client.Request(&request, nil); err != nil {
+	if err := s.setChannels(volumes); err != nil {
 		s.logger.Warnw("Failed to set session volume", "error", err)
 		return fmt.Errorf("adjust session volume: %w", err)
 	}
@@ -125,6 +175,94 @@ func (s *paSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *paSession) GetMute() bool {
+	request := proto.GetSinkInputInfo{
+		SinkInputIndex: s.sinkInputIndex,
+	}
+	reply := proto.GetSinkInputInfoReply{}
+
+	if err := s.client.Request(&request, &reply); err != nil {
+		s.logger.Warnw("Failed to get session mute state", "error", err)
+		return false
+	}
+
+	return reply.Muted
+}
+
+func (s *paSession) SetMute(m bool) error {
+	request := proto.SetSinkInputMute{
+		SinkInputIndex: s.sinkInputIndex,
+		Mute:           m,
+	}
+
+	if err := s.client.Request(&request, nil); err != nil {
+		s.logger.Warnw("Failed to set session mute state", "error", err)
+		return fmt.Errorf("adjust session mute state: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session mute state", "to", m)
+
+	return nil
+}
+
+func (s *paSession) GetBalance() float32 {
+	volumes, channelMap, err := s.getChannels()
+	if err != nil {
+		s.logger.Warnw("Failed to get session balance", "error", err)
+		return centerBalance
+	}
+
+	return channelBalance(paChannelLevels(volumes), paChannelSides(channelMap))
+}
+
+func (s *paSession) SetBalance(b float32) error {
+	volumes, channelMap, err := s.getChannels()
+	if err == nil {
+		err = s.setChannels(paChannelVolumes(balanceChannelLevels(paChannelLevels(volumes), paChannelSides(channelMap), b)))
+	}
+
+	if err != nil {
+		s.logger.Warnw("Failed to set session balance", "error", err)
+		return fmt.Errorf("adjust session balance: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session balance", "to", fmt.Sprintf("%.2f", b))
+
+	return nil
+}
+
+// getChannels returns the sink input's current channel volumes, along with which channel is which
+func (s *paSession) getChannels() (proto.ChannelVolumes, proto.ChannelMap, error) {
+	request := proto.GetSinkInputInfo{
+		SinkInputIndex: s.sinkInputIndex,
+	}
+	reply := proto.GetSinkInputInfoReply{}
+
+	if err := s.client.Request(&request, &reply); err != nil {
+		return nil, nil, err
+	}
+
+	return reply.ChannelVolumes, reply.ChannelMap, nil
+}
+
+func (s *paSession) setChannels(volumes proto.ChannelVolumes) error {
+	request := proto.SetSinkInputVolume{
+		SinkInputIndex: s.sinkInputIndex,
+		ChannelVolumes: volumes,
+	}
+
+	return s.client.Request(&request, nil)
+}
+
+func (s *paSession) Property(name string) (string, bool) {
+	value, ok := s.properties[name]
+	return value, ok
+}
+
+func (s *paSession) streamID() uint32 {
+	return s.sinkInputIndex
+}
+
 func (s *paSession) Release() {
 	s.logger.Debug("Releasing audio session")
 }
@@ -134,8 +272,41 @@ func (s *paSession) String() string {
 }
 
 func (s *masterSession) GetVolume() float32 {
-	var level float32
+	volumes, _, err := s.getChannels()
+	if err != nil {
+		s.logger.Warnw("Failed to get session volume", "error", err)
+		return 0
+	}
+
+	level := parseChannelVolumes(volumes)
+
+	return level
+}
+
+func (s *masterSession) SetVolume(v float32) error {
 
+	// keep the channels' balance by scaling their current volumes, if we can get them
+	volumes := createChannelVolumes(s.streamChannels, v)
+	if currentVolumes, _, err := s.getChannels(); err == nil {
+		s.channelRatiosLock.Lock()
+		volumes = scaleChannelVolumes(&s.channelRatios, currentVolumes, v)
+		s.channelRatiosLock.Unlock()
+	}
+
+	if err := s.setChannels(volumes); err != nil {
+		s.logger.Warnw("Failed to set session volume",
+			"error", err,
+			"volume", v)
+
+		return fmt.Errorf("adjust session volume: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session volume", "to", fmt.Sprintf("%.2f", v))
+
+	return nil
+}
+
+func (s *masterSession) GetMute() bool {
 	if s.isOutput {
 		request := proto.GetSinkInfo{
 			SinkIndex: s.streamIndex,
@@ -143,33 +314,113 @@ func (s *masterSession) GetVolume() float32 {
 		reply := proto.GetSinkInfoReply{}
 
 		if err := s.client.Request(&request, &reply); err != nil {
-			s.logger.Warnw("Failed to get session volume", "error", err)
-			return 0
+			s.logger.Warnw("Failed to get session mute state", "error", err)
+			return false
 		}
 
-		level = parseChannelVolumes(reply.ChannelVolumes)
+		return reply.Mute
+	}
+
+	request := proto.GetSourceInfo{
+		SourceIndex: s.streamIndex,
+	}
+	reply := proto.GetSourceInfoReply{}
+
+	if err := s.client.Request(&request, &reply); err != nil {
+		s.logger.Warnw("Failed to get session mute state", "error", err)
+		return false
+	}
+
+	return reply.Mute
+}
+
+func (s *masterSession) SetMute(m bool) error {
+	var request proto.RequestArgs
+
+	if s.isOutput {
+		request = &proto.SetSinkMute{
+			SinkIndex: s.streamIndex,
+			Mute:      m,
+		}
 	} else {
-		request := proto.GetSourceInfo{
+		request = &proto.SetSourceMute{
 			SourceIndex: s.streamIndex,
+			Mute:        m,
+		}
+	}
+
+	if err := s.client.Request(request, nil); err != nil {
+		s.logger.Warnw("Failed to set session mute state",
+			"error", err,
+			"mute", m)
+
+		return fmt.Errorf("adjust session mute state: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session mute state", "to", m)
+
+	return nil
+}
+
+func (s *masterSession) GetBalance() float32 {
+	volumes, channelMap, err := s.getChannels()
+	if err != nil {
+		s.logger.Warnw("Failed to get session balance", "error", err)
+		return centerBalance
+	}
+
+	return channelBalance(paChannelLevels(volumes), paChannelSides(channelMap))
+}
+
+func (s *masterSession) SetBalance(b float32) error {
+	volumes, channelMap, err := s.getChannels()
+	if err == nil {
+		err = s.setChannels(paChannelVolumes(balanceChannelLevels(paChannelLevels(volumes), paChannelSides(channelMap), b)))
+	}
+
+	if err != nil {
+		s.logger.Warnw("Failed to set session balance",
+			"error", err,
+			"balance", b)
+
+		return fmt.Errorf("adjust session balance: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session balance", "to", fmt.Sprintf("%.2f", b))
+
+	return nil
+}
+
+// getChannels returns the sink's (or source's) current channel volumes, along with which channel is which
+func (s *masterSession) getChannels() (proto.ChannelVolumes, proto.ChannelMap, error) {
+	if s.isOutput {
+		request := proto.GetSinkInfo{
+			SinkIndex: s.streamIndex,
 		}
-		reply := proto.GetSourceInfoReply{}
+		reply := proto.GetSinkInfoReply{}
 
 		if err := s.client.Request(&request, &reply); err != nil {
-			s.logger.Warnw("Failed to get session volume", "error", err)
-			return 0
+			return nil, nil, err
 		}
 
-		level = parseChannelVolumes(reply.ChannelVolumes)
+		return reply.ChannelVolumes, reply.ChannelMap, nil
 	}
 
-	return level
+	request := proto.GetSourceInfo{
+		SourceIndex: s.streamIndex,
+	}
+	reply := proto.GetSourceInfoReply{}
+
+	if err := s.client.Request(&request, &reply); err != nil {
+		return nil, nil, err
+	}
+
+	return reply.ChannelVolumes, reply.ChannelMap, nil
 }
 
-func (s *masterSession) SetVolume(v float32) error {
+func (s *masterSession) setChannels(volumes proto.ChannelVolumes) error {
 	var request proto.RequestArgs
 
-	volumes := createChannelVolumes(s.streamChannels, v)
-
 	if s.isOutput {
 		request = &proto.SetSinkVolume{
 			SinkIndex:      s.streamIndex,
@@ -182,17 +433,11 @@ func (s *masterSession) SetVolume(v float32) error {
 		}
 	}
 
-	if err := s.client.Request(request, nil); err != nil {
-		s.logger.Warnw("Failed to set session volume",
-			"error", err,
-			"volume", v)
-
-		return fmt.Errorf("adjust session volume: %w", err)
-	}
-
-	s.logger.Debugw("Adjusting session volume", "to", fmt.Sprintf("%.2f", v))
+	return s.client.Request(request, nil)
+}
 
-	return nil
+func (s *masterSession) Description() string {
+	return s.description
 }
 
 func (s *masterSession) Release() {
@@ -213,12 +458,52 @@ func createChannelVolumes(channels byte, volume float32) []uint32 {
 	return volumes
 }
 
+// parseChannelVolumes returns the volume of the loudest channel, which is what PulseAudio itself
+// considers a stream's volume (and what scaleChannelVolumes sets it to)
 func parseChannelVolumes(volumes []uint32) float32 {
-	var level uint32
+	return float32(maxChannelLevel(paChannelLevels(volumes)))
+}
+
+// scaleChannelVolumes sets the given channel volumes to a new volume, keeping the balance the given ratios have
+func scaleChannelVolumes(ratios *channelRatios, volumes []uint32, volume float32) []uint32 {
+	return paChannelVolumes(ratios.scale(paChannelLevels(volumes), float64(volume)))
+}
+
+func paChannelLevels(volumes []uint32) []float64 {
+	levels := make([]float64, len(volumes))
+
+	for idx, volume := range volumes {
+		levels[idx] = float64(volume) / maxVolume
+	}
+
+	return levels
+}
+
+func paChannelVolumes(levels []float64) []uint32 {
+	volumes := make([]uint32, len(levels))
+
+	for idx, level := range levels {
+		volumes[idx] = uint32(level * maxVolume)
+	}
 
-	for _, volume := range volumes {
-		level += volume
+	return volumes
+}
+
+// paChannelSides tells which of a PulseAudio channel map's channels are on the left and which are on the right
+func paChannelSides(channelMap proto.ChannelMap) []channelSide {
+	sides := make([]channelSide, len(channelMap))
+
+	for idx, position := range channelMap {
+		switch position {
+		case proto.ChannelFrontLeft, proto.ChannelRearLeft, proto.ChannelLeftCenter, proto.ChannelLeftSide,
+			proto.ChannelTopFrontLeft, proto.ChannelTopRearLeft:
+			sides[idx] = channelSideLeft
+
+		case proto.ChannelFrontRight, proto.ChannelRearRight, proto.ChannelRightCenter, proto.ChannelRightSide,
+			proto.ChannelTopFrontRight, proto.ChannelTopRearRight:
+			sides[idx] = channelSideRight
+		}
 	}
 
-	return float32(level) / float32(len(volumes)) / float32(maxVolume)
+	return sides
 }
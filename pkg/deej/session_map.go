@@ -2,6 +2,7 @@ package deej
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strings"
 	"sync"
@@ -16,13 +17,33 @@ type sessionMap struct {
 	deej   *Deej
 	logger *zap.SugaredLogger
 
+	// lock guards the map itself along with lastSessionRefresh and unmappedSessions
 	m    map[string][]Session
 	lock sync.Locker
 
+	// refreshes and sessions added or removed by the session finder are handled one at a time,
+	// so that a refresh can't clear the map from under an added session (or register it a second time)
+	refreshLock sync.Locker
+
 	sessionFinder SessionFinder
 
 	lastSessionRefresh time.Time
 	unmappedSessions   []Session
+
+	// true if the session finder tells us when sessions come and go, in which case we don't need to go looking for them
+	eventDriven bool
+
+	// the volume each slider last moved to (after its curve), applied to sessions as they appear. these are based on
+	// the same values SerialIO keeps in currentSliderPercentValues, recorded as they reach us rather than shared across goroutines
+	sliderValues     map[int]float32
+	sliderValuesLock sync.Mutex
+
+	// where each slider last was and when it got there, and the positions that sliders disengaged
+	// by a volume change made elsewhere have to reach before they take over again (see volume_watch.go)
+	sliderPositions   map[int]float32
+	sliderMoveTimes   map[int]time.Time
+	takeoverPositions map[int]float32
+	takeoverLock      sync.Mutex
 }
 
 const (
@@ -40,6 +61,19 @@ const (
 	// targets all currently unmapped sessions (experimental)
 	specialTargetAllUnmapped = "unmapped"
 
+	// targets sessions by one of their properties rather than their process name, e.g. "prop:media.role=game".
+	// only sessions that carry properties can match these (Linux-only)
+	propertyTargetPrefix = "prop:"
+
+	// target a specific output or input device by its name or description, e.g. "sink:alsa_output.usb-headset"
+	// or "source:USB Headset Mono" (Linux-only, Windows devices are targeted by their friendly name instead)
+	sinkTargetPrefix   = "sink:"
+	sourceTargetPrefix = "source:"
+
+	// makes a slider control the left/right balance of another target's sessions rather than their volume,
+	// e.g. "balance:master" (only sessions that support balance are affected, currently on Linux)
+	balanceTargetPrefix = "balance:"
+
 	// this threshold constant assumes that re-acquiring all sessions is a kind of expensive operation,
 	// and needs to be limited in some manner. this value was previously user-configurable through a config
 	// key "process_refresh_frequency", but exposing this type of implementation detail seems wrong now
@@ -49,23 +83,28 @@ const (
 	// this is a bit greedy but allows us to ensure sessions are always re-acquired, which is
 	// especially important for process groups (because you can have one ongoing session
 	// always preventing lookup of other processes bound to its slider, which forces the user
-	// to manually refresh sessions). a cleaner way to do this down the line is by registering to notifications
-	// whenever a new session is added, but that's too hard to justify for how easy this solution is
+	// to manually refresh sessions). session finders that notify us whenever a session is added (see sessionEventSource)
+	// make this unnecessary, so it only applies to those that don't
 	maxTimeBetweenSessionRefreshes = time.Second * 45
 )
 
 // this matches friendly device names (on Windows), e.g. "Headphones (Realtek Audio)"
 var deviceSessionKeyPattern = regexp.MustCompile(`^.+ \(.+\)$`)
 
-func newSessionMap(deej *Deej, logger *zap.SugaredLogger, sessionFinder SessionFinder) (*sessionMap, error) {
+func newSessionMap(deej *Deej, logger *zap.SugaredLogger) (*sessionMap, error) {
 	logger = logger.Named("sessions")
 
 	m := &sessionMap{
-		deej:          deej,
-		logger:        logger,
-		m:             make(map[string][]Session),
-		lock:          &sync.Mutex{},
-		sessionFinder: sessionFinder,
+		deej:         deej,
+		logger:       logger,
+		m:            make(map[string][]Session),
+		lock:         &sync.Mutex{},
+		refreshLock:  &sync.Mutex{},
+		sliderValues: map[int]float32{},
+
+		sliderPositions:   map[int]float32{},
+		sliderMoveTimes:   map[int]time.Time{},
+		takeoverPositions: map[int]float32{},
 	}
 
 	logger.Debug("Created session map instance")
@@ -73,14 +112,22 @@ func newSessionMap(deej *Deej, logger *zap.SugaredLogger, sessionFinder SessionF
 	return m, nil
 }
 
-func (m *sessionMap) initialize() error {
-	if err := m.getAndAddSessions(); err != nil {
+func (m *sessionMap) initialize(sessionFinder SessionFinder) error {
+	m.sessionFinder = sessionFinder
+
+	if err := m.getAndAddSessions(nil); err != nil {
 		m.logger.Warnw("Failed to get all sessions during session map initialization", "error", err)
 		return fmt.Errorf("get all sessions during init: %w", err)
 	}
 
 	m.setupOnConfigReload()
 	m.setupOnSliderMove()
+	m.setupOnEncoderMove()
+	m.setupVolumeWatch()
+
+	if eventSource, ok := m.sessionFinder.(sessionEventSource); ok {
+		m.setupOnSessionEvents(eventSource)
+	}
 
 	return nil
 }
@@ -95,12 +142,16 @@ func (m *sessionMap) release() error {
 }
 
 // assumes the session map is clean!
-// only call on a new session map or as part of refreshSessions which calls reset
-func (m *sessionMap) getAndAddSessions() error {
+// only call on a new session map or as part of refreshSessions which calls reset.
+// previousSessions holds how many sessions there were of each identity before (see sessionIdentities),
+// so that new ones can be told apart
+func (m *sessionMap) getAndAddSessions(previousSessions map[string]int) error {
 
 	// mark that we're refreshing before anything else
+	m.lock.Lock()
 	m.lastSessionRefresh = time.Now()
 	m.unmappedSessions = nil
+	m.lock.Unlock()
 
 	sessions, err := m.sessionFinder.GetAllSessions()
 	if err != nil {
@@ -108,20 +159,48 @@ func (m *sessionMap) getAndAddSessions() error {
 		return fmt.Errorf("get sessions from SessionFinder: %w", err)
 	}
 
+	newSessions := []Session{}
+
 	for _, session := range sessions {
-		m.add(session)
+		if !m.add(session, !m.sessionMapped(session)) {
+			continue
+		}
 
-		if !m.sessionMapped(session) {
-			m.logger.Debugw("Tracking unmapped session", "session", session)
-			m.unmappedSessions = append(m.unmappedSessions, session)
+		// sessions are re-created on every refresh, so they're told apart by what they belong to rather than
+		// by which object they are. only those that weren't around before get their slider's value, since the rest
+		// may have been changed in the meantime (i.e. from the app itself, while soft takeover is on)
+		identity := sessionIdentity(session)
+		if previousSessions[identity] > 0 {
+			previousSessions[identity]--
+		} else {
+			newSessions = append(newSessions, session)
 		}
 	}
 
 	m.logger.Infow("Got all audio sessions successfully", "sessionMap", m)
 
+	for _, session := range newSessions {
+		m.applySliderValue(session)
+	}
+
 	return nil
 }
 
+// sessionIdentities returns how many sessions there are of each identity (see sessionIdentity)
+func (m *sessionMap) sessionIdentities() map[string]int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	identities := map[string]int{}
+	for _, sessions := range m.m {
+		for _, session := range sessions {
+			identities[sessionIdentity(session)]++
+		}
+	}
+
+	return identities
+}
+
 func (m *sessionMap) setupOnConfigReload() {
 	configReloadedChannel := m.deej.config.SubscribeToChanges()
 
@@ -131,6 +210,9 @@ func (m *sessionMap) setupOnConfigReload() {
 			case <-configReloadedChannel:
 				m.logger.Info("Detected config reload, attempting to re-acquire all audio sessions")
 				m.refreshSessions(false)
+
+				// motorized faders follow whatever they're now mapped to, rather than the other way around
+				m.moveFaders()
 			}
 		}
 	}()
@@ -149,18 +231,141 @@ func (m *sessionMap) setupOnSliderMove() {
 	}()
 }
 
+func (m *sessionMap) setupOnEncoderMove() {
+	encoderEventsChannel := m.deej.serial.SubscribeToEncoderMoveEvents()
+
+	go func() {
+		for {
+			select {
+			case event := <-encoderEventsChannel:
+				m.handleEncoderMoveEvent(event)
+			}
+		}
+	}()
+}
+
+func (m *sessionMap) setupOnSessionEvents(eventSource sessionEventSource) {
+	sessionEventsChannel := eventSource.SubscribeToSessionEvents()
+	m.eventDriven = true
+
+	m.logger.Debug("Session finder supports session events, no longer polling for new sessions")
+
+	go func() {
+		for {
+			select {
+			case event := <-sessionEventsChannel:
+				m.handleSessionEvent(event)
+			}
+		}
+	}()
+}
+
+func (m *sessionMap) handleSessionEvent(event sessionEvent) {
+	switch event.Type {
+	case sessionAdded:
+		m.refreshLock.Lock()
+		defer m.refreshLock.Unlock()
+
+		// a refresh that ran after the session appeared already has it
+		m.logger.Debugw("Adding new session", "session", event.Session)
+		if !m.add(event.Session, !m.sessionMapped(event.Session)) {
+			return
+		}
+
+		// otherwise, new apps would play at whatever volume they like until their slider moves
+		m.applySliderValue(event.Session)
+
+	case sessionRemoved:
+		m.refreshLock.Lock()
+		defer m.refreshLock.Unlock()
+
+		m.logger.Debugw("Removing session", "session", event.Session.Key())
+		m.remove(event.Session)
+
+	case sessionsChanged:
+
+		// performance: forcing is fine here, as these only come in when devices are added or removed
+		m.logger.Debug("Audio devices changed, re-acquiring all audio sessions")
+		m.refreshSessions(true)
+	}
+}
+
+// applySliderValue sets the given session's volume to the last value of the slider it's mapped to, if that's known
+func (m *sessionMap) applySliderValue(session Session) {
+	sliderID, ok := m.findSessionSlider(session)
+	if !ok {
+		return
+	}
+
+	m.sliderValuesLock.Lock()
+	value, ok := m.sliderValues[sliderID]
+	m.sliderValuesLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	// don't bother the audio server if there's nothing to change
+	if session.GetVolume() == value {
+		return
+	}
+
+	m.logger.Debugw("Applying slider value to new session", "session", session.Key(), "sliderID", sliderID, "value", value)
+
+	if err := session.SetVolume(value); err != nil {
+		m.logger.Warnw("Failed to apply slider value to new session", "error", err)
+	}
+}
+
+// findSessionSlider returns the lowest-numbered slider the given session is mapped to, either directly
+// or through deej.unmapped. sessions that are only mapped through deej.current aren't considered, as that changes constantly
+func (m *sessionMap) findSessionSlider(session Session) (int, bool) {
+	unmapped := !m.sessionMapped(session)
+
+	foundSliderID := -1
+
+	m.deej.config.sliderMapping().iterate(func(sliderIdx int, targets []string) {
+		if foundSliderID != -1 && foundSliderID < sliderIdx {
+			return
+		}
+
+		for _, target := range targets {
+			target = strings.ToLower(target)
+
+			if m.targetHasSpecialTransform(target) {
+				if unmapped && target == specialTargetTransformPrefix+specialTargetAllUnmapped {
+					foundSliderID = sliderIdx
+					return
+				}
+
+				continue
+			}
+
+			if m.targetMatchesSession(m.resolveTarget(target)[0], session) {
+				foundSliderID = sliderIdx
+				return
+			}
+		}
+	})
+
+	return foundSliderID, foundSliderID != -1
+}
+
 // performance: explain why force == true at every such use to avoid unintended forced refresh spams
 func (m *sessionMap) refreshSessions(force bool) {
+	m.refreshLock.Lock()
+	defer m.refreshLock.Unlock()
 
 	// make sure enough time passed since the last refresh, unless force is true in which case always clear
-	if !force && m.lastSessionRefresh.Add(minTimeBetweenSessionRefreshes).After(time.Now()) {
+	if !force && m.lastRefresh().Add(minTimeBetweenSessionRefreshes).After(time.Now()) {
 		return
 	}
 
-	// clear and release sessions first
+	// clear and release sessions first, remembering what we had so that new sessions can get their slider's value
+	previousSessions := m.sessionIdentities()
 	m.clear()
 
-	if err := m.getAndAddSessions(); err != nil {
+	if err := m.getAndAddSessions(previousSessions); err != nil {
 		m.logger.Warnw("Failed to re-acquire all audio sessions", "error", err)
 	} else {
 		m.logger.Debug("Re-acquired sessions successfully")
@@ -178,14 +383,14 @@ func (m *sessionMap) sessionMapped(session Session) bool {
 	}
 
 	// count device sessions as mapped
-	if deviceSessionKeyPattern.MatchString(session.Key()) {
+	if _, ok := session.(deviceSession); ok || deviceSessionKeyPattern.MatchString(session.Key()) {
 		return true
 	}
 
 	matchFound := false
 
 	// look through the actual mappings
-	m.deej.config.SliderMapping.iterate(func(sliderIdx int, targets []string) {
+	m.deej.config.sliderMapping().iterate(func(sliderIdx int, targets []string) {
 		for _, target := range targets {
 
 			// ignore special transforms
@@ -194,9 +399,7 @@ func (m *sessionMap) sessionMapped(session Session) bool {
 			}
 
 			// safe to assume this has a single element because we made sure there's no special transform
-			target = m.resolveTarget(target)[0]
-
-			if target == session.Key() {
+			if m.targetMatchesSession(m.resolveTarget(target)[0], session) {
 				matchFound = true
 				return
 			}
@@ -208,23 +411,233 @@ func (m *sessionMap) sessionMapped(session Session) bool {
 
 func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 
-	// first of all, ensure our session map isn't moldy
-	if m.lastSessionRefresh.Add(maxTimeBetweenSessionRefreshes).Before(time.Now()) {
+	// a slider that's out of sync with a volume set elsewhere might not get a say yet
+	if !m.sliderEngaged(event) {
+		return
+	}
+
+	// the slider's position only becomes a volume through its curve
+	volume := m.deej.config.sliderCurve(event.SliderID).apply(event.PercentValue)
+
+	m.sliderValuesLock.Lock()
+	m.sliderValues[event.SliderID] = volume
+	m.sliderValuesLock.Unlock()
+
+	// first of all, ensure our session map isn't moldy (unless we're told about every change anyway)
+	if !m.eventDriven && m.lastRefresh().Add(maxTimeBetweenSessionRefreshes).Before(time.Now()) {
 		m.logger.Debug("Stale session map detected on slider move, refreshing")
 		m.refreshSessions(true)
 	}
 
-	// get the targets mapped to this slider from the config
-	targets, ok := m.deej.config.SliderMapping.get(event.SliderID)
+	// get all sessions currently bound to this slider
+	sessions, ok := m.getSliderSessions(event.SliderID)
+
+	// if slider not found in config, silently ignore
+	if !ok {
+		return
+	}
+
+	balanceSessions := m.getSliderBalanceSessions(event.SliderID)
+
+	targetFound := len(sessions) > 0 || len(balanceSessions) > 0
+	adjustmentFailed := false
+
+	// iterate all matching sessions and adjust the volume of each one
+	for _, session := range sessions {
+		if session.GetVolume() != volume {
+			if err := session.SetVolume(volume); err != nil {
+				m.logger.Warnw("Failed to set target session volume", "error", err)
+				adjustmentFailed = true
+			}
+		}
+	}
+
+	// and the balance of those bound through balance targets, with the slider's middle being centered
+	// (curves are meant for volumes, so balance follows the slider's position as-is)
+	for _, session := range balanceSessions {
+		if session.GetBalance() != event.PercentValue {
+			if err := session.SetBalance(event.PercentValue); err != nil {
+				m.logger.Warnw("Failed to set target session balance", "error", err)
+				adjustmentFailed = true
+			}
+		}
+	}
+
+	// if we still haven't found a target or the volume adjustment failed, maybe look for the target again.
+	// processes could've opened since the last time this slider moved (though if we're event-driven, we'd know).
+	// if they haven't, the cooldown will take care to not spam it up
+	if !targetFound && !m.eventDriven {
+		m.refreshSessions(false)
+	} else if adjustmentFailed {
+
+		// performance: the reason that forcing a refresh here is okay is that we'll only get here
+		// when a session's SetVolume call errored, such as in the case of a stale master session
+		// (or another, more catastrophic failure happens)
+		m.refreshSessions(true)
+	}
+}
+
+func (m *sessionMap) handleEncoderMoveEvent(event EncoderMoveEvent) {
+
+	// same as with sliders, ensure our session map isn't moldy
+	if !m.eventDriven && m.lastRefresh().Add(maxTimeBetweenSessionRefreshes).Before(time.Now()) {
+		m.logger.Debug("Stale session map detected on encoder move, refreshing")
+		m.refreshSessions(true)
+	}
+
+	// encoders share their indexes with sliders, so they're bound through the slider mapping
+	sessions, ok := m.getSliderSessions(event.EncoderID)
+
+	// if encoder not found in config, silently ignore
+	if !ok {
+		return
+	}
+
+	// encoders are relative, so the step is applied to each session's own volume. the acceleration
+	// makes bigger deltas (that is, faster turns between two lines) cover disproportionately more ground
+	step, acceleration := m.deej.config.encoderConfig()
+	magnitude := math.Pow(float64(absInt(event.Delta)), acceleration)
+	change := step * magnitude
+
+	if event.Delta < 0 {
+		change = -change
+	}
+
+	adjustmentFailed := false
+
+	// encoders have no position for a curve to apply to, but they do stay within its limits
+	curve := m.deej.config.sliderCurve(event.EncoderID)
+
+	for _, session := range sessions {
+		currentVolume := float64(session.GetVolume())
+
+		// round (rather than trim) to 2 points of precision, otherwise repeated steps would drift downwards
+		newVolume := math.Round(curve.clamp(currentVolume+change)*100) / 100
+
+		if newVolume != currentVolume {
+			if err := session.SetVolume(float32(newVolume)); err != nil {
+				m.logger.Warnw("Failed to set target session volume", "error", err)
+				adjustmentFailed = true
+			}
+		}
+	}
+
+	// balance targets are stepped the same way, with turning right moving the balance to the right
+	balanceSessions := m.getSliderBalanceSessions(event.EncoderID)
+
+	for _, session := range balanceSessions {
+		currentBalance := float64(session.GetBalance())
+		newBalance := math.Round(math.Max(0, math.Min(1, currentBalance+change))*100) / 100
+
+		if newBalance != currentBalance {
+			if err := session.SetBalance(float32(newBalance)); err != nil {
+				m.logger.Warnw("Failed to set target session balance", "error", err)
+				adjustmentFailed = true
+			}
+		}
+	}
+
+	// same logic as with slider moves - look for the target again if we didn't find it, or force a refresh on failure
+	if len(sessions) == 0 && len(balanceSessions) == 0 && !m.eventDriven {
+		m.refreshSessions(false)
+	} else if adjustmentFailed {
+		m.refreshSessions(true)
+	}
+}
+
+// toggleMute flips the mute state of every session bound to the given slider. if any of these
+// sessions is currently unmuted, all of them get muted - otherwise, all of them get unmuted
+func (m *sessionMap) toggleMute(sliderID int) {
+	sessions, ok := m.getSliderSessions(sliderID)
 
 	// if slider not found in config, silently ignore
 	if !ok {
 		return
 	}
 
-	targetFound := false
+	// same as with slider moves, processes could've opened since we last looked for them
+	if len(sessions) == 0 && !m.eventDriven {
+		m.refreshSessions(false)
+		sessions, _ = m.getSliderSessions(sliderID)
+	}
+
+	mute := false
+	for _, session := range sessions {
+		if !session.GetMute() {
+			mute = true
+			break
+		}
+	}
+
 	adjustmentFailed := false
 
+	for _, session := range sessions {
+		if err := session.SetMute(mute); err != nil {
+			m.logger.Warnw("Failed to set target session mute state", "error", err)
+			adjustmentFailed = true
+		}
+	}
+
+	m.logger.Debugw("Toggled slider mute state", "sliderID", sliderID, "mute", mute, "sessions", len(sessions))
+
+	// performance: same as in handleSliderMoveEvent, we'll only get here when a call to SetMute errored
+	if adjustmentFailed {
+		m.refreshSessions(true)
+	}
+}
+
+// getSliderSessions resolves every target mapped to the given slider and returns all matching sessions
+// (except for those bound through balance targets, see getSliderBalanceSessions).
+// the second return value is false if the slider isn't mapped in the config at all
+func (m *sessionMap) getSliderSessions(sliderID int) ([]Session, bool) {
+
+	// get the targets mapped to this slider from the config
+	targets, ok := m.deej.config.sliderMapping().get(sliderID)
+	if !ok {
+		return nil, false
+	}
+
+	volumeTargets := []string{}
+	for _, target := range targets {
+		if !isBalanceTarget(target) {
+			volumeTargets = append(volumeTargets, target)
+		}
+	}
+
+	return m.getTargetSessions(volumeTargets), true
+}
+
+// getSliderBalanceSessions returns the sessions whose balance is bound to the given slider through balance targets.
+// sessions that don't support balance are left out
+func (m *sessionMap) getSliderBalanceSessions(sliderID int) []balanceSession {
+	targets, _ := m.deej.config.sliderMapping().get(sliderID)
+
+	balanceTargets := []string{}
+	for _, target := range targets {
+		if isBalanceTarget(target) {
+			balanceTargets = append(balanceTargets, strings.TrimPrefix(strings.ToLower(target), balanceTargetPrefix))
+		}
+	}
+
+	result := []balanceSession{}
+
+	for _, session := range m.getTargetSessions(balanceTargets) {
+		if balanceSession, ok := session.(balanceSession); ok {
+			result = append(result, balanceSession)
+		}
+	}
+
+	return result
+}
+
+func isBalanceTarget(target string) bool {
+	return strings.HasPrefix(strings.ToLower(target), balanceTargetPrefix)
+}
+
+// getTargetSessions resolves the given targets and returns all matching sessions
+func (m *sessionMap) getTargetSessions(targets []string) []Session {
+	result := []Session{}
+
 	// for each possible target for this slider...
 	for _, target := range targets {
 
@@ -232,10 +645,15 @@ func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 		// depending on the transformation applied, this can result in more than one target name
 		resolvedTargets := m.resolveTarget(target)
 
-		// for each resolved target...
+		// for each resolved target, check the map for matching sessions
 		for _, resolvedTarget := range resolvedTargets {
 
-			// check the map for matching sessions
+			// property and device targets can't (always) be looked up by key, so they're matched against every session instead
+			if targetNeedsMatching(resolvedTarget) {
+				result = append(result, m.getMatching(resolvedTarget)...)
+				continue
+			}
+
 			sessions, ok := m.get(resolvedTarget)
 
 			// no sessions matching this target - move on
@@ -243,32 +661,69 @@ func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 				continue
 			}
 
-			targetFound = true
+			result = append(result, sessions...)
+		}
+	}
 
-			// iterate all matching sessions and adjust the volume of each one
-			for _, session := range sessions {
-				if session.GetVolume() != event.PercentValue {
-					if err := session.SetVolume(event.PercentValue); err != nil {
-						m.logger.Warnw("Failed to set target session volume", "error", err)
-						adjustmentFailed = true
-					}
-				}
+	return result
+}
+
+// targetNeedsMatching returns true if sessions for the given (resolved) target can't be found just by their key
+func targetNeedsMatching(target string) bool {
+	for _, prefix := range []string{propertyTargetPrefix, sinkTargetPrefix, sourceTargetPrefix} {
+		if strings.HasPrefix(target, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// targetMatchesSession returns true if the given (resolved) target refers to the given session
+func (m *sessionMap) targetMatchesSession(target string, session Session) bool {
+	if target == session.Key() {
+		return true
+	}
+
+	// device targets can use the device's description instead of its name
+	if device, ok := session.(deviceSession); ok {
+		for _, prefix := range []string{sinkTargetPrefix, sourceTargetPrefix} {
+			if strings.HasPrefix(session.Key(), prefix) && target == prefix+strings.ToLower(device.Description()) {
+				return true
 			}
 		}
+
+		return false
 	}
 
-	// if we still haven't found a target or the volume adjustment failed, maybe look for the target again.
-	// processes could've opened since the last time this slider moved.
-	// if they haven't, the cooldown will take care to not spam it up
-	if !targetFound {
-		m.refreshSessions(false)
-	} else if adjustmentFailed {
+	if !strings.HasPrefix(target, propertyTargetPrefix) {
+		return false
+	}
 
-		// performance: the reason that forcing a refresh here is okay is that we'll only get here
-		// when a session's SetVolume call errored, such as in the case of a stale master session
-		// (or another, more catastrophic failure happens)
-		m.refreshSessions(true)
+	name, value, ok := parsePropertyTarget(target)
+	if !ok {
+		return false
+	}
+
+	propertySession, ok := session.(propertySession)
+	if !ok {
+		return false
 	}
+
+	// targets are lowercase by the time they get here, so property values are compared regardless of case
+	sessionValue, ok := propertySession.Property(name)
+
+	return ok && strings.ToLower(sessionValue) == value
+}
+
+// parsePropertyTarget splits a property target into the property's name and its expected value
+func parsePropertyTarget(target string) (string, string, bool) {
+	nameValue := strings.SplitN(strings.TrimPrefix(target, propertyTargetPrefix), "=", 2)
+	if len(nameValue) != 2 || nameValue[0] == "" {
+		return "", "", false
+	}
+
+	return strings.TrimSpace(nameValue[0]), strings.TrimSpace(nameValue[1]), true
 }
 
 func (m *sessionMap) targetHasSpecialTransform(target string) bool {
@@ -297,7 +752,8 @@ func (m *sessionMap) applyTargetTransform(specialTargetName string) []string {
 	case specialTargetCurrentWindow:
 		currentWindowProcessNames, err := util.GetCurrentWindowProcessNames()
 
-		// silently ignore errors here, as this is on deej's "hot path" (and it could just mean the user's running linux)
+		// silently ignore errors here, as this is on deej's "hot path" (and it could just mean there's no supported
+		// window system, i.e. an unsupported wayland compositor on linux)
 		if err != nil {
 			return nil
 		}
@@ -312,6 +768,9 @@ func (m *sessionMap) applyTargetTransform(specialTargetName string) []string {
 
 	// get currently unmapped sessions
 	case specialTargetAllUnmapped:
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
 		targetKeys := make([]string, len(m.unmappedSessions))
 		for sessionIdx, session := range m.unmappedSessions {
 			targetKeys[sessionIdx] = session.Key()
@@ -323,7 +782,9 @@ func (m *sessionMap) applyTargetTransform(specialTargetName string) []string {
 	return nil
 }
 
-func (m *sessionMap) add(value Session) {
+// add tracks the given session, and also as an unmapped one if it isn't mapped to any slider.
+// returns false if the map already has the session, in which case it's released and left out
+func (m *sessionMap) add(value Session, unmapped bool) bool {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -333,8 +794,32 @@ func (m *sessionMap) add(value Session) {
 	if !ok {
 		m.m[key] = []Session{value}
 	} else {
+		for _, session := range existing {
+			if sameSession(session, value) {
+				m.logger.Debugw("Session already tracked, ignoring", "session", value)
+				value.Release()
+
+				return false
+			}
+		}
+
 		m.m[key] = append(existing, value)
 	}
+
+	if unmapped {
+		m.logger.Debugw("Tracking unmapped session", "session", value)
+		m.unmappedSessions = append(m.unmappedSessions, value)
+	}
+
+	return true
+}
+
+// lastRefresh returns when all sessions were last re-acquired
+func (m *sessionMap) lastRefresh() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.lastSessionRefresh
 }
 
 func (m *sessionMap) get(key string) ([]Session, bool) {
@@ -345,6 +830,41 @@ func (m *sessionMap) get(key string) ([]Session, bool) {
 	return value, ok
 }
 
+// getMatching returns every session the given (resolved) target refers to
+func (m *sessionMap) getMatching(target string) []Session {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	result := []Session{}
+
+	for _, sessions := range m.m {
+		for _, session := range sessions {
+			if m.targetMatchesSession(target, session) {
+				result = append(result, session)
+			}
+		}
+	}
+
+	return result
+}
+
+// remove releases the given session and forgets about it
+func (m *sessionMap) remove(value Session) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := value.Key()
+
+	m.m[key] = withoutSession(m.m[key], value)
+	if len(m.m[key]) == 0 {
+		delete(m.m, key)
+	}
+
+	m.unmappedSessions = withoutSession(m.unmappedSessions, value)
+
+	value.Release()
+}
+
 func (m *sessionMap) clear() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -362,6 +882,53 @@ func (m *sessionMap) clear() {
 	m.logger.Debug("Session map cleared")
 }
 
+func withoutSession(sessions []Session, value Session) []Session {
+	result := []Session{}
+
+	for _, session := range sessions {
+		if !sameSession(session, value) {
+			result = append(result, session)
+		}
+	}
+
+	return result
+}
+
+// sessionIdentity returns what the given session controls, which outlives the session itself: its stream or process,
+// if it has one. other sessions (master, devices) are the only ones of their key, which is what identifies them
+func sessionIdentity(session Session) string {
+	if stream, ok := session.(streamSession); ok {
+		return fmt.Sprintf("%s:stream:%d", session.Key(), stream.streamID())
+	}
+
+	if process, ok := session.(processSession); ok {
+		return fmt.Sprintf("%s:pid:%d", session.Key(), process.processID())
+	}
+
+	return session.Key()
+}
+
+// sameSession returns true if both sessions control the same thing. sessions are re-created on every refresh,
+// so ones that belong to a single stream are told apart by it rather than by which object they are
+func sameSession(a Session, b Session) bool {
+	if a == b {
+		return true
+	}
+
+	aStream, aOk := a.(streamSession)
+	bStream, bOk := b.(streamSession)
+
+	return aOk && bOk && aStream.streamID() == bStream.streamID()
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
 func (m *sessionMap) String() string {
 	m.lock.Lock()
 	defer m.lock.Unlock()
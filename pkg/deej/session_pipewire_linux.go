@@ -0,0 +1,228 @@
+package deej
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// pwStreamSession is an app's playback stream, as seen by PipeWire
+type pwStreamSession struct {
+	baseSession
+
+	processName string
+
+	// the stream node's properties (i.e. application.name, media.role), used by property targets
+	properties map[string]string
+
+	finder *pwSessionFinder
+	nodeID uint32
+}
+
+// pwDeviceSession is a sink or source node, controlled either as itself (sink:/source: targets)
+// or as the default device (master and mic)
+type pwDeviceSession struct {
+	baseSession
+
+	// set for sessions of a specific device rather than the default one, which can also be targeted by it
+	description string
+
+	finder *pwSessionFinder
+	nodeID uint32
+}
+
+func newPWStreamSession(
+	logger *zap.SugaredLogger,
+	finder *pwSessionFinder,
+	nodeID uint32,
+	processName string,
+	properties map[string]string,
+) *pwStreamSession {
+
+	s := &pwStreamSession{
+		finder:     finder,
+		nodeID:     nodeID,
+		properties: properties,
+	}
+
+	s.processName = processName
+	s.name = processName
+	s.humanReadableDesc = processName
+
+	// use a self-identifying session name e.g. deej.sessions.chrome
+	s.logger = logger.Named(s.Key())
+	s.logger.Debugw(sessionCreationLogMessage, "session", s)
+
+	return s
+}
+
+// newPWDeviceSession creates a session for a sink or source node. key is either master or mic,
+// or the node's name prefixed with sink: or source:
+func newPWDeviceSession(
+	logger *zap.SugaredLogger,
+	finder *pwSessionFinder,
+	nodeID uint32,
+	key string,
+	description string,
+) *pwDeviceSession {
+
+	s := &pwDeviceSession{
+		finder:      finder,
+		nodeID:      nodeID,
+		description: description,
+	}
+
+	s.logger = logger.Named(key)
+	s.master = true
+	s.name = key
+	s.humanReadableDesc = key
+
+	if description != "" {
+		s.humanReadableDesc = description
+	}
+
+	s.logger.Debugw(sessionCreationLogMessage, "session", s)
+
+	return s
+}
+
+func (s *pwStreamSession) GetVolume() float32 {
+	return getPWNodeVolume(s.logger, s.finder, s.nodeID)
+}
+
+func (s *pwStreamSession) SetVolume(v float32) error {
+	return setPWNodeVolume(s.logger, s.finder, s.nodeID, v)
+}
+
+func (s *pwStreamSession) GetMute() bool {
+	return getPWNodeMute(s.logger, s.finder, s.nodeID)
+}
+
+func (s *pwStreamSession) SetMute(m bool) error {
+	return setPWNodeMute(s.logger, s.finder, s.nodeID, m)
+}
+
+func (s *pwStreamSession) GetBalance() float32 {
+	return getPWNodeBalance(s.logger, s.finder, s.nodeID)
+}
+
+func (s *pwStreamSession) SetBalance(b float32) error {
+	return setPWNodeBalance(s.logger, s.finder, s.nodeID, b)
+}
+
+func (s *pwStreamSession) Property(name string) (string, bool) {
+	value, ok := s.properties[name]
+	return value, ok
+}
+
+func (s *pwStreamSession) streamID() uint32 {
+	return s.nodeID
+}
+
+func (s *pwStreamSession) Release() {
+	s.logger.Debug("Releasing audio session")
+}
+
+func (s *pwStreamSession) String() string {
+	return fmt.Sprintf(sessionStringFormat, s.humanReadableDesc, s.GetVolume())
+}
+
+func (s *pwDeviceSession) GetVolume() float32 {
+	return getPWNodeVolume(s.logger, s.finder, s.nodeID)
+}
+
+func (s *pwDeviceSession) SetVolume(v float32) error {
+	return setPWNodeVolume(s.logger, s.finder, s.nodeID, v)
+}
+
+func (s *pwDeviceSession) GetMute() bool {
+	return getPWNodeMute(s.logger, s.finder, s.nodeID)
+}
+
+func (s *pwDeviceSession) SetMute(m bool) error {
+	return setPWNodeMute(s.logger, s.finder, s.nodeID, m)
+}
+
+func (s *pwDeviceSession) GetBalance() float32 {
+	return getPWNodeBalance(s.logger, s.finder, s.nodeID)
+}
+
+func (s *pwDeviceSession) SetBalance(b float32) error {
+	return setPWNodeBalance(s.logger, s.finder, s.nodeID, b)
+}
+
+func (s *pwDeviceSession) Description() string {
+	return s.description
+}
+
+func (s *pwDeviceSession) Release() {
+	s.logger.Debug("Releasing audio session")
+}
+
+func (s *pwDeviceSession) String() string {
+	return fmt.Sprintf(sessionStringFormat, s.humanReadableDesc, s.GetVolume())
+}
+
+// streams and devices are both just nodes to PipeWire, so they share the actual volume handling
+
+// like PulseAudio, a node's volume is that of its loudest channel
+func getPWNodeVolume(logger *zap.SugaredLogger, finder *pwSessionFinder, nodeID uint32) float32 {
+	levels, _, ok := finder.nodeChannels(nodeID)
+	if !ok {
+		logger.Warnw("Failed to get session volume", "error", errPipeWireNodeGone)
+	}
+
+	return float32(maxChannelLevel(levels))
+}
+
+func setPWNodeVolume(logger *zap.SugaredLogger, finder *pwSessionFinder, nodeID uint32, v float32) error {
+	if err := finder.setNodeVolume(nodeID, v); err != nil {
+		logger.Warnw("Failed to set session volume", "error", err, "volume", v)
+		return fmt.Errorf("adjust session volume: %w", err)
+	}
+
+	logger.Debugw("Adjusting session volume", "to", fmt.Sprintf("%.2f", v))
+
+	return nil
+}
+
+func getPWNodeMute(logger *zap.SugaredLogger, finder *pwSessionFinder, nodeID uint32) bool {
+	mute, ok := finder.nodeMute(nodeID)
+	if !ok {
+		logger.Warnw("Failed to get session mute state", "error", errPipeWireNodeGone)
+	}
+
+	return mute
+}
+
+func setPWNodeMute(logger *zap.SugaredLogger, finder *pwSessionFinder, nodeID uint32, m bool) error {
+	if err := finder.setNodeMute(nodeID, m); err != nil {
+		logger.Warnw("Failed to set session mute state", "error", err, "mute", m)
+		return fmt.Errorf("adjust session mute state: %w", err)
+	}
+
+	logger.Debugw("Adjusting session mute state", "to", m)
+
+	return nil
+}
+
+func getPWNodeBalance(logger *zap.SugaredLogger, finder *pwSessionFinder, nodeID uint32) float32 {
+	levels, sides, ok := finder.nodeChannels(nodeID)
+	if !ok {
+		logger.Warnw("Failed to get session balance", "error", errPipeWireNodeGone)
+		return centerBalance
+	}
+
+	return channelBalance(levels, sides)
+}
+
+func setPWNodeBalance(logger *zap.SugaredLogger, finder *pwSessionFinder, nodeID uint32, b float32) error {
+	if err := finder.setNodeBalance(nodeID, b); err != nil {
+		logger.Warnw("Failed to set session balance", "error", err, "balance", b)
+		return fmt.Errorf("adjust session balance: %w", err)
+	}
+
+	logger.Debugw("Adjusting session balance", "to", fmt.Sprintf("%.2f", b))
+
+	return nil
+}
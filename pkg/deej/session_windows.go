@@ -33,7 +33,7 @@ type masterSession struct {
 
 	eventCtx *ole.GUID
 
-	stale bool // when set to true, we should refresh sessions on the next call to SetVolume
+	stale bool // when set to true, we should refresh sessions on the next call to SetVolume/SetMute
 }
 
 func newWCASession(
@@ -143,6 +143,31 @@ func (s *wcaSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *wcaSession) GetMute() bool {
+	var mute bool
+
+	if err := s.volume.GetMute(&mute); err != nil {
+		s.logger.Warnw("Failed to get session mute state", "error", err)
+	}
+
+	return mute
+}
+
+func (s *wcaSession) SetMute(m bool) error {
+	if err := s.volume.SetMute(m, s.eventCtx); err != nil {
+		s.logger.Warnw("Failed to set session mute state", "error", err)
+		return fmt.Errorf("adjust session mute state: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session mute state", "to", m)
+
+	return nil
+}
+
+func (s *wcaSession) processID() uint32 {
+	return s.pid
+}
+
 func (s *wcaSession) Release() {
 	s.logger.Debug("Releasing audio session")
 
@@ -183,6 +208,35 @@ func (s *masterSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *masterSession) GetMute() bool {
+	var mute bool
+
+	if err := s.volume.GetMute(&mute); err != nil {
+		s.logger.Warnw("Failed to get session mute state", "error", err)
+	}
+
+	return mute
+}
+
+func (s *masterSession) SetMute(m bool) error {
+	if s.stale {
+		s.logger.Warnw("Session expired because default device has changed, triggering session refresh")
+		return errRefreshSessions
+	}
+
+	if err := s.volume.SetMute(m, s.eventCtx); err != nil {
+		s.logger.Warnw("Failed to set session mute state",
+			"error", err,
+			"mute", m)
+
+		return fmt.Errorf("adjust session mute state: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session mute state", "to", m)
+
+	return nil
+}
+
 func (s *masterSession) Release() {
 	s.logger.Debug("Releasing audio session")
 
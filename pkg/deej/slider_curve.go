@@ -0,0 +1,291 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
+)
+
+// sliderCurve maps a slider's position (between 0 and 1) to the volume it sets. sliders are linear by default,
+// which leaves most of their travel to volumes that are too loud for many setups
+type sliderCurve struct {
+	name string
+
+	// the lookup table of custom curves, whose values are spread evenly over the slider's travel
+	points []float64
+
+	// the curve is fitted between these, so that the whole travel is still used
+	min float64
+	max float64
+}
+
+const (
+	sliderCurveLinear      = "linear"
+	sliderCurveLogarithmic = "logarithmic"
+	sliderCurveExponential = "exponential"
+
+	// curves given as a lookup table (a list of volumes) rather than by name
+	sliderCurveCustom = "custom"
+
+	// logarithmic curves move the volume by the same amount of decibels across the slider's travel,
+	// from this far below full volume up to full volume (with the very bottom still being silent)
+	logarithmicCurveRange = 40.0
+
+	// how sharply exponential curves rise towards their top end
+	exponentialCurveSteepness = 4.0
+
+	// the keys of a slider's curve when given as a map rather than just a curve
+	sliderCurveKeyCurve = "curve"
+	sliderCurveKeyMin   = "min"
+	sliderCurveKeyMax   = "max"
+)
+
+var supportedSliderCurves = []string{sliderCurveLinear, sliderCurveLogarithmic, sliderCurveExponential}
+
+var defaultSliderCurve = &sliderCurve{name: sliderCurveLinear, min: 0, max: 1}
+
+// sliderCurvesFromConfig reads the per-slider curves from the config. each slider's curve is either a curve name,
+// a lookup table (a list of volumes), or a map holding either of those along with the volume's min and max, i.e.:
+//
+//	0: logarithmic
+//	1: [0, 0.05, 0.15, 0.4, 1]
+//	2: {curve: exponential, min: 0.1, max: 0.8}
+//
+// invalid curves are warned about and left linear
+func sliderCurvesFromConfig(logger *zap.SugaredLogger, userCurves map[string]interface{}) map[int]*sliderCurve {
+	curves := map[int]*sliderCurve{}
+
+	for sliderIdxString, value := range userCurves {
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil {
+			logger.Warnw("Invalid slider index in slider curves, ignoring", "key", configKeySliderCurves, "sliderIdx", sliderIdxString)
+			continue
+		}
+
+		curve, err := parseSliderCurve(value)
+		if err != nil {
+			logger.Warnw("Invalid slider curve specified, using linear curve",
+				"key", configKeySliderCurves,
+				"sliderIdx", sliderIdx,
+				"invalidValue", value,
+				"error", err)
+
+			continue
+		}
+
+		curves[sliderIdx] = curve
+	}
+
+	return curves
+}
+
+func parseSliderCurve(value interface{}) (*sliderCurve, error) {
+	curve := &sliderCurve{min: 0, max: 1}
+
+	// the curve itself may come with limits, in which case it's nested one level deeper
+	if options, ok := stringKeyedMap(value); ok {
+		value = options[sliderCurveKeyCurve]
+		if value == nil {
+			value = sliderCurveLinear
+		}
+
+		var err error
+
+		if curve.min, err = parseCurveLimit(options, sliderCurveKeyMin, curve.min); err != nil {
+			return nil, err
+		}
+
+		if curve.max, err = parseCurveLimit(options, sliderCurveKeyMax, curve.max); err != nil {
+			return nil, err
+		}
+
+		if curve.min >= curve.max {
+			return nil, fmt.Errorf("min (%v) must be below max (%v)", curve.min, curve.max)
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		curve.name = strings.ToLower(value)
+		if !funk.ContainsString(supportedSliderCurves, curve.name) {
+			return nil, fmt.Errorf("unknown curve %q", value)
+		}
+
+	case []interface{}:
+		if len(value) < 2 {
+			return nil, fmt.Errorf("lookup table needs at least 2 values, got %d", len(value))
+		}
+
+		curve.name = sliderCurveCustom
+		curve.points = make([]float64, len(value))
+
+		for idx, point := range value {
+			number, ok := configNumber(point)
+			if !ok || number < 0 || number > 1 {
+				return nil, fmt.Errorf("lookup table values must be numbers between 0 and 1, got %v", point)
+			}
+
+			// volumes going back down as the slider goes up would make for a confusing slider
+			if idx > 0 && number < curve.points[idx-1] {
+				return nil, fmt.Errorf("lookup table values must not decrease, got %v after %v", number, curve.points[idx-1])
+			}
+
+			curve.points[idx] = number
+		}
+
+	default:
+		return nil, fmt.Errorf("expected a curve name, lookup table or map, got %T", value)
+	}
+
+	return curve, nil
+}
+
+func parseCurveLimit(options map[string]interface{}, key string, defaultValue float64) (float64, error) {
+	value, ok := options[key]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	number, ok := configNumber(value)
+	if !ok || number < 0 || number > 1 {
+		return 0, fmt.Errorf("%s must be a number between 0 and 1, got %v", key, value)
+	}
+
+	return number, nil
+}
+
+// stringKeyedMap returns the given config value as a map, if it is one. nested maps come out of the yaml parser
+// keyed by interface{} rather than string
+func stringKeyedMap(value interface{}) (map[string]interface{}, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, true
+
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for key, mapValue := range value {
+			result[strings.ToLower(fmt.Sprint(key))] = mapValue
+		}
+
+		return result, true
+	}
+
+	return nil, false
+}
+
+// configNumber returns the given config value as a float, whether the yaml parser read it as an int or a float
+func configNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+
+	return 0, false
+}
+
+// apply returns the volume for the given slider position
+func (c *sliderCurve) apply(position float32) float32 {
+	p := math.Max(0, math.Min(1, float64(position)))
+
+	var volume float64
+
+	switch c.name {
+	case sliderCurveLogarithmic:
+		if p > 0 {
+			volume = math.Pow(10, (p-1)*logarithmicCurveRange/20)
+		}
+
+	case sliderCurveExponential:
+		volume = (math.Exp(exponentialCurveSteepness*p) - 1) / (math.Exp(exponentialCurveSteepness) - 1)
+
+	case sliderCurveCustom:
+
+		// interpolate between the two table values the position falls between
+		scaled := p * float64(len(c.points)-1)
+		lower := int(math.Floor(scaled))
+
+		if lower >= len(c.points)-1 {
+			volume = c.points[len(c.points)-1]
+		} else {
+			volume = c.points[lower] + (c.points[lower+1]-c.points[lower])*(scaled-float64(lower))
+		}
+
+	default:
+		volume = p
+	}
+
+	return float32(c.min + volume*(c.max-c.min))
+}
+
+// invert returns the slider position at which the curve reaches the given volume, that is, where the slider
+// would have to be for a volume that was set by other means. volumes outside the curve's limits are clamped to them
+func (c *sliderCurve) invert(volume float32) float32 {
+	v := math.Max(0, math.Min(1, (float64(volume)-c.min)/(c.max-c.min)))
+
+	var position float64
+
+	switch c.name {
+	case sliderCurveLogarithmic:
+		if v > 0 {
+			position = 1 + math.Log10(v)*20/logarithmicCurveRange
+		}
+
+	case sliderCurveExponential:
+		position = math.Log(v*(math.Exp(exponentialCurveSteepness)-1)+1) / exponentialCurveSteepness
+
+	case sliderCurveCustom:
+
+		// find the first two table values the volume falls between. flat stretches resolve to their start
+		position = 1
+
+		for idx := 1; idx < len(c.points); idx++ {
+			if v > c.points[idx] {
+				continue
+			}
+
+			var segment float64
+			if lower, upper := c.points[idx-1], c.points[idx]; upper > lower {
+				segment = (v - lower) / (upper - lower)
+			}
+
+			position = (float64(idx-1) + segment) / float64(len(c.points)-1)
+			break
+		}
+
+	default:
+		position = v
+	}
+
+	return float32(math.Max(0, math.Min(1, position)))
+}
+
+// clamp keeps a volume set by other means than the slider's position (i.e. an encoder) within the curve's limits
+func (c *sliderCurve) clamp(volume float64) float64 {
+	return math.Max(c.min, math.Min(c.max, volume))
+}
+
+func (c *sliderCurve) String() string {
+	if c.name == sliderCurveCustom {
+		return fmt.Sprintf("%s%v (%.2f-%.2f)", c.name, c.points, c.min, c.max)
+	}
+
+	return fmt.Sprintf("%s (%.2f-%.2f)", c.name, c.min, c.max)
+}
+
+// sliderCurve returns the curve of the given slider, which is linear unless the config says otherwise
+func (cc *CanonicalConfig) sliderCurve(sliderID int) *sliderCurve {
+	cc.profileLock.Lock()
+	defer cc.profileLock.Unlock()
+
+	if curve, ok := cc.SliderCurves[sliderID]; ok {
+		return curve
+	}
+
+	return defaultSliderCurve
+}
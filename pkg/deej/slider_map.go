@@ -23,9 +23,12 @@ func newSliderMap() *sliderMap {
 func sliderMapFromConfigs(userMapping map[string][]string, internalMapping map[string][]string) *sliderMap {
 	resultMap := newSliderMap()
 
-	// copy targets from user config, ignoring empty values
+	// copy targets from user config, ignoring empty values (and indexes that aren't numbers, which used to mean 0)
 	for sliderIdxString, targets := range userMapping {
-		sliderIdx, _ := strconv.Atoi(sliderIdxString)
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil {
+			continue
+		}
 
 		resultMap.set(sliderIdx, funk.FilterString(targets, func(s string) bool {
 			return s != ""
@@ -34,7 +37,10 @@ func sliderMapFromConfigs(userMapping map[string][]string, internalMapping map[s
 
 	// add targets from internal configs, ignoring duplicate or empty values
 	for sliderIdxString, targets := range internalMapping {
-		sliderIdx, _ := strconv.Atoi(sliderIdxString)
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil {
+			continue
+		}
 
 		existingTargets, ok := resultMap.get(sliderIdx)
 		if !ok {
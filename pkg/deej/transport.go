@@ -0,0 +1,51 @@
+package deej
+
+import (
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+)
+
+// Transport represents a bidirectional byte stream between deej and its board, regardless of how the two are connected
+type Transport interface {
+	io.ReadWriteCloser
+
+	// Open establishes the underlying connection. It must be called before reading or writing,
+	// and can be called again after Close to reconnect with the same parameters
+	Open() error
+
+	// Reconnectable reports whether it makes sense to re-open the transport after the given error
+	// (as returned by Open or Read), as opposed to it being permanent until the user intervenes
+	Reconnectable(err error) bool
+
+	// String describes the transport's endpoint, e.g. "COM4" or "tcp://192.168.1.50:5000"
+	String() string
+}
+
+const (
+	transportTypeSerial = "serial"
+	transportTypeTCP    = "tcp"
+	transportTypeUDP    = "udp"
+	transportTypeUnix   = "unix"
+)
+
+var supportedTransportTypes = []string{transportTypeSerial, transportTypeTCP, transportTypeUDP, transportTypeUnix}
+
+// newTransport creates the transport described by the given connection info, without opening it
+func newTransport(logger *zap.SugaredLogger, connectionInfo ConnectionInfo) (Transport, error) {
+	switch connectionInfo.Type {
+	case transportTypeSerial:
+		return newSerialTransport(logger,
+			connectionInfo.COMPort,
+			uint(connectionInfo.BaudRate),
+			connectionInfo.USBVendorID,
+			connectionInfo.USBProductID), nil
+	case transportTypeTCP, transportTypeUDP:
+		return newNetTransport(logger, connectionInfo.Type, connectionInfo.Address), nil
+	case transportTypeUnix:
+		return newLocalTransport(logger, connectionInfo.Address), nil
+	}
+
+	return nil, fmt.Errorf("unsupported connection type: %s", connectionInfo.Type)
+}
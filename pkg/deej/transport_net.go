@@ -0,0 +1,160 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// netTransport connects to a board over the network (i.e. an ESP32 over Wi-Fi), using either TCP or UDP
+type netTransport struct {
+	logger *zap.SugaredLogger
+
+	network string
+	address string
+	conn    net.Conn
+}
+
+// localTransport connects to a local unix socket or pseudo-terminal, which is mostly useful for
+// driving deej from test harnesses and other programs without any real hardware
+type localTransport struct {
+	logger *zap.SugaredLogger
+
+	path string
+	conn io.ReadWriteCloser
+}
+
+const netTransportDialTimeout = 5 * time.Second
+
+// sent to UDP boards right after opening, since they can't know where to send their lines before hearing from us
+var udpGreeting = []byte("\n")
+
+func newNetTransport(logger *zap.SugaredLogger, network string, address string) *netTransport {
+	return &netTransport{
+		logger:  logger.Named(fmt.Sprintf("%s_transport", network)),
+		network: network,
+		address: address,
+	}
+}
+
+func (t *netTransport) Open() error {
+	t.logger.Debugw("Attempting network connection", "network", t.network, "address", t.address)
+
+	conn, err := net.DialTimeout(t.network, t.address, netTransportDialTimeout)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", t, err)
+	}
+
+	if t.network == transportTypeUDP {
+		if _, err := conn.Write(udpGreeting); err != nil {
+			conn.Close()
+			return fmt.Errorf("greet %s: %w", t, err)
+		}
+	}
+
+	t.conn = conn
+
+	return nil
+}
+
+func (t *netTransport) Read(p []byte) (int, error) {
+	return t.conn.Read(p)
+}
+
+func (t *netTransport) Write(p []byte) (int, error) {
+	return t.conn.Write(p)
+}
+
+func (t *netTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+
+	err := t.conn.Close()
+	t.conn = nil
+
+	return err
+}
+
+func (t *netTransport) Reconnectable(err error) bool {
+
+	// a board on the network can always come back (it might just be rebooting or out of range),
+	// unless the address itself is malformed
+	var addrErr *net.AddrError
+	return !errors.As(err, &addrErr)
+}
+
+func (t *netTransport) String() string {
+	return fmt.Sprintf("%s://%s", t.network, t.address)
+}
+
+func newLocalTransport(logger *zap.SugaredLogger, path string) *localTransport {
+	return &localTransport{
+		logger: logger.Named("local_transport"),
+		path:   path,
+	}
+}
+
+func (t *localTransport) Open() error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", t.path, err)
+	}
+
+	// sockets are dialed, anything else (such as a pty) is opened like a regular file
+	if info.Mode()&os.ModeSocket != 0 {
+		t.logger.Debugw("Attempting unix socket connection", "path", t.path)
+
+		conn, err := net.Dial(transportTypeUnix, t.path)
+		if err != nil {
+			return fmt.Errorf("dial unix socket %s: %w", t.path, err)
+		}
+
+		t.conn = conn
+	} else {
+		t.logger.Debugw("Attempting to open local device", "path", t.path)
+
+		file, err := os.OpenFile(t.path, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("open local device %s: %w", t.path, err)
+		}
+
+		t.conn = file
+	}
+
+	return nil
+}
+
+func (t *localTransport) Read(p []byte) (int, error) {
+	return t.conn.Read(p)
+}
+
+func (t *localTransport) Write(p []byte) (int, error) {
+	return t.conn.Write(p)
+}
+
+func (t *localTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+
+	err := t.conn.Close()
+	t.conn = nil
+
+	return err
+}
+
+func (t *localTransport) Reconnectable(err error) bool {
+
+	// same as with serial ports, permission errors aren't going to fix themselves
+	return !errors.Is(err, os.ErrPermission)
+}
+
+func (t *localTransport) String() string {
+	return fmt.Sprintf("unix://%s", t.path)
+}
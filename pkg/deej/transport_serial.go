@@ -0,0 +1,113 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jacobsa/go-serial/serial"
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// serialTransport connects to the board over a (usually USB) serial port
+type serialTransport struct {
+	logger *zap.SugaredLogger
+
+	connOptions serial.OpenOptions
+	conn        io.ReadWriteCloser
+
+	// when set to auto, the actual port is detected (again) on every Open, since it can change between replugs
+	comPort      string
+	usbVendorID  string
+	usbProductID string
+}
+
+func newSerialTransport(
+	logger *zap.SugaredLogger,
+	comPort string,
+	baudRate uint,
+	usbVendorID string,
+	usbProductID string,
+) *serialTransport {
+
+	// set minimum read size according to platform (0 for windows, 1 for linux)
+	// this prevents a rare bug on windows where serial reads get congested,
+	// resulting in significant lag
+	minimumReadSize := 0
+	if util.Linux() {
+		minimumReadSize = 1
+	}
+
+	return &serialTransport{
+		logger:       logger.Named("serial_transport"),
+		comPort:      comPort,
+		usbVendorID:  usbVendorID,
+		usbProductID: usbProductID,
+		connOptions: serial.OpenOptions{
+			PortName:        comPort,
+			BaudRate:        baudRate,
+			DataBits:        8,
+			StopBits:        1,
+			MinimumReadSize: uint(minimumReadSize),
+		},
+	}
+}
+
+func (t *serialTransport) Open() error {
+	if strings.EqualFold(t.comPort, comPortAuto) {
+		detectedPort, err := detectSerialPort(t.logger, t.connOptions.BaudRate, t.usbVendorID, t.usbProductID)
+		if err != nil {
+			return fmt.Errorf("detect serial port: %w", err)
+		}
+
+		t.connOptions.PortName = detectedPort
+	}
+
+	t.logger.Debugw("Attempting serial connection",
+		"comPort", t.connOptions.PortName,
+		"baudRate", t.connOptions.BaudRate,
+		"minReadSize", t.connOptions.MinimumReadSize)
+
+	conn, err := serial.Open(t.connOptions)
+	if err != nil {
+		return fmt.Errorf("open serial port %s: %w", t.connOptions.PortName, err)
+	}
+
+	t.conn = conn
+
+	return nil
+}
+
+func (t *serialTransport) Read(p []byte) (int, error) {
+	return t.conn.Read(p)
+}
+
+func (t *serialTransport) Write(p []byte) (int, error) {
+	return t.conn.Write(p)
+}
+
+func (t *serialTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+
+	err := t.conn.Close()
+	t.conn = nil
+
+	return err
+}
+
+func (t *serialTransport) Reconnectable(err error) bool {
+
+	// a busy port means something else is connected to the board, and that won't go away on its own.
+	// note that a missing board (when detecting its port automatically) isn't permanent - it can be replugged
+	return !errors.Is(err, os.ErrPermission)
+}
+
+func (t *serialTransport) String() string {
+	return t.connOptions.PortName
+}
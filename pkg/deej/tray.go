@@ -1,7 +1,11 @@
 package deej
 
 import (
+	"fmt"
+
 	"github.com/getlantern/systray"
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
 
 	"github.com/omriharel/deej/pkg/deej/icon"
 	"github.com/omriharel/deej/pkg/deej/util"
@@ -23,6 +27,10 @@ func (d *Deej) initializeTray(onDone func()) {
 		refreshSessions := systray.AddMenuItem("Re-scan audio sessions", "Manually refresh audio sessions if something's stuck")
 		refreshSessions.SetIcon(icon.RefreshSessions)
 
+		// only shown once there's more than one profile to pick from
+		profiles := systray.AddMenuItem("Profiles", "Switch between your config's profiles")
+		d.setupProfileMenu(logger, profiles)
+
 		if d.version != "" {
 			systray.AddSeparator()
 			versionInfo := systray.AddMenuItem(d.version, "")
@@ -80,6 +88,72 @@ func (d *Deej) initializeTray(onDone func()) {
 	systray.Run(onReady, onExit)
 }
 
+// setupProfileMenu fills the profiles menu with an item for every profile, with the active one checked.
+// items are kept in sync with the config as it's reloaded (since the tray can't remove them, old ones are hidden)
+func (d *Deej) setupProfileMenu(logger *zap.SugaredLogger, profilesMenu *systray.MenuItem) {
+	items := map[string]*systray.MenuItem{}
+
+	update := func() {
+		profileNames := d.config.ProfileNames()
+		activeProfile := d.config.ActiveProfile()
+
+		for _, name := range profileNames {
+			item, ok := items[name]
+			if !ok {
+				item = profilesMenu.AddSubMenuItem(name, fmt.Sprintf("Switch to the %s profile", name))
+				items[name] = item
+
+				go func(name string, item *systray.MenuItem) {
+					for {
+						select {
+						case <-item.ClickedCh:
+							logger.Infow("Profile menu item clicked, switching profile", "profile", name)
+
+							if err := d.config.SwitchProfile(name); err != nil {
+								logger.Warnw("Failed to switch profile", "profile", name, "error", err)
+							}
+						}
+					}
+				}(name, item)
+			}
+
+			item.Show()
+
+			if name == activeProfile {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+		}
+
+		for name, item := range items {
+			if !funk.ContainsString(profileNames, name) {
+				item.Hide()
+			}
+		}
+
+		if len(profileNames) > 1 {
+			profilesMenu.Show()
+		} else {
+			profilesMenu.Hide()
+		}
+	}
+
+	update()
+
+	// profile switches count as config reloads too
+	configReloadedChannel := d.config.SubscribeToChanges()
+
+	go func() {
+		for {
+			select {
+			case <-configReloadedChannel:
+				update()
+			}
+		}
+	}()
+}
+
 func (d *Deej) stopTray() {
 	d.logger.Debug("Quitting tray")
 	systray.Quit()
@@ -47,11 +47,25 @@ func SetupCloseHandler() chan os.Signal {
 
 // GetCurrentWindowProcessNames returns the process names (including extension, if applicable)
 // of the current foreground window. This includes child processes belonging to the window.
-// This is currently only implemented for Windows
+// On Linux, this works with X11 as well as the sway and Hyprland Wayland compositors
 func GetCurrentWindowProcessNames() ([]string, error) {
 	return getCurrentWindowProcessNames()
 }
 
+// media keys that can be simulated with SendMediaKey
+const (
+	MediaKeyPlayPause = "play_pause"
+	MediaKeyNext      = "next"
+	MediaKeyPrevious  = "previous"
+	MediaKeyStop      = "stop"
+)
+
+// SendMediaKey simulates a press of the given media key (one of the MediaKey* constants).
+// On Linux, this is delegated to playerctl which needs to be installed separately
+func SendMediaKey(key string) error {
+	return sendMediaKey(key)
+}
+
 // OpenExternal spawns a detached window with the provided command and argument
 func OpenExternal(logger *zap.SugaredLogger, cmd string, arg string) error {
 
@@ -1,9 +1,27 @@
 package util
 
 import (
-	"errors"
+	"fmt"
+	"os/exec"
 )
 
-func getCurrentWindowProcessNames() ([]string, error) {
-	return nil, errors.New("Not implemented")
+func sendMediaKey(key string) error {
+	playerctlCommands := map[string]string{
+		MediaKeyPlayPause: "play-pause",
+		MediaKeyNext:      "next",
+		MediaKeyPrevious:  "previous",
+		MediaKeyStop:      "stop",
+	}
+
+	command, ok := playerctlCommands[key]
+	if !ok {
+		return fmt.Errorf("unknown media key: %s", key)
+	}
+
+	// playerctl talks to whichever MPRIS-capable player is currently active
+	if err := exec.Command("playerctl", command).Run(); err != nil {
+		return fmt.Errorf("run playerctl %s: %w", command, err)
+	}
+
+	return nil
 }
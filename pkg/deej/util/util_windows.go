@@ -94,3 +94,42 @@ func getCurrentWindowProcessNames() ([]string, error) {
 	lastGetCurrentWindowResult = result
 	return result, nil
 }
+
+func sendMediaKey(key string) error {
+	virtualKeyCodes := map[string]uint16{
+		MediaKeyPlayPause: win.VK_MEDIA_PLAY_PAUSE,
+		MediaKeyNext:      win.VK_MEDIA_NEXT_TRACK,
+		MediaKeyPrevious:  win.VK_MEDIA_PREV_TRACK,
+		MediaKeyStop:      win.VK_MEDIA_STOP,
+	}
+
+	virtualKeyCode, ok := virtualKeyCodes[key]
+	if !ok {
+		return fmt.Errorf("unknown media key: %s", key)
+	}
+
+	// a key press is made of a key down event followed by a key up event
+	inputs := []win.KEYBD_INPUT{
+		{
+			Type: win.INPUT_KEYBOARD,
+			Ki: win.KEYBDINPUT{
+				WVk:     virtualKeyCode,
+				DwFlags: win.KEYEVENTF_EXTENDEDKEY,
+			},
+		},
+		{
+			Type: win.INPUT_KEYBOARD,
+			Ki: win.KEYBDINPUT{
+				WVk:     virtualKeyCode,
+				DwFlags: win.KEYEVENTF_EXTENDEDKEY | win.KEYEVENTF_KEYUP,
+			},
+		},
+	}
+
+	sent := win.SendInput(uint32(len(inputs)), unsafe.Pointer(&inputs[0]), int32(unsafe.Sizeof(inputs[0])))
+	if sent != uint32(len(inputs)) {
+		return fmt.Errorf("send input for media key %s: only %d of %d events sent", key, sent, len(inputs))
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// hyprlandBackend asks Hyprland for its active window through its request socket.
+// see https://wiki.hyprland.org/IPC
+type hyprlandBackend struct{}
+
+const hyprlandActiveWindowRequest = "j/activewindow"
+
+// hyprlandWindow holds the parts of an activewindow reply we care about. it's an empty object if nothing is focused
+type hyprlandWindow struct {
+	PID int `json:"pid"`
+}
+
+func (b *hyprlandBackend) available() bool {
+	return os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != ""
+}
+
+// socketPath returns the path to Hyprland's request socket, which moved from /tmp to the runtime dir in v0.40
+func (b *hyprlandBackend) socketPath() string {
+	instanceSignature := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		socketPath := filepath.Join(runtimeDir, "hypr", instanceSignature, ".socket.sock")
+		if _, err := os.Stat(socketPath); err == nil {
+			return socketPath
+		}
+	}
+
+	return filepath.Join("/tmp", "hypr", instanceSignature, ".socket.sock")
+}
+
+func (b *hyprlandBackend) activeWindowPID() (int, error) {
+	conn, err := net.DialTimeout("unix", b.socketPath(), windowIPCTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("connect to hyprland ipc: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(windowIPCTimeout))
+
+	if _, err := conn.Write([]byte(hyprlandActiveWindowRequest)); err != nil {
+		return 0, fmt.Errorf("send hyprland ipc request: %w", err)
+	}
+
+	// hyprland closes the connection once it's done replying
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return 0, fmt.Errorf("read hyprland ipc reply: %w", err)
+	}
+
+	window := hyprlandWindow{}
+	if err := json.Unmarshal(reply, &window); err != nil {
+		return 0, fmt.Errorf("parse hyprland active window: %w", err)
+	}
+
+	// windows without a known process report a pid of -1
+	if window.PID < 0 {
+		return 0, nil
+	}
+
+	return window.PID, nil
+}
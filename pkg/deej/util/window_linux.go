@@ -0,0 +1,141 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-ps"
+)
+
+// linux has no single way of asking which window is in the foreground - it depends on the display server.
+// each backend knows how to ask one of them for the PID of the process owning the active window
+type activeWindowBackend interface {
+
+	// available reports whether the backend applies to the current session (i.e. its compositor is running)
+	available() bool
+
+	// activeWindowPID returns the PID of the active window's process, or 0 if no window is active
+	activeWindowPID() (int, error)
+}
+
+// backends are tried in this order. wayland compositors come first, since their sessions usually run
+// XWayland as well - which would only tell us about the X11 apps among their windows
+var activeWindowBackends = []activeWindowBackend{
+	&swayBackend{},
+	&hyprlandBackend{},
+	&x11Backend{},
+}
+
+const (
+	getCurrentWindowInternalCooldown = time.Millisecond * 350
+
+	// apps like browsers play audio from child processes, but there's no need to go all the way down
+	maxChildProcessDepth = 3
+
+	deletedExecutableSuffix = " (deleted)"
+)
+
+var (
+	lastGetCurrentWindowResult []string
+	lastGetCurrentWindowCall   = time.Now()
+
+	errNoActiveWindowBackend = errors.New("no supported display server found")
+)
+
+func getCurrentWindowProcessNames() ([]string, error) {
+
+	// apply an internal cooldown on this function to avoid talking to the display server too frequently.
+	// return a cached value during that cooldown
+	now := time.Now()
+	if lastGetCurrentWindowCall.Add(getCurrentWindowInternalCooldown).After(now) {
+		return lastGetCurrentWindowResult, nil
+	}
+
+	lastGetCurrentWindowCall = now
+
+	backend := findActiveWindowBackend()
+	if backend == nil {
+		return nil, errNoActiveWindowBackend
+	}
+
+	pid, err := backend.activeWindowPID()
+	if err != nil {
+		return nil, fmt.Errorf("get active window pid: %w", err)
+	}
+
+	// no window is focused, or it doesn't say which process it belongs to
+	if pid == 0 {
+		lastGetCurrentWindowResult = nil
+		return nil, nil
+	}
+
+	// like on windows, the window's own process comes first, followed by any process that might actually
+	// be the one playing audio on its behalf (here: its children, since there are no child windows to go by)
+	result := []string{}
+	if name := processName(pid); name != "" {
+		result = append(result, name)
+	}
+
+	processes, err := ps.Processes()
+	if err == nil {
+		result = append(result, childProcessNames(processes, pid, maxChildProcessDepth)...)
+	}
+
+	// cache & return whichever executable names we ended up with
+	lastGetCurrentWindowResult = result
+	return result, nil
+}
+
+func findActiveWindowBackend() activeWindowBackend {
+	for _, backend := range activeWindowBackends {
+		if backend.available() {
+			return backend
+		}
+	}
+
+	return nil
+}
+
+// childProcessNames returns the names of all processes descending from the given one, down to the given depth
+func childProcessNames(processes []ps.Process, parentPID int, depth int) []string {
+	if depth == 0 {
+		return nil
+	}
+
+	names := []string{}
+
+	for _, process := range processes {
+		if process.PPid() != parentPID || process.Pid() == parentPID {
+			continue
+		}
+
+		if name := processName(process.Pid()); name != "" {
+			names = append(names, name)
+		}
+
+		names = append(names, childProcessNames(processes, process.Pid(), depth-1)...)
+	}
+
+	return names
+}
+
+// processName returns the base name of a process's executable. this is what PulseAudio reports as the process binary,
+// and unlike the kernel's process name it isn't cut short at 15 characters
+func processName(pid int) string {
+	if executable, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+
+		// executables replaced while running (i.e. by an update) are marked as such
+		return strings.TrimSuffix(filepath.Base(executable), deletedExecutableSuffix)
+	}
+
+	// we may not be allowed to look at the executable (i.e. for another user's processes)
+	if process, err := ps.FindProcess(pid); err == nil && process != nil {
+		return process.Executable()
+	}
+
+	return ""
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// swayBackend asks sway (or i3, which shares its IPC protocol) for its window tree, and finds the focused window in it.
+// see https://i3wm.org/docs/ipc.html
+type swayBackend struct{}
+
+const (
+	i3IPCMagic       = "i3-ipc"
+	i3IPCGetTree     = 4
+	i3IPCHeaderSize  = len(i3IPCMagic) + 8
+	windowIPCTimeout = 500 * time.Millisecond
+)
+
+// swayNode is a single node of sway's window tree (outputs, workspaces, containers and windows alike)
+type swayNode struct {
+	Focused       bool       `json:"focused"`
+	PID           int        `json:"pid"`
+	Nodes         []swayNode `json:"nodes"`
+	FloatingNodes []swayNode `json:"floating_nodes"`
+}
+
+func (b *swayBackend) available() bool {
+	return b.socketPath() != ""
+}
+
+func (b *swayBackend) socketPath() string {
+	if socketPath := os.Getenv("SWAYSOCK"); socketPath != "" {
+		return socketPath
+	}
+
+	return os.Getenv("I3SOCK")
+}
+
+func (b *swayBackend) activeWindowPID() (int, error) {
+	conn, err := net.DialTimeout("unix", b.socketPath(), windowIPCTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("connect to sway ipc: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(windowIPCTimeout))
+
+	// messages are made of the magic string, the payload's length and the message type (both in native byte order),
+	// followed by the payload - which is empty for GET_TREE. replies have the same format
+	request := make([]byte, i3IPCHeaderSize)
+	copy(request, i3IPCMagic)
+	binary.LittleEndian.PutUint32(request[len(i3IPCMagic):], 0)
+	binary.LittleEndian.PutUint32(request[len(i3IPCMagic)+4:], i3IPCGetTree)
+
+	if _, err := conn.Write(request); err != nil {
+		return 0, fmt.Errorf("send sway ipc request: %w", err)
+	}
+
+	header := make([]byte, i3IPCHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, fmt.Errorf("read sway ipc reply header: %w", err)
+	}
+
+	if string(header[:len(i3IPCMagic)]) != i3IPCMagic {
+		return 0, fmt.Errorf("unexpected sway ipc reply: %q", header)
+	}
+
+	payload := make([]byte, binary.LittleEndian.Uint32(header[len(i3IPCMagic):]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return 0, fmt.Errorf("read sway ipc reply: %w", err)
+	}
+
+	tree := swayNode{}
+	if err := json.Unmarshal(payload, &tree); err != nil {
+		return 0, fmt.Errorf("parse sway window tree: %w", err)
+	}
+
+	if focused := findFocusedSwayNode(&tree); focused != nil {
+		return focused.PID, nil
+	}
+
+	return 0, nil
+}
+
+func findFocusedSwayNode(node *swayNode) *swayNode {
+	if node.Focused {
+		return node
+	}
+
+	for _, children := range [][]swayNode{node.Nodes, node.FloatingNodes} {
+		for idx := range children {
+			if focused := findFocusedSwayNode(&children[idx]); focused != nil {
+				return focused
+			}
+		}
+	}
+
+	return nil
+}
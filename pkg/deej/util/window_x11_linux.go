@@ -0,0 +1,111 @@
+package util
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// x11Backend asks the X server's window manager which window is active (through EWMH's _NET_ACTIVE_WINDOW),
+// and that window which process it belongs to (through _NET_WM_PID)
+type x11Backend struct {
+	conn *xgb.Conn
+	root xproto.Window
+
+	activeWindowAtom xproto.Atom
+	pidAtom          xproto.Atom
+}
+
+func (b *x11Backend) available() bool {
+	return os.Getenv("DISPLAY") != ""
+}
+
+func (b *x11Backend) activeWindowPID() (int, error) {
+	if b.conn == nil {
+		if err := b.connect(); err != nil {
+			return 0, err
+		}
+	}
+
+	pid, err := b.queryActiveWindowPID()
+	if err != nil {
+
+		// the connection might be broken (i.e. the X server restarted), so start over on the next call
+		b.conn.Close()
+		b.conn = nil
+
+		return 0, err
+	}
+
+	return pid, nil
+}
+
+func (b *x11Backend) connect() error {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return fmt.Errorf("connect to X server: %w", err)
+	}
+
+	activeWindowAtom, err := internAtom(conn, "_NET_ACTIVE_WINDOW")
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	pidAtom, err := internAtom(conn, "_NET_WM_PID")
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	b.conn = conn
+	b.root = xproto.Setup(conn).DefaultScreen(conn).Root
+	b.activeWindowAtom = activeWindowAtom
+	b.pidAtom = pidAtom
+
+	return nil
+}
+
+func (b *x11Backend) queryActiveWindowPID() (int, error) {
+	activeWindow, err := b.getCardinalProperty(b.root, b.activeWindowAtom, xproto.AtomWindow)
+	if err != nil {
+		return 0, fmt.Errorf("get active window: %w", err)
+	}
+
+	// nothing is focused, i.e. when on an empty desktop
+	if activeWindow == 0 {
+		return 0, nil
+	}
+
+	pid, err := b.getCardinalProperty(xproto.Window(activeWindow), b.pidAtom, xproto.AtomCardinal)
+	if err != nil {
+		return 0, fmt.Errorf("get active window pid: %w", err)
+	}
+
+	return int(pid), nil
+}
+
+// getCardinalProperty reads a single 32-bit value of a window property, returning 0 if the property isn't set
+func (b *x11Backend) getCardinalProperty(window xproto.Window, property xproto.Atom, propertyType xproto.Atom) (uint32, error) {
+	reply, err := xproto.GetProperty(b.conn, false, window, property, propertyType, 0, 1).Reply()
+	if err != nil {
+		return 0, err
+	}
+
+	if reply == nil || reply.Format != 32 || len(reply.Value) < 4 {
+		return 0, nil
+	}
+
+	return xgb.Get32(reply.Value), nil
+}
+
+func internAtom(conn *xgb.Conn, name string) (xproto.Atom, error) {
+	reply, err := xproto.InternAtom(conn, true, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("intern atom %s: %w", name, err)
+	}
+
+	return reply.Atom, nil
+}
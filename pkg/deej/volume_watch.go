@@ -0,0 +1,180 @@
+package deej
+
+import (
+	"math"
+	"time"
+)
+
+// volumes don't only change through deej - media keys, the OS mixer and apps themselves all move them too.
+// the session map keeps an eye on the volumes of each slider's sessions so that it can tell the board about them
+// (see SerialIO.SendLevels), move its motorized faders to match them (see SerialIO.MoveSlider) and so that,
+// with soft takeover on, a slider that's out of sync with its sessions doesn't yank them back the moment it jitters
+
+const (
+
+	// how often the volumes of each slider's sessions are looked at
+	volumeWatchInterval = 250 * time.Millisecond
+
+	// a session's volume has to be this far from its slider's for the difference to count as a change made elsewhere
+	// (rather than as the audio server rounding what we set)
+	externalVolumeChangeThreshold = 0.015
+
+	// right after a slider moves, its sessions might not have caught up yet, so they're left alone for a moment
+	sliderMoveSettleTime = time.Second
+
+	// how close to a volume set elsewhere a slider has to get to take over again, if it doesn't cross it outright
+	softTakeoverTolerance = 0.02
+)
+
+func (m *sessionMap) setupVolumeWatch() {
+	ticker := time.NewTicker(volumeWatchInterval)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+
+				// performance: don't bother the audio server if nobody's interested in what it says
+				watched := m.deej.config.softTakeover() ||
+					m.deej.serial.boardSupports(capabilityLevels) ||
+					m.deej.serial.boardSupports(capabilityMotors)
+
+				if !watched {
+					continue
+				}
+
+				m.watchVolumes()
+			}
+		}
+	}()
+}
+
+// watchVolumes looks at the current volume of every mapped slider's sessions (that is, of the first of them),
+// picking up on changes made outside of deej and reporting all of them to the board
+func (m *sessionMap) watchVolumes() {
+	levels := map[int]float32{}
+
+	for _, sliderID := range m.mappedSliderIDs() {
+		sessions, _ := m.getSliderSessions(sliderID)
+		if len(sessions) == 0 {
+			continue
+		}
+
+		level := sessions[0].GetVolume()
+		levels[sliderID] = level
+
+		m.checkExternalVolumeChange(sliderID, level)
+	}
+
+	m.deej.serial.SendLevels(levels)
+}
+
+// checkExternalVolumeChange compares a slider's session volume with the volume the slider last set. if they differ,
+// the session's volume becomes the slider's (so that sessions appearing later get it too) and its fader is moved
+// to match it, if it's motorized. with soft takeover on, the slider is also disengaged until it gets there
+func (m *sessionMap) checkExternalVolumeChange(sliderID int, level float32) {
+	m.takeoverLock.Lock()
+	moved := m.sliderMoveTimes[sliderID]
+	m.takeoverLock.Unlock()
+
+	if time.Since(moved) < sliderMoveSettleTime {
+		return
+	}
+
+	m.sliderValuesLock.Lock()
+	value, ok := m.sliderValues[sliderID]
+
+	// sliders that haven't moved yet haven't set anything that could be changed
+	if !ok || math.Abs(float64(level-value)) <= externalVolumeChangeThreshold {
+		m.sliderValuesLock.Unlock()
+		return
+	}
+
+	m.sliderValues[sliderID] = level
+	m.sliderValuesLock.Unlock()
+
+	m.logger.Debugw("Detected volume change made outside of deej", "sliderID", sliderID, "from", value, "to", level)
+
+	position := m.deej.config.sliderCurve(sliderID).invert(level)
+	m.deej.serial.MoveSlider(sliderID, position)
+
+	if !m.deej.config.softTakeover() {
+		return
+	}
+
+	m.takeoverLock.Lock()
+	m.takeoverPositions[sliderID] = position
+	m.takeoverLock.Unlock()
+
+	m.logger.Debugw("Slider disengaged until it reaches the new volume", "sliderID", sliderID, "position", position)
+}
+
+// moveFaders moves every motorized fader to match its sessions' current volume, which then becomes the slider's own.
+// this is how boards with motors keep up with changes to what their sliders are mapped to
+func (m *sessionMap) moveFaders() {
+	if !m.deej.serial.boardSupports(capabilityMotors) {
+		return
+	}
+
+	for _, sliderID := range m.mappedSliderIDs() {
+		sessions, _ := m.getSliderSessions(sliderID)
+		if len(sessions) == 0 {
+			continue
+		}
+
+		level := sessions[0].GetVolume()
+
+		m.sliderValuesLock.Lock()
+		m.sliderValues[sliderID] = level
+		m.sliderValuesLock.Unlock()
+
+		// the fader's about to be where it needs to be, so there's nothing left to take over
+		m.takeoverLock.Lock()
+		delete(m.takeoverPositions, sliderID)
+		m.takeoverLock.Unlock()
+
+		m.deej.serial.MoveSlider(sliderID, m.deej.config.sliderCurve(sliderID).invert(level))
+	}
+}
+
+// mappedSliderIDs returns the index of every slider that's mapped to something. the mapping's lock is only held
+// while collecting them, since resolving their sessions needs it too
+func (m *sessionMap) mappedSliderIDs() []int {
+	sliderIDs := []int{}
+	m.deej.config.sliderMapping().iterate(func(sliderID int, _ []string) {
+		sliderIDs = append(sliderIDs, sliderID)
+	})
+
+	return sliderIDs
+}
+
+// sliderEngaged records the slider's new position and tells whether it should be applied to its sessions.
+// with soft takeover on, sliders disengaged by a volume change made elsewhere are ignored until they cross it
+func (m *sessionMap) sliderEngaged(event SliderMoveEvent) bool {
+	m.takeoverLock.Lock()
+	defer m.takeoverLock.Unlock()
+
+	previous, known := m.sliderPositions[event.SliderID]
+	m.sliderPositions[event.SliderID] = event.PercentValue
+
+	target, disengaged := m.takeoverPositions[event.SliderID]
+	if !disengaged {
+		m.sliderMoveTimes[event.SliderID] = time.Now()
+		return true
+	}
+
+	// the slider's either right there, or it jumped past the target between two lines
+	reached := math.Abs(float64(event.PercentValue-target)) <= softTakeoverTolerance
+	crossed := known && (previous-target)*(event.PercentValue-target) < 0
+
+	if !reached && !crossed && m.deej.config.softTakeover() {
+		return false
+	}
+
+	delete(m.takeoverPositions, event.SliderID)
+	m.sliderMoveTimes[event.SliderID] = time.Now()
+
+	m.logger.Debugw("Slider took over again", "sliderID", event.SliderID, "position", event.PercentValue)
+
+	return true
+}